@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
-	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
 
 const (
@@ -23,6 +25,7 @@ var (
 	app        = kingpin.New(prefix, "Command line client to ytb-be.")
 	remoteHost = app.Flag("host", "Address of remote ytb-be service.").Default("127.0.0.1").Short('h').String()
 	remotePort = app.Flag("port", "Port of remote ytb-be service.").Default("9009").Short('p').String()
+	roomId     = app.Flag("room", "Id of the room to act on.").Default("1").Short('r').Uint32()
 
 	// "playlist" subcommand
 	playlist = app.Command("playlist", "Get current songs in the playlist.").Alias("ls")
@@ -42,6 +45,20 @@ var (
 	// "pause" subcommand
 	pause = app.Command("pause", "Toggle pause state of the player.")
 
+	// "seek" subcommand
+	seek         = app.Command("seek", "Seek the current song. Pass -- before negative values.")
+	seekPosition = seek.Arg("seconds", "Position in seconds to seek to. Prefix with + or - to seek relative to the current position.").Required().String()
+
+	// "volume" subcommand
+	volume      = app.Command("volume", "Set the volume of the players.")
+	volumeLevel = volume.Arg("level", "Volume level from 0 to 100.").Required().Float64()
+
+	// "mute" subcommand
+	mute = app.Command("mute", "Toggle mute state of the players.")
+
+	// "restart" subcommand
+	restart = app.Command("restart", "Play the current song again from the start.")
+
 	// "pop" subcommand
 	pop = app.Command("pop", "Pop a song off the top of the queue.")
 
@@ -50,6 +67,13 @@ var (
 	removeSong = remove.Arg("songId", "Id of the song to remove.").Required().Uint32()
 	removeUser = remove.Arg("userId", "Id of the user who subitted the song.").Required().Uint32()
 
+	// "move" subcommand
+	move          = app.Command("move", "Move a song among the songs queued by the same user.").Alias("mv")
+	moveSong      = move.Arg("songId", "Id of the song to move.").Required().Uint32()
+	moveUser      = move.Arg("userId", "Id of the user who submitted the song.").Required().Uint32()
+	moveDirection = move.Arg("direction", "Direction to move the song in.").Required().Enum("up", "down")
+	moveTurns     = move.Arg("turns", "Number of the user's turns to move the song by.").Default("1").Int32()
+
 	// "save" subcommand
 	save     = app.Command("save", "Save the current playlist to a file.")
 	saveFile = save.Arg("file", "File name to write playlist to").Required().String()
@@ -58,13 +82,44 @@ var (
 	send     = app.Command("send", "send a link to the queue.")
 	sendLink = send.Arg("link", "Link to song.").Required().String()
 	sendUser = send.Arg("user", "User id to send link under.").Required().Uint32()
+	sendList = send.Flag("playlist", "Queue every song of a playlist link.").Bool()
+
+	// "search" subcommand
+	search     = app.Command("search", "Search for songs to send to the queue.")
+	searchText = search.Arg("text", "Text to search for.").Required().String()
+	searchUser = search.Arg("user", "User id to search as.").Required().Uint32()
+
+	// "library" subcommand
+	library       = app.Command("library", "Browse the local music library by artist and album.")
+	libraryArtist = library.Arg("artist", "Artist to list the albums of.").String()
+	libraryAlbum  = library.Arg("album", "Album of the artist to list the tracks of.").String()
+
+	// "findTrack" subcommand
+	findTrack     = app.Command("findTrack", "Search the local music library by artist, album and title.")
+	findTrackText = findTrack.Arg("text", "Text to search for.").Required().String()
 
 	// "newRoom" subcommand
-	newRoom  = app.Command("newRoom", "Creates a new room.")
-	roomName = newRoom.Arg("name", "Name of the room.").Required().String()
+	newRoom         = app.Command("newRoom", "Creates a new room.")
+	roomName        = newRoom.Arg("name", "Name of the room.").Required().String()
+	newRoomStrategy = newRoom.Arg("strategy", "Queuing strategy of the room (fifo, round-robin, duration-fair, voting).").String()
 
 	getRoom     = app.Command("getRoom", "Query for a room by name.")
 	getRoomName = getRoom.Arg("name", "Name of the room.").Required().String()
+
+	// "strategy" subcommand
+	strategy         = app.Command("strategy", "Switch the queuing strategy of a room.")
+	strategyRoomName = strategy.Arg("name", "Name of the room.").Required().String()
+	strategyName     = strategy.Arg("strategy", "Queuing strategy to switch to (fifo, round-robin, duration-fair, voting).").Required().String()
+
+	// "admin" subcommand
+	admin         = app.Command("admin", "Set the user who controls the players of a room.")
+	adminRoomName = admin.Arg("name", "Name of the room.").Required().String()
+	adminUser     = admin.Arg("userId", "Id of the user to make admin. Zero removes the admin.").Required().Uint32()
+
+	// "maxDuration" subcommand
+	maxDuration         = app.Command("maxDuration", "Set the longest song that can be submitted to a room.")
+	maxDurationRoomName = maxDuration.Arg("name", "Name of the room.").Required().String()
+	maxDurationLength   = maxDuration.Arg("duration", "Longest song allowed, e.g. 10m or 4m30s. Zero allows songs of any length.").Required().Duration()
 )
 
 /*
@@ -92,23 +147,91 @@ func connectToRemote() (*grpc.ClientConn, bepb.YtbBackendClient) {
  */
 func sendCommand(client bepb.YtbBackendClient) {
 	link := *sendLink
-	_, err := client.SendSong(context.Background(), &bepb.Submission{
-		Link:   link,
-		UserId: *sendUser,
+	response, err := client.SendSong(context.Background(), &bepb.Submission{
+		Link:           link,
+		UserId:         *sendUser,
+		ExpandPlaylist: *sendList,
 	})
 	if err != nil {
 		fmt.Printf("failed to call SendSong: %v\n", err)
 		os.Exit(1)
 	}
 
+	if *sendList {
+		fmt.Println(response.Message)
+		return
+	}
+
 	fmt.Println(link)
 }
 
+/*
+ * Handler to search for songs
+ */
+func searchCommand(client bepb.YtbBackendClient) {
+	results, err := client.SearchSongs(context.Background(), &bepb.SearchQuery{
+		Text:   *searchText,
+		UserId: *searchUser,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SearchSongs: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i, song := range results.Songs {
+		fmt.Printf("%3d. { id: %s, length: %4ds, title: %s }\n", i+1, song.ServiceId, song.Duration, song.Title)
+	}
+}
+
+/*
+ * Handler to browse the local music library
+ */
+func libraryCommand(client bepb.YtbBackendClient) {
+	listing, err := client.BrowseLibrary(context.Background(), &bepb.LibraryBrowse{
+		Artist: *libraryArtist,
+		Album:  *libraryAlbum,
+	})
+	if err != nil {
+		fmt.Printf("failed to call BrowseLibrary: %v\n", err)
+		os.Exit(1)
+	}
+
+	printListing(listing)
+}
+
+/*
+ * Handler to search the local music library
+ */
+func findTrackCommand(client bepb.YtbBackendClient) {
+	listing, err := client.SearchLibrary(context.Background(), &bepb.SearchQuery{Text: *findTrackText})
+	if err != nil {
+		fmt.Printf("failed to call SearchLibrary: %v\n", err)
+		os.Exit(1)
+	}
+
+	printListing(listing)
+}
+
+func printListing(listing *bepb.LibraryListing) {
+	for _, artist := range listing.Artists {
+		fmt.Println(artist)
+	}
+
+	for _, album := range listing.Albums {
+		fmt.Println(album)
+	}
+
+	for _, track := range listing.Tracks {
+		fmt.Printf("%3d. { artist: %s, album: %s, title: %s, length: %ds, path: %s }\n",
+			track.Track, track.Artist, track.Album, track.Title, track.Duration, track.Path)
+	}
+}
+
 /*
  * Handler to list the songs in the playlist
  */
 func playlistCommand(client bepb.YtbBackendClient) {
-	playlist, err := client.GetPlaylist(context.Background(), &cmpb.Empty{})
+	playlist, err := client.GetPlaylist(context.Background(), &bepb.User{RoomId: *roomId})
 	if err != nil {
 		fmt.Printf("failed to call GetPlaylist: %v\n", err)
 		os.Exit(1)
@@ -124,7 +247,7 @@ func playlistCommand(client bepb.YtbBackendClient) {
  * Tell the backend server to save the current playlist to a file
  */
 func saveCommand(client bepb.YtbBackendClient) {
-	response, err := client.SavePlaylist(context.Background(), &bepb.FilePath{Path: *saveFile})
+	response, err := client.SavePlaylist(context.Background(), &bepb.FilePath{Path: *saveFile, RoomId: *roomId})
 	if err != nil {
 		fmt.Printf("failed to call GetPlaylist: %v\n", err)
 		os.Exit(1)
@@ -134,7 +257,7 @@ func saveCommand(client bepb.YtbBackendClient) {
 }
 
 func popCommand(client bepb.YtbBackendClient) {
-	song, err := client.PopQueue(context.Background(), &cmpb.Empty{})
+	song, err := client.PopQueue(context.Background(), &bepb.User{RoomId: *roomId})
 	if err != nil {
 		fmt.Printf("failed to call PopQueue: %v\n", err)
 		os.Exit(1)
@@ -169,23 +292,46 @@ func removeCommand(client bepb.YtbBackendClient) {
 	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
 }
 
+func moveCommand(client bepb.YtbBackendClient) {
+	offset := *moveTurns
+	if *moveDirection == "up" {
+		offset = -offset
+	}
+
+	response, err := client.MoveSong(context.Background(), &bepb.Move{SongId: *moveSong, UserId: *moveUser, Offset: offset})
+	if err != nil {
+		fmt.Printf("failed to call MoveSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
 func nowCommand(client bepb.YtbBackendClient) {
-	song, err := client.GetNowPlaying(context.Background(), &cmpb.Empty{})
+	playback, err := client.GetPlayback(context.Background(), &bepb.User{RoomId: *roomId})
 	if err != nil {
-		fmt.Printf("failed to call GetNowPlaying: %v\n", err)
+		fmt.Printf("failed to call GetPlayback: %v\n", err)
 		os.Exit(1)
 	}
 
-	if song.SongId == 0 {
+	song := playback.GetSong()
+	if song.GetSongId() == 0 {
 		fmt.Println("No song is currently playing")
 	} else {
-		fmt.Printf("Now Playing: { id: %2d, user: %2d, title: %s }\n",
-			song.SongId, song.UserId, song.Title)
+		fmt.Printf("Now Playing: { id: %2d, user: %2d, title: %s, position: %s / %s, paused: %t }\n",
+			song.SongId, song.UserId, song.Title, seconds(playback.Position), seconds(playback.Duration), playback.Paused)
 	}
 }
 
+/*
+ * Converts a number of seconds into a duration rounded to the second
+ */
+func seconds(value float64) time.Duration {
+	return (time.Duration(value * float64(time.Second))).Round(time.Second)
+}
+
 func nextCommand(client bepb.YtbBackendClient) {
-	response, err := client.NextSong(context.Background(), &cmpb.Empty{})
+	response, err := client.NextSong(context.Background(), &bepb.User{RoomId: *roomId})
 	if err != nil {
 		fmt.Printf("failed to call NextSong: %v\n", err)
 		os.Exit(1)
@@ -195,7 +341,7 @@ func nextCommand(client bepb.YtbBackendClient) {
 }
 
 func pauseCommand(client bepb.YtbBackendClient) {
-	response, err := client.PauseSong(context.Background(), &cmpb.Empty{})
+	response, err := client.PauseSong(context.Background(), &bepb.User{RoomId: *roomId})
 	if err != nil {
 		fmt.Printf("failed to call PauseSong: %v\n", err)
 		os.Exit(1)
@@ -204,19 +350,75 @@ func pauseCommand(client bepb.YtbBackendClient) {
 	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
 }
 
+func seekCommand(client bepb.YtbBackendClient) {
+	relative := strings.HasPrefix(*seekPosition, "+") || strings.HasPrefix(*seekPosition, "-")
+	seconds, err := strconv.ParseFloat(*seekPosition, 64)
+	if err != nil {
+		fmt.Printf("invalid number of seconds: %s\n", *seekPosition)
+		os.Exit(1)
+	}
+
+	response, err := client.SeekSong(context.Background(), &bepb.SeekPosition{
+		User:     &bepb.User{RoomId: *roomId},
+		Seconds:  seconds,
+		Relative: relative,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SeekSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func volumeCommand(client bepb.YtbBackendClient) {
+	response, err := client.SetVolume(context.Background(), &bepb.VolumeLevel{User: &bepb.User{RoomId: *roomId}, Level: *volumeLevel})
+	if err != nil {
+		fmt.Printf("failed to call SetVolume: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func muteCommand(client bepb.YtbBackendClient) {
+	response, err := client.MuteSong(context.Background(), &bepb.User{RoomId: *roomId})
+	if err != nil {
+		fmt.Printf("failed to call MuteSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func restartCommand(client bepb.YtbBackendClient) {
+	response, err := client.RestartSong(context.Background(), &bepb.User{RoomId: *roomId})
+	if err != nil {
+		fmt.Printf("failed to call RestartSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func adminCommand(client bepb.YtbBackendClient) {
+	room, err := client.SetRoomAdmin(context.Background(), &bepb.Room{Name: *adminRoomName, AdminId: *adminUser})
+	if err != nil {
+		fmt.Printf("failed to call SetRoomAdmin: %v\n", err)
+		os.Exit(1)
+	}
+
+	printRoom(room)
+}
+
 func newRoomCommand(client bepb.YtbBackendClient) {
-	room, err := client.CreateRoom(context.Background(), &bepb.Room{Name: *roomName})
+	room, err := client.CreateRoom(context.Background(), &bepb.Room{Name: *roomName, Strategy: *newRoomStrategy})
 	if err != nil {
 		fmt.Printf("failed to call CreateRoom: %v\n", err)
 		os.Exit(1)
 	}
 
-	if room.Err.Success == false {
-		fmt.Println(room.Err.Message)
-	} else {
-		fmt.Printf("Room name: %s\n", room.Name)
-		fmt.Printf("Room id: %2d\n", room.Id)
-	}
+	printRoom(room)
 }
 
 func getRoomCommand(client bepb.YtbBackendClient) {
@@ -226,11 +428,52 @@ func getRoomCommand(client bepb.YtbBackendClient) {
 		os.Exit(1)
 	}
 
+	printRoom(room)
+}
+
+func strategyCommand(client bepb.YtbBackendClient) {
+	room, err := client.SetRoomStrategy(context.Background(), &bepb.Room{Name: *strategyRoomName, Strategy: *strategyName})
+	if err != nil {
+		fmt.Printf("failed to call SetRoomStrategy: %v\n", err)
+		os.Exit(1)
+	}
+
+	printRoom(room)
+}
+
+func maxDurationCommand(client bepb.YtbBackendClient) {
+	if *maxDurationLength < 0 {
+		fmt.Println("The duration can't be negative")
+		os.Exit(1)
+	}
+
+	seconds := uint32(maxDurationLength.Seconds())
+	room, err := client.SetRoomMaxDuration(context.Background(), &bepb.Room{Name: *maxDurationRoomName, MaxDuration: seconds})
+	if err != nil {
+		fmt.Printf("failed to call SetRoomMaxDuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	printRoom(room)
+}
+
+func printRoom(room *bepb.Room) {
 	if room.Err.Success == false {
 		fmt.Println(room.Err.Message)
 	} else {
 		fmt.Printf("Room name: %s\n", room.Name)
 		fmt.Printf("Room id: %2d\n", room.Id)
+		fmt.Printf("Strategy: %s\n", room.Strategy)
+		if room.MaxDuration == 0 {
+			fmt.Println("Max duration: none")
+		} else {
+			fmt.Printf("Max duration: %s\n", time.Duration(room.MaxDuration)*time.Second)
+		}
+		if room.AdminId == 0 {
+			fmt.Println("Admin: none")
+		} else {
+			fmt.Printf("Admin: %d\n", room.AdminId)
+		}
 	}
 }
 
@@ -248,6 +491,15 @@ func main() {
 	case send.FullCommand():
 		sendCommand(client)
 
+	case search.FullCommand():
+		searchCommand(client)
+
+	case library.FullCommand():
+		libraryCommand(client)
+
+	case findTrack.FullCommand():
+		findTrackCommand(client)
+
 	case playlist.FullCommand():
 		playlistCommand(client)
 
@@ -263,18 +515,42 @@ func main() {
 	case remove.FullCommand():
 		removeCommand(client)
 
+	case move.FullCommand():
+		moveCommand(client)
+
 	case next.FullCommand():
 		nextCommand(client)
 
 	case pause.FullCommand():
 		pauseCommand(client)
 
+	case seek.FullCommand():
+		seekCommand(client)
+
+	case volume.FullCommand():
+		volumeCommand(client)
+
+	case mute.FullCommand():
+		muteCommand(client)
+
+	case restart.FullCommand():
+		restartCommand(client)
+
+	case admin.FullCommand():
+		adminCommand(client)
+
 	case newRoom.FullCommand():
 		newRoomCommand(client)
 
 	case getRoom.FullCommand():
 		getRoomCommand(client)
 
+	case strategy.FullCommand():
+		strategyCommand(client)
+
+	case maxDuration.FullCommand():
+		maxDurationCommand(client)
+
 	default:
 		nowCommand(client)
 	}
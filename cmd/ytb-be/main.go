@@ -13,6 +13,7 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/nguyenmq/ytbox-go/internal/backend"
+	queuer "github.com/nguyenmq/ytbox-go/internal/backend/song_queuer"
 	"github.com/nguyenmq/ytbox-go/internal/common"
 )
 
@@ -26,6 +27,16 @@ var (
 	loadFile  = app.Flag("load", "Load a serialized protobuf playlist from a file").Short('l').ExistingFile()
 	dbFile    = app.Flag("database", "Path to database").Default("./ytbox.db").Short('d').String()
 	ytApiFile = app.Flag("apiKey", "Path to file containing YouTube api key").String()
+	readyWait = app.Flag("ready-timeout", "How long to wait for every player in a room to be ready before moving on without the slow ones").Default("10s").Duration()
+	reconnect = app.Flag("reconnect-grace", "How long to hold the song of a room after its last player disconnects. Zero gives up on the song right away").Default("2m").Duration()
+	startWait = app.Flag("start-delay", "How far ahead songs are scheduled to start so that every player in a room starts them together. Zero starts songs as soon as they arrive").Default("2s").Duration()
+	skipShare = app.Flag("skip-share", "Share of a room's active users who must vote to skip a song").Default("0.5").Float64()
+	cooldown  = app.Flag("cooldown", "How long after a song plays before it can be submitted to the room again. Zero turns off the cooldown").Default("1h").Duration()
+	queueCap  = app.Flag("playlist-cap", "Most songs a user can have queued in a room after submitting a playlist. Zero turns off playlists").Default("10").Int()
+	libraries = app.Flag("library", "Directory of the local music library. Repeat to add more directories").ExistingDirs()
+	cliAccess = app.Flag("cli-control", "Let requests that name a room without a user, like those from ytb-be-cli, control its players and change its settings").Bool()
+	rescan    = app.Flag("library-rescan", "How often to scan the local music library for changes. Zero only scans it on start up").Default("10m").Duration()
+	strategy  = app.Flag("queuer", "Queuing strategy that decides the order songs are played in").Default(string(queuer.RoundRobinStrategy)).Enum(queuer.StrategyNames()...)
 )
 
 func main() {
@@ -40,6 +51,11 @@ func main() {
 		addr = "0.0.0.0"
 	}
 
+	if *skipShare <= 0 || *skipShare > 1 {
+		log.Printf("Skip share must be greater than 0 and at most 1, but was %v\n", *skipShare)
+		os.Exit(1)
+	}
+
 	ytApiKeyString := ""
 	if *ytApiFile != "" {
 		ytApiKey, err := ioutil.ReadFile(*ytApiFile)
@@ -51,7 +67,22 @@ func main() {
 		ytApiKeyString = string(ytApiKey)
 	}
 
-	ytbServer := backend.NewServer(addr+":"+*port, *loadFile, *dbFile, ytApiKeyString)
+	ytbServer := backend.NewServer(backend.ServerConfig{
+		Addr:           addr + ":" + *port,
+		LoadFile:       *loadFile,
+		DbPath:         *dbFile,
+		YtApiKey:       ytApiKeyString,
+		ReadyTimeout:   *readyWait,
+		ReconnectGrace: *reconnect,
+		StartDelay:     *startWait,
+		Strategy:       queuer.Strategy(*strategy),
+		SkipShare:      *skipShare,
+		Cooldown:       *cooldown,
+		PlaylistCap:    *queueCap,
+		LibraryDirs:    *libraries,
+		LibraryRescan:  *rescan,
+		CliControl:     *cliAccess,
+	})
 
 	go func() {
 		stop := make(chan os.Signal)
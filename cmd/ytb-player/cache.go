@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+const (
+	fetchedBuffer = 8 // finished downloads that can be waiting on the interaction loop
+)
+
+// YouTube ids are safe to use in file names as long as they look like this
+var cacheableId = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+/*
+ * Keeps local copies of YouTube songs so that they play without streaming.
+ * Songs coming up next are downloaded with yt-dlp ahead of time. The least
+ * recently played files are removed once the cache grows past its size limit.
+ */
+type mediaCache struct {
+	dir      string
+	maxBytes int64
+	format   string // yt-dlp format of the downloads
+	lock     sync.Mutex
+	pending  map[string]bool // keys of the songs being downloaded
+	upcoming string          // key of the song coming up next
+	fetched  chan uint32     // ids of the songs whose download finished
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+/*
+ * Open the cache in the given directory, creating the directory if needed.
+ * Downloads left unfinished by an earlier run are removed.
+ */
+func newMediaCache(dir string, maxBytes int64, audioOnly bool) (*mediaCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cache := new(mediaCache)
+	cache.dir = dir
+	cache.maxBytes = maxBytes
+	cache.format = "best"
+	if audioOnly {
+		cache.format = "bestaudio/best"
+	}
+	cache.pending = make(map[string]bool)
+	cache.fetched = make(chan uint32, fetchedBuffer)
+	cache.ctx, cache.cancel = context.WithCancel(context.Background())
+
+	cache.removePartials("*")
+	return cache, nil
+}
+
+/*
+ * Returns the path of the song's file if it's in the cache. The file counts as
+ * recently played so that it's the last to be evicted.
+ */
+func (c *mediaCache) Lookup(song *cmpb.Song) (string, bool) {
+	key, ok := cacheKey(song)
+	if c == nil || !ok {
+		return "", false
+	}
+
+	path, ok := c.find(key)
+	if !ok {
+		return "", false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+/*
+ * Start downloading the song into the cache unless it's already there or on
+ * its way. Its id is sent on Fetched once the download finishes.
+ */
+func (c *mediaCache) Prefetch(song *cmpb.Song) {
+	key, ok := cacheKey(song)
+	if c == nil || !ok {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.upcoming = key
+	if _, cached := c.find(key); cached || c.pending[key] {
+		return
+	}
+
+	c.pending[key] = true
+	c.wg.Add(1)
+	go c.download(key, song)
+}
+
+/*
+ * Ids of the songs whose download finished. Never fires if there is no cache.
+ */
+func (c *mediaCache) Fetched() <-chan uint32 {
+	if c == nil {
+		return nil
+	}
+
+	return c.fetched
+}
+
+/*
+ * Stop the downloads in progress and remove what they left behind
+ */
+func (c *mediaCache) Close() {
+	if c == nil {
+		return
+	}
+
+	c.cancel()
+	c.wg.Wait()
+	c.removePartials("*")
+}
+
+/*
+ * Download the song with yt-dlp and then make room for it
+ */
+func (c *mediaCache) download(key string, song *cmpb.Song) {
+	defer c.wg.Done()
+
+	link, _ := buildSongLink(song)
+	output := filepath.Join(c.dir, key+".%(ext)s")
+	// --no-mtime keeps the upload date from making a fresh download the first to be evicted
+	cmd := exec.CommandContext(c.ctx, "yt-dlp", "--quiet", "--no-playlist", "--no-progress",
+		"--no-mtime", "-f", c.format, "-o", output, "--", link)
+
+	start := time.Now()
+	err := cmd.Run()
+
+	c.lock.Lock()
+	delete(c.pending, key)
+	c.lock.Unlock()
+
+	if err != nil {
+		fmt.Printf("Failed to cache %s: %v\n", link, err)
+		c.removePartials(key)
+		return
+	}
+
+	fmt.Printf("Cached %s in %v\n", link, time.Since(start).Round(time.Second))
+	c.evict()
+
+	select {
+	case c.fetched <- song.GetSongId():
+	default:
+	}
+}
+
+/*
+ * Remove the least recently played files until the cache fits in its size
+ * limit. Downloads in progress don't count towards the size. The files of the
+ * songs being downloaded or coming up next are never removed.
+ */
+func (c *mediaCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		fmt.Printf("Failed to read cache directory: %v\n", err)
+		return
+	}
+
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []cachedFile
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || isPartial(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, cachedFile{filepath.Join(c.dir, entry.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	// a file that is playing can be removed, the player keeps it open
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, file := range files {
+		if total <= c.maxBytes {
+			break
+		}
+
+		// the song coming up next is kept even if the cache stays too big
+		key := strings.TrimSuffix(filepath.Base(file.path), filepath.Ext(file.path))
+		if c.pending[key] || key == c.upcoming {
+			continue
+		}
+
+		if err := os.Remove(file.path); err != nil {
+			fmt.Printf("Failed to evict %s: %v\n", file.path, err)
+			continue
+		}
+
+		fmt.Printf("Evicted %s from the cache\n", filepath.Base(file.path))
+		total -= file.size
+	}
+}
+
+/*
+ * Returns the path of the finished download with the given key
+ */
+func (c *mediaCache) find(key string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(c.dir, key+".*"))
+	for _, match := range matches {
+		if !isPartial(match) {
+			return match, true
+		}
+	}
+
+	return "", false
+}
+
+/*
+ * Remove the unfinished downloads of the songs matching the key pattern
+ */
+func (c *mediaCache) removePartials(pattern string) {
+	matches, _ := filepath.Glob(filepath.Join(c.dir, pattern+".*"))
+	for _, match := range matches {
+		if isPartial(match) {
+			os.Remove(match)
+		}
+	}
+}
+
+/*
+ * Returns the name a song is kept under in the cache. Only YouTube songs are
+ * cached.
+ */
+func cacheKey(song *cmpb.Song) (string, bool) {
+	if song.GetService() != cmpb.ServiceType_Youtube || !cacheableId.MatchString(song.GetServiceId()) {
+		return "", false
+	}
+
+	return "youtube-" + song.GetServiceId(), true
+}
+
+/*
+ * Returns true for the files that yt-dlp writes while a download is in
+ * progress
+ */
+func isPartial(name string) bool {
+	return strings.HasSuffix(name, ".part") ||
+		strings.HasSuffix(name, ".ytdl") ||
+		strings.Contains(name, ".part-Frag") ||
+		strings.Contains(name, ".temp.")
+}
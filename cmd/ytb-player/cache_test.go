@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+func newTestCache(t *testing.T, maxBytes int64) *mediaCache {
+	cache, err := newMediaCache(t.TempDir(), maxBytes, false)
+	if err != nil {
+		t.Fatal("Failed to open the cache:", err)
+	}
+
+	return cache
+}
+
+/*
+ * Write a file of the given size into the cache that was last played the
+ * given time ago
+ */
+func writeCachedFile(t *testing.T, cache *mediaCache, name string, size int, age time.Duration) string {
+	path := filepath.Join(cache.dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal("Failed to write file:", err)
+	}
+
+	played := time.Now().Add(-age)
+	os.Chtimes(path, played, played)
+	return path
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCacheKey_onlyCachesYoutube(t *testing.T) {
+	key, ok := cacheKey(&cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "dQw4w9WgXcQ"})
+	if !ok || key != "youtube-dQw4w9WgXcQ" {
+		t.Error("Expected youtube-dQw4w9WgXcQ but got", key, ok)
+	}
+
+	if _, ok := cacheKey(&cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "../../etc/passwd"}); ok {
+		t.Error("Expected an id that isn't safe in a file name not to be cached")
+	}
+
+	if _, ok := cacheKey(&cmpb.Song{Service: cmpb.ServiceType_Local, ServiceId: "/music/song.mp3"}); ok {
+		t.Error("Expected a local song not to be cached")
+	}
+}
+
+func TestIsPartial_matchesUnfinishedDownloads(t *testing.T) {
+	partials := []string{"youtube-a.webm.part", "youtube-a.webm.ytdl", "youtube-a.f251.webm.part-Frag3", "youtube-a.temp.webm"}
+	for _, name := range partials {
+		if !isPartial(name) {
+			t.Error("Expected an unfinished download:", name)
+		}
+	}
+
+	if isPartial("youtube-a.webm") {
+		t.Error("Expected a finished download not to be partial")
+	}
+}
+
+func TestRemovePartials_keepsFinishedDownloads(t *testing.T) {
+	cache := newTestCache(t, 1024)
+	finished := writeCachedFile(t, cache, "youtube-a.webm", 1, 0)
+	partial := writeCachedFile(t, cache, "youtube-a.webm.part", 1, 0)
+	other := writeCachedFile(t, cache, "youtube-b.webm.part", 1, 0)
+
+	cache.removePartials("youtube-a")
+	if !exists(finished) || exists(partial) || !exists(other) {
+		t.Error("Expected only the partial download of youtube-a to be removed")
+	}
+
+	cache.removePartials("*")
+	if !exists(finished) || exists(other) {
+		t.Error("Expected every partial download to be removed")
+	}
+}
+
+func TestEvict_removesLeastRecentlyPlayed(t *testing.T) {
+	cache := newTestCache(t, 250)
+	oldest := writeCachedFile(t, cache, "youtube-a.webm", 100, 3*time.Hour)
+	older := writeCachedFile(t, cache, "youtube-b.webm", 100, 2*time.Hour)
+	newest := writeCachedFile(t, cache, "youtube-c.webm", 100, 0)
+	partial := writeCachedFile(t, cache, "youtube-d.webm.part", 1000, 4*time.Hour)
+
+	cache.evict()
+	if exists(oldest) || !exists(older) || !exists(newest) || !exists(partial) {
+		t.Error("Expected only the least recently played file to be removed")
+	}
+}
+
+func TestEvict_whenUpcomingOrPending_keepsFiles(t *testing.T) {
+	cache := newTestCache(t, 150)
+	upcoming := writeCachedFile(t, cache, "youtube-a.webm", 100, 3*time.Hour)
+	pending := writeCachedFile(t, cache, "youtube-b.webm", 100, 2*time.Hour)
+	played := writeCachedFile(t, cache, "youtube-c.webm", 100, time.Hour)
+
+	cache.upcoming = "youtube-a"
+	cache.pending["youtube-b"] = true
+
+	cache.evict()
+	if !exists(upcoming) || !exists(pending) || exists(played) {
+		t.Error("Expected the upcoming and pending songs to be kept")
+	}
+}
@@ -4,17 +4,17 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"os/signal"
 	"time"
 
-	mpv "github.com/DexterLB/mpvipc"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/nguyenmq/ytbox-go/internal/common"
 	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+	"github.com/nguyenmq/ytbox-go/internal/provider"
 )
 
 /*
@@ -24,123 +24,31 @@ var (
 	app        = kingpin.New("ytb-player", "Command line client to play videos in the ytb-be queue")
 	remoteHost = app.Flag("host", "Address of remote ytb-be service").Default("127.0.0.1").Short('h').String()
 	remotePort = app.Flag("port", "Port of remote ytb-be service").Default("9009").Short('p').String()
+	room       = app.Flag("room", "Name of the room to play songs from").Short('r').Required().String()
 	continuous = app.Flag("cont", "Continuous play songs from the queue").Short('c').Bool()
 	audioDelay = app.Flag("audio-delay", "Delay audio within mpv by given number of seconds. See mpv manual for more info").Default("0.0").Short('d').String()
+	playerKind = app.Flag("player", "Player to play songs with: mpv, mpv-audio for mpv without a window, or null to only simulate playback").Default("mpv").Enum("mpv", "mpv-audio", "null")
+	cacheDir   = app.Flag("cache-dir", "Directory to download upcoming YouTube songs to so they play without streaming").String()
+	cacheSize  = app.Flag("cache-size", "Largest the cache directory may grow to in megabytes").Default("2048").Int64()
 )
 
-const (
-	mpvSocket = "./.mpvsocket"
-)
+// local copies of songs, nil when songs are streamed
+var songCache *mediaCache
 
-/*
- * Remote contoller to interface with mpv
- */
-type Remote struct {
-	conn *mpv.Connection
-}
+// the sources that songs come from
+var providers = provider.NewRegistry("")
 
-/*
- * Initialize the remote
- */
-func (r *Remote) Init(conn *mpv.Connection) {
-	r.conn = conn
-}
-
-/*
- * Load a song into mpv
- */
-func (r *Remote) LoadSong(name string, play bool) {
-	var err error
-	if play {
-		_, err = r.conn.Call("loadfile", name, "append-play")
-	} else {
-		_, err = r.conn.Call("loadfile", name, "append")
-	}
-
-	if err != nil {
-		fmt.Printf("Failed to load song: %v\n", err)
-	}
-}
-
-/*
- * Show the given text on mpv's OSD. Duration is given in milliseconds.
- */
-func (r *Remote) ShowText(text string, duration string) {
-	_, err := r.conn.Call("show-text", text, duration, 1)
-	if err != nil {
-		fmt.Printf("Failed to show text: %v\n", err)
-	}
-}
-
-/*
- * Toggle the pause state
- */
-func (r *Remote) TogglePause() {
-	_, err := r.conn.Call("cycle", "pause", "up")
-	if err != nil {
-		fmt.Printf("Failed to toggle pause: %v\n", err)
-	}
-}
-
-/*
- * Force pause to a certain state
- */
-func (r *Remote) ForcePause(state bool) {
-	_, err := r.conn.Call("set_property", "pause", state)
-	if err != nil {
-		fmt.Printf("Failed to pause: %v\n", err)
-	}
-}
-
-/*
- * Get the number of tracks in mpv's playlist
- */
-func (r *Remote) GetPlaylistCount() float64 {
-	count, err := r.conn.Get("playlist-count")
-	if err != nil {
-		fmt.Printf("Failed to pause: %v\n", err)
-		return 0
-	}
-
-	return count.(float64)
-}
-
-/*
- * Go to the next song
- */
-func (r *Remote) Next(name string) {
-	if name != "" {
-		r.LoadSong(name, true)
-	}
-
-	// a new player should have one track in the playlist. Players who are
-	// already playing should have two
-	if r.GetPlaylistCount() > 1.0 {
-		_, err := r.conn.Call("playlist-next", "force")
-		if err != nil {
-			fmt.Printf("Failed to go to next song: %v\n", err)
-		} else {
-			r.ForcePause(false)
-		}
-	}
-}
-
-/*
- * Tell mpv to quit
- */
-func (r *Remote) Quit() {
-	if !r.conn.IsClosed() {
-		_, err := r.conn.Call("quit")
-		if err != nil {
-			fmt.Printf("Failed to call quit: %v\n", err)
-		}
-	}
-}
+const (
+	progressInterval = time.Second      // how often playback progress is reported to the backend
+	minBackoff       = time.Second      // first wait before reconnecting to the backend
+	maxBackoff       = 30 * time.Second // longest wait between attempts to reconnect
+	syncInterval     = 10 * time.Second // how often the clock offset from the backend is sampled
+)
 
 /*
  * Connect to the remote server and create an RPC client
  */
-func connectToRemote() (*grpc.ClientConn, bepb.YtbBePlayer_SongPlayerClient) {
+func connectToRemote() (*grpc.ClientConn, bepb.YtbBePlayerClient, bepb.YtbBePlayer_SongPlayerClient) {
 	opts := []grpc.DialOption{}
 	opts = append(opts, grpc.WithInsecure())
 	opts = append(opts, grpc.WithBlock())
@@ -159,7 +67,7 @@ func connectToRemote() (*grpc.ClientConn, bepb.YtbBePlayer_SongPlayerClient) {
 		os.Exit(1)
 	}
 
-	return conn, stream
+	return conn, client, stream
 }
 
 /*
@@ -170,7 +78,7 @@ func receiveStatus(stream bepb.YtbBePlayer_SongPlayerClient, newStatus chan *bep
 		status, err := stream.Recv()
 
 		if err == io.EOF {
-			fmt.Println("Disconnected")
+			fmt.Println("Stream closed by server")
 			close(newStatus)
 			break
 		}
@@ -186,70 +94,231 @@ func receiveStatus(stream bepb.YtbBePlayer_SongPlayerClient, newStatus chan *bep
 }
 
 /*
- * Handle a new status message from the server.
+ * Handle a new status message from the server. Returns the song that is
+ * playing and the song that is preloaded afterwards.
  */
-func handleNewStatus(status *bepb.PlayerControl, remote *Remote) {
+func handleNewStatus(status *bepb.PlayerControl, remote *Remote, current *cmpb.Song, upcoming *cmpb.Song) (*cmpb.Song, *cmpb.Song) {
 	fmt.Printf("Received: %v\n", status)
 
 	switch status.GetCommand() {
 	case bepb.CommandType_Play:
-		link, ok := buildSongLink(status.GetSong())
-		if ok {
-			remote.LoadSong(link, true)
-			remote.ShowText(status.GetSong().GetTitle(), "8000")
+		track, ok := buildTrack(status.GetSong(), status.GetSeconds())
+		if !ok {
+			break
+		}
+
+		switch {
+		case current != nil && current.GetSongId() == status.GetSong().GetSongId():
+			// the player already moved on to the song that it preloaded
+
+		case current != nil:
+			// the queue changed after the song coming up was preloaded
+			remote.Next(track, status.GetStartAt() == 0)
+			remote.ShowText(track.Title, 8*time.Second)
+
+		default:
+			// a scheduled song is loaded paused and started later
+			if status.GetStartAt() != 0 {
+				remote.ForcePause(true)
+			}
+			remote.LoadSong(track)
+			remote.ShowText(track.Title, 8*time.Second)
 		}
+		current = status.GetSong()
+		upcoming = preload(remote, current, nil, status.GetUpcoming())
 
 	case bepb.CommandType_Next:
-		// link can be an empty string. We still want to stop the player even
-		// if there are no more songs in the playlist
-		link, _ := buildSongLink(status.GetSong())
-		remote.Next(link)
-		remote.ShowText(status.GetSong().GetTitle(), "8000")
+		// the track can be empty. We still want to stop the player even if
+		// there are no more songs in the playlist
+		track, ok := buildTrack(status.GetSong(), 0)
+		remote.Next(track, status.GetStartAt() == 0)
+		remote.ShowText(status.GetSong().GetTitle(), 8*time.Second)
+		current = nil
+		if ok {
+			current = status.GetSong()
+		}
+		upcoming = preload(remote, current, nil, status.GetUpcoming())
+
+	case bepb.CommandType_Preload:
+		upcoming = preload(remote, current, upcoming, status.GetUpcoming())
+
+	case bepb.CommandType_Stop:
+		remote.Stop()
 
 	case bepb.CommandType_Pause:
 		remote.TogglePause()
-		remote.ShowText("Player is paused", "600000")
+		remote.ShowText("Player is paused", 10*time.Minute)
+
+	case bepb.CommandType_Seek:
+		remote.Seek(status.GetSeconds(), status.GetRelative())
+
+	case bepb.CommandType_Volume:
+		remote.SetVolume(status.GetLevel())
+		remote.ShowText(fmt.Sprintf("Volume: %.0f%%", status.GetLevel()), 2*time.Second)
+
+	case bepb.CommandType_Mute:
+		if remote.ToggleMute() {
+			remote.ShowText("Muted", 2*time.Second)
+		} else {
+			remote.ShowText("Unmuted", 2*time.Second)
+		}
+
+	case bepb.CommandType_Restart:
+		remote.Restart()
 	}
+
+	return current, upcoming
+}
+
+/*
+ * Preload the song coming up next behind the current song. A song preloaded
+ * earlier is taken out when a different song comes up next. Returns the song
+ * that is preloaded afterwards.
+ */
+func preload(remote *Remote, current *cmpb.Song, upcoming *cmpb.Song, next *cmpb.Song) *cmpb.Song {
+	songCache.Prefetch(next)
+
+	if current == nil || next.GetSongId() == upcoming.GetSongId() {
+		return upcoming
+	}
+
+	if upcoming != nil {
+		remote.ClearPlaylist()
+	}
+
+	track, ok := buildTrack(next, 0)
+	if !ok {
+		return nil
+	}
+
+	remote.Preload(track)
+	return next
+}
+
+/*
+ * Report the playback progress of the current song to the server
+ */
+func reportProgress(stream bepb.YtbBePlayer_SongPlayerClient, remote *Remote, current *cmpb.Song) {
+	if current == nil {
+		return
+	}
+
+	position, duration, paused, err := remote.GetProgress()
+	if err != nil {
+		return
+	}
+
+	stream.Send(&bepb.PlayerStatus{
+		Command:  bepb.CommandType_Progress,
+		SongId:   current.GetSongId(),
+		Position: position,
+		Duration: duration,
+		Paused:   paused,
+	})
+}
+
+/*
+ * Tell the server that the current song could not be played so that it can
+ * move the room on to the next song
+ */
+func reportFailure(stream bepb.YtbBePlayer_SongPlayerClient, remote *Remote, current *cmpb.Song, reason string) {
+	fmt.Printf("Failed to play song: %s\n", reason)
+	if current == nil {
+		return
+	}
+
+	remote.ShowText(fmt.Sprintf("Could not play %s", current.GetTitle()), 8*time.Second)
+	if stream == nil {
+		return
+	}
+
+	stream.Send(&bepb.PlayerStatus{
+		Command: bepb.CommandType_Failed,
+		SongId:  current.GetSongId(),
+		Reason:  reason,
+	})
 }
 
 /*
  * Handle messages from other goroutines.
  */
-func interactionLoop(stream bepb.YtbBePlayer_SongPlayerClient, conn *mpv.Connection) {
+func interactionLoop(client bepb.YtbBePlayerClient, stream bepb.YtbBePlayer_SongPlayerClient, player Player) {
 	newStatus := make(chan *bepb.PlayerControl)
+	reconnected := make(chan bepb.YtbBePlayer_SongPlayerClient)
+	done := make(chan struct{})
 	halt := make(chan os.Signal)
-	mpvExit := make(chan struct{})
 	signal.Notify(halt, os.Interrupt)
 	remote := new(Remote)
-	remote.Init(conn)
-	events, stop := conn.NewEventListener()
-	streamOk := true
+	remote.Init(player)
+	events := player.Events()
+	progress := time.NewTicker(progressInterval)
+	defer progress.Stop()
+	syncTicker := time.NewTicker(syncInterval)
+	defer syncTicker.Stop()
+	clock := new(common.ClockOffset)
+	var startTimer <-chan time.Time
+	var current *cmpb.Song
+	var upcoming *cmpb.Song
 	running := true
 
-	remote.ShowText("Waiting for users to add songs", "600000")
+	remote.ShowText("Waiting for users to add songs", 10*time.Minute)
 
-	// send the initial command to the server to signal the player is ready
-	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+	// join the room and then signal to the server that the player is ready
+	joinRoom(stream, remote, current)
 
 	// start receiving messages
 	go receiveStatus(stream, newStatus)
 
-	// if mpv exits before player, then signal an exit to player
-	go func() {
-		conn.WaitUntilClosed()
-		close(mpvExit)
-	}()
-
 	for running {
 		select {
-		case status, streamOk := <-newStatus:
-			if !streamOk {
-				running = false
+		case status, ok := <-newStatus:
+			if !ok {
+				// keep the player playing while trying to get back to the server
+				stream = nil
+				newStatus = nil
+				go reconnect(client, reconnected, done)
 				break
 			}
-			handleNewStatus(status, remote)
 
-		case <-mpvExit:
+			if status.GetCommand() == bepb.CommandType_Sync {
+				clock.AddSample(time.Unix(0, status.GetSentAt()), time.Unix(0, status.GetServerTime()), time.Now())
+				break
+			}
+
+			current, upcoming = handleNewStatus(status, remote, current, upcoming)
+			if status.GetCommand() == bepb.CommandType_Play || status.GetCommand() == bepb.CommandType_Next {
+				startTimer = scheduleStart(status, clock)
+			}
+
+		case songId := <-songCache.Fetched():
+			// play the preloaded song from the cache rather than streaming it
+			if upcoming.GetSongId() == songId {
+				track, _ := buildTrack(upcoming, 0)
+				remote.ClearPlaylist()
+				remote.Preload(track)
+			}
+
+		case <-startTimer:
+			startTimer = nil
+			remote.ForcePause(false)
+
+		case <-syncTicker.C:
+			if stream != nil {
+				sendSync(stream)
+			}
+
+		case stream = <-reconnected:
+			fmt.Println("Reconnected")
+			newStatus = make(chan *bepb.PlayerControl)
+			joinRoom(stream, remote, current)
+			go receiveStatus(stream, newStatus)
+
+		case <-progress.C:
+			if stream != nil {
+				reportProgress(stream, remote, current)
+			}
+
+		case <-player.Closed():
 			running = false
 			break
 
@@ -258,107 +327,189 @@ func interactionLoop(stream bepb.YtbBePlayer_SongPlayerClient, conn *mpv.Connect
 			break
 
 		case event := <-events:
-			if event.Name == "idle" {
-				stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
-				remote.ShowText("Waiting for users to add songs", "600000")
+			switch event.Type {
+			case EventError:
+				reportFailure(stream, remote, current, event.Reason)
+
+			case EventEnded:
+				if upcoming == nil {
+					break
+				}
+
+				// the player moved straight on to the preloaded song, so the
+				// server is asked for the song after it
+				current = upcoming
+				upcoming = nil
+				remote.ShowText(current.GetTitle(), 8*time.Second)
+				if stream != nil {
+					stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+				}
+
+			case EventIdle:
+				current = nil
+				upcoming = nil
+				remote.ClearPlaylist()
+				remote.ShowText("Waiting for users to add songs", 10*time.Minute)
+
+				// a disconnected player says it's ready once it rejoins
+				if stream != nil {
+					stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+				}
 			}
 		}
 	}
 
-	// tell the server we're exiting
-	if streamOk {
+	// stop trying to reconnect and tell the server we're exiting
+	close(done)
+	if stream != nil {
 		stream.CloseSend()
 	}
 
-	// clean up mpv connection
-	time.Sleep(100 * time.Millisecond)
-	if !conn.IsClosed() {
-		stop <- struct{}{}
-		remote.Quit()
-	}
-	conn.Close()
+	// tell the player to exit if it hasn't already
+	remote.Quit()
 
 	close(halt)
 }
 
 /*
- * Build the song link
+ * Join the room on a new stream. A player that is in the middle of a song
+ * tells the server which song and how far in it is so the server lets it carry
+ * on. An idle player tells the server that it is ready for a song.
  */
-func buildSongLink(song *cmpb.Song) (string, bool) {
-	link := ""
-	ok := true
-
-	switch song.GetService() {
-	case cmpb.ServiceType_Local:
-		link = song.GetServiceId()
+func joinRoom(stream bepb.YtbBePlayer_SongPlayerClient, remote *Remote, current *cmpb.Song) {
+	join := &bepb.PlayerStatus{Command: bepb.CommandType_Join, Room: *room}
+	if current != nil {
+		position, duration, paused, err := remote.GetProgress()
+		if err == nil {
+			join.SongId = current.GetSongId()
+			join.Position = position
+			join.Duration = duration
+			join.Paused = paused
+		}
+	}
 
-	case cmpb.ServiceType_Youtube:
-		link = fmt.Sprintf("https://www.youtube.com/watch?v=%s", song.GetServiceId())
+	stream.Send(join)
+	sendSync(stream)
+	if current == nil {
+		stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+	}
+}
 
-	case cmpb.ServiceType_None:
-		ok = false
+/*
+ * Ping the server for its time to keep the clock offset estimate up to date
+ */
+func sendSync(stream bepb.YtbBePlayer_SongPlayerClient) {
+	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Sync, SentAt: time.Now().UnixNano()})
+}
 
-	default:
-		fmt.Printf("Unsupported link: %s\n", song.GetServiceId())
-		ok = false
+/*
+ * Returns a channel that fires when a song that was loaded paused should start
+ * playing. The start time set by the server is converted into local time using
+ * the clock offset estimate. Returns nil if the song wasn't scheduled.
+ */
+func scheduleStart(status *bepb.PlayerControl, clock *common.ClockOffset) <-chan time.Time {
+	if status.GetStartAt() == 0 {
+		return nil
 	}
 
-	return link, ok
+	// a start time that has already passed fires right away
+	startAt := clock.LocalTime(time.Unix(0, status.GetStartAt()))
+	fmt.Printf("Starting song at %v\n", startAt)
+	return time.After(time.Until(startAt))
 }
 
 /*
- * Start mpv in idle mode
+ * Keep trying to open a new stream to the server, waiting longer between each
+ * attempt. Gives up when done is closed.
  */
-func startMpv() (*exec.Cmd, io.ReadCloser) {
-	socketFlag := "--input-ipc-server=" + mpvSocket
-	audioDelayFlag := fmt.Sprintf("--audio-delay=%s", *audioDelay)
-	cmd := exec.Command("mpv",
-		"--idle",
-		socketFlag,
-		"--fullscreen",
-		"--force-window",
-		"--no-osc",
-		"--osd-align-y=bottom",
-		"--osd-blur=1.0",
-		"--osd-font='Ubuntu'",
-		"--osd-font-size=44",
-		audioDelayFlag)
-
-	// mpv outputs errors regarding bad input via stdout
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		panic(err)
+func reconnect(client bepb.YtbBePlayerClient, reconnected chan<- bepb.YtbBePlayer_SongPlayerClient, done <-chan struct{}) {
+	backoff := minBackoff
+
+	for {
+		fmt.Printf("Disconnected, reconnecting in %v\n", backoff)
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+
+		stream, err := client.SongPlayer(context.Background())
+		if err == nil {
+			select {
+			case reconnected <- stream:
+			case <-done:
+				stream.CloseSend()
+			}
+			return
+		}
+
+		fmt.Printf("Failed to reconnect: %v\n", err)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+/*
+ * Build the song link with the provider of the song's service
+ */
+func buildSongLink(song *cmpb.Song) (string, bool) {
+	if song.GetService() == cmpb.ServiceType_None {
+		return "", false
 	}
 
-	err = cmd.Start()
+	link, err := providers.PlayableUri(song)
 	if err != nil {
-		panic(err)
+		fmt.Printf("Unsupported link: %v\n", err)
+		return "", false
 	}
 
-	time.Sleep(500 * time.Millisecond)
-	return cmd, stdout
+	return link, true
+}
+
+/*
+ * Create the kind of player chosen on the command line
+ */
+func newPlayer(kind string) Player {
+	switch kind {
+	case "mpv-audio":
+		return newMpvPlayer(true)
+
+	case "null":
+		return newNullPlayer()
+
+	default:
+		return newMpvPlayer(false)
+	}
 }
 
 func main() {
 	kingpin.Version("0.1")
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
-	conn, stream := connectToRemote()
+	conn, client, stream := connectToRemote()
 	defer conn.Close()
 
-	mpvCmd, mpvStdout := startMpv()
+	if *cacheDir != "" {
+		var err error
+		songCache, err = newMediaCache(*cacheDir, *cacheSize*1024*1024, *playerKind == "mpv-audio")
+		if err != nil {
+			fmt.Printf("Failed to open cache: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	mpvConn := mpv.NewConnection(mpvSocket)
-	if err := mpvConn.Open(); err != nil {
-		slurp, _ := io.ReadAll(mpvStdout)
-		fmt.Printf("%s\n", slurp)
-		panic(err)
+	player := newPlayer(*playerKind)
+	if err := player.Start(); err != nil {
+		fmt.Printf("Failed to start player: %v\n", err)
+		os.Exit(1)
 	}
 
-	interactionLoop(stream, mpvConn)
+	interactionLoop(client, stream, player)
 
-	mpvCmd.Wait()
-	os.Remove(mpvSocket)
+	player.Wait()
+	songCache.Close()
 	time.Sleep(200 * time.Millisecond)
 	fmt.Println("end")
 }
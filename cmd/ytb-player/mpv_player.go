@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	mpv "github.com/DexterLB/mpvipc"
+)
+
+const (
+	mpvSocket   = "./.mpvsocket"
+	eventBuffer = 16 // player events that can be waiting on the interaction loop
+)
+
+/*
+ * Plays songs in mpv, driving it through its IPC socket. In audio only mode
+ * mpv runs without a window so it can be used on machines with no display.
+ */
+type mpvPlayer struct {
+	audioOnly    bool
+	cmd          *exec.Cmd
+	conn         *mpv.Connection
+	events       chan PlayerEvent
+	closed       chan struct{}
+	stopEvents   chan<- struct{}
+	startLock    sync.Mutex
+	startPending bool // true while a song loaded at a start position is loading
+}
+
+func newMpvPlayer(audioOnly bool) *mpvPlayer {
+	player := new(mpvPlayer)
+	player.audioOnly = audioOnly
+	player.events = make(chan PlayerEvent, eventBuffer)
+	player.closed = make(chan struct{})
+	return player
+}
+
+/*
+ * Start mpv in idle mode and connect to it
+ */
+func (p *mpvPlayer) Start() error {
+	args := []string{
+		"--idle",
+		"--input-ipc-server=" + mpvSocket,
+		"--prefetch-playlist=yes",
+		fmt.Sprintf("--audio-delay=%s", *audioDelay),
+	}
+
+	if p.audioOnly {
+		args = append(args,
+			"--no-video",
+			"--force-window=no",
+			"--ytdl-format=bestaudio/best")
+	} else {
+		args = append(args,
+			"--fullscreen",
+			"--force-window",
+			"--no-osc",
+			"--osd-align-y=bottom",
+			"--osd-blur=1.0",
+			"--osd-font='Ubuntu'",
+			"--osd-font-size=44")
+	}
+
+	p.cmd = exec.Command("mpv", args...)
+
+	// mpv outputs errors regarding bad input via stdout
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = p.cmd.Start(); err != nil {
+		return err
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	p.conn = mpv.NewConnection(mpvSocket)
+	if err = p.conn.Open(); err != nil {
+		slurp, _ := io.ReadAll(stdout)
+		fmt.Printf("%s\n", slurp)
+		return err
+	}
+
+	mpvEvents, stop := p.conn.NewEventListener()
+	p.stopEvents = stop
+	go p.listen(mpvEvents)
+
+	// if mpv exits before player, then signal an exit to player
+	go func() {
+		p.conn.WaitUntilClosed()
+		close(p.closed)
+	}()
+
+	return nil
+}
+
+/*
+ * Turn mpv's events into player events. Nothing here may wait on a reply from
+ * mpv, since mpv's replies are delivered after its events.
+ */
+func (p *mpvPlayer) listen(mpvEvents chan *mpv.Event) {
+	for event := range mpvEvents {
+		switch event.Name {
+		case "file-loaded":
+			go p.clearStart()
+
+		case "end-file":
+			if event.Reason == "eof" && !p.send(PlayerEvent{Type: EventEnded}) {
+				return
+			}
+
+			if event.Reason != "error" {
+				break
+			}
+
+			reason, _ := event.ExtraData["file_error"].(string)
+			if !p.send(PlayerEvent{Type: EventError, Reason: reason}) {
+				return
+			}
+
+		case "idle":
+			if !p.send(PlayerEvent{Type: EventIdle}) {
+				return
+			}
+		}
+	}
+}
+
+/*
+ * Pass an event on to the interaction loop. Returns false if mpv exited.
+ */
+func (p *mpvPlayer) send(event PlayerEvent) bool {
+	select {
+	case p.events <- event:
+		return true
+	case <-p.closed:
+		return false
+	}
+}
+
+/*
+ * Load a song into mpv. The start position is set on mpv before the song is
+ * loaded and reset once the song has loaded.
+ */
+func (p *mpvPlayer) Load(track Track) error {
+	if track.Start > 0 {
+		_, err := p.conn.Call("set_property", "start", fmt.Sprintf("%.1f", track.Start))
+		if err != nil {
+			return err
+		}
+
+		p.startLock.Lock()
+		p.startPending = true
+		p.startLock.Unlock()
+	}
+
+	_, err := p.conn.Call("loadfile", track.Link, "append-play")
+	return err
+}
+
+/*
+ * Reset the start position once the song loaded at it so that the songs after
+ * it play from the beginning
+ */
+func (p *mpvPlayer) clearStart() {
+	p.startLock.Lock()
+	defer p.startLock.Unlock()
+
+	if !p.startPending {
+		return
+	}
+
+	p.startPending = false
+	_, err := p.conn.Call("set_property", "start", "none")
+	if err != nil {
+		fmt.Printf("Failed to reset start position: %v\n", err)
+	}
+}
+
+func (p *mpvPlayer) PlaylistNext() error {
+	_, err := p.conn.Call("playlist-next", "force")
+	return err
+}
+
+func (p *mpvPlayer) Preload(track Track) error {
+	_, err := p.conn.Call("loadfile", track.Link, "append")
+	return err
+}
+
+func (p *mpvPlayer) ClearPlaylist() error {
+	_, err := p.conn.Call("playlist-clear")
+	return err
+}
+
+func (p *mpvPlayer) Stop() error {
+	_, err := p.conn.Call("stop")
+	return err
+}
+
+func (p *mpvPlayer) PlaylistCount() (int, error) {
+	count, err := p.conn.Get("playlist-count")
+	if err != nil {
+		return 0, err
+	}
+
+	value, _ := count.(float64)
+	return int(value), nil
+}
+
+func (p *mpvPlayer) SetPause(paused bool) error {
+	_, err := p.conn.Call("set_property", "pause", paused)
+	return err
+}
+
+func (p *mpvPlayer) TogglePause() error {
+	_, err := p.conn.Call("cycle", "pause", "up")
+	return err
+}
+
+func (p *mpvPlayer) Seek(seconds float64, relative bool) error {
+	mode := "absolute"
+	if relative {
+		mode = "relative"
+	}
+
+	_, err := p.conn.Call("seek", seconds, mode)
+	return err
+}
+
+func (p *mpvPlayer) SetVolume(level float64) error {
+	_, err := p.conn.Call("set_property", "volume", level)
+	return err
+}
+
+func (p *mpvPlayer) ToggleMute() (bool, error) {
+	if _, err := p.conn.Call("cycle", "mute"); err != nil {
+		return false, err
+	}
+
+	muted, err := p.conn.Get("mute")
+	if err != nil {
+		return false, err
+	}
+
+	state, _ := muted.(bool)
+	return state, nil
+}
+
+func (p *mpvPlayer) Progress() (float64, float64, bool, error) {
+	position, err := p.conn.Get("time-pos")
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	// the length isn't always known, e.g. for live streams
+	duration, err := p.conn.Get("duration")
+	if err != nil {
+		duration = 0.0
+	}
+
+	paused, err := p.conn.Get("pause")
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	positionSecs, _ := position.(float64)
+	durationSecs, _ := duration.(float64)
+	pausedState, _ := paused.(bool)
+	return positionSecs, durationSecs, pausedState, nil
+}
+
+/*
+ * Show the given text on mpv's OSD
+ */
+func (p *mpvPlayer) ShowText(text string, duration time.Duration) error {
+	_, err := p.conn.Call("show-text", text, fmt.Sprintf("%d", duration.Milliseconds()), 1)
+	return err
+}
+
+func (p *mpvPlayer) Events() <-chan PlayerEvent {
+	return p.events
+}
+
+func (p *mpvPlayer) Closed() <-chan struct{} {
+	return p.closed
+}
+
+/*
+ * Tell mpv to quit and close the connection to it
+ */
+func (p *mpvPlayer) Quit() {
+	time.Sleep(100 * time.Millisecond)
+	if !p.conn.IsClosed() {
+		p.stopEvents <- struct{}{}
+		if _, err := p.conn.Call("quit"); err != nil {
+			fmt.Printf("Failed to call quit: %v\n", err)
+		}
+	}
+	p.conn.Close()
+}
+
+/*
+ * Wait for mpv to exit and remove its socket
+ */
+func (p *mpvPlayer) Wait() {
+	p.cmd.Wait()
+	os.Remove(mpvSocket)
+}
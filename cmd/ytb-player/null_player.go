@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	nullDefaultLength = 3 * time.Minute // play time of songs whose length isn't known
+)
+
+var errNothingPlaying = errors.New("Nothing is playing")
+
+/*
+ * Pretends to play songs without any audio or video. Songs last as long as
+ * their duration so the timing of the player matches a real one. Lets the
+ * player run on machines with no display or sound card.
+ */
+type nullPlayer struct {
+	lock       sync.Mutex
+	playlist   []Track     // loaded songs, the first one is playing
+	playing    bool        // true while a song is playing
+	position   float64     // position in seconds when playback last resumed
+	resumed    time.Time   // when playback last resumed
+	paused     bool        // true if playback is paused
+	muted      bool        // true if the player is muted
+	timer      *time.Timer // fires when the current song ends
+	generation int         // incremented each time the end timer is replaced
+	events     chan PlayerEvent
+	closed     chan struct{}
+	quit       sync.Once
+
+	// events waiting on the interaction loop, wake is signalled as they're queued
+	queued []PlayerEvent
+	wake   chan struct{}
+}
+
+func newNullPlayer() *nullPlayer {
+	player := new(nullPlayer)
+	player.events = make(chan PlayerEvent, eventBuffer)
+	player.wake = make(chan struct{}, 1)
+	player.closed = make(chan struct{})
+	go player.deliverEvents()
+	return player
+}
+
+func (p *nullPlayer) Start() error {
+	fmt.Println("Simulating playback with the null player")
+	return nil
+}
+
+func (p *nullPlayer) Load(track Track) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	fmt.Printf("Loaded %s\n", track.Link)
+	p.playlist = append(p.playlist, track)
+	if !p.playing {
+		p.startTrack()
+	}
+
+	return nil
+}
+
+func (p *nullPlayer) PlaylistNext() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.playing {
+		return errNothingPlaying
+	}
+
+	p.nextTrack()
+	return nil
+}
+
+func (p *nullPlayer) Preload(track Track) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.playing {
+		return errNothingPlaying
+	}
+
+	fmt.Printf("Preloaded %s\n", track.Link)
+	p.playlist = append(p.playlist, track)
+	return nil
+}
+
+func (p *nullPlayer) ClearPlaylist() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.playing {
+		p.playlist = nil
+		return nil
+	}
+
+	p.playlist = p.playlist[:1]
+	return nil
+}
+
+func (p *nullPlayer) Stop() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.playing {
+		p.playlist = nil
+		return nil
+	}
+
+	p.playlist = p.playlist[:1]
+	p.nextTrack()
+	return nil
+}
+
+func (p *nullPlayer) PlaylistCount() (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.playlist), nil
+}
+
+func (p *nullPlayer) SetPause(paused bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.setPause(paused)
+	return nil
+}
+
+func (p *nullPlayer) TogglePause() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.setPause(!p.paused)
+	return nil
+}
+
+func (p *nullPlayer) Seek(seconds float64, relative bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.playing {
+		return errNothingPlaying
+	}
+
+	position := seconds
+	if relative {
+		position += p.currentPosition()
+	}
+
+	if position < 0 {
+		position = 0
+	}
+
+	p.position = position
+	p.resumed = time.Now()
+	p.scheduleEnd()
+	return nil
+}
+
+func (p *nullPlayer) SetVolume(level float64) error {
+	return nil
+}
+
+func (p *nullPlayer) ToggleMute() (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.muted = !p.muted
+	return p.muted, nil
+}
+
+func (p *nullPlayer) Progress() (float64, float64, bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.playing {
+		return 0, 0, false, errNothingPlaying
+	}
+
+	return p.currentPosition(), p.playlist[0].Duration, p.paused, nil
+}
+
+/*
+ * There is nothing to show text on, so the text is printed instead
+ */
+func (p *nullPlayer) ShowText(text string, duration time.Duration) error {
+	fmt.Printf("Showing: %s\n", text)
+	return nil
+}
+
+func (p *nullPlayer) Events() <-chan PlayerEvent {
+	return p.events
+}
+
+func (p *nullPlayer) Closed() <-chan struct{} {
+	return p.closed
+}
+
+func (p *nullPlayer) Quit() {
+	p.quit.Do(func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		close(p.closed)
+	})
+}
+
+func (p *nullPlayer) Wait() {
+	<-p.closed
+}
+
+/*
+ * Start playing the song at the front of the playlist. The caller must hold
+ * the lock.
+ */
+func (p *nullPlayer) startTrack() {
+	// a local file that doesn't exist fails to play just like it would in a
+	// real player
+	link := p.playlist[0].Link
+	if !strings.Contains(link, "://") {
+		if _, err := os.Stat(link); err != nil {
+			p.send(PlayerEvent{Type: EventError, Reason: err.Error()})
+			p.nextTrack()
+			return
+		}
+	}
+
+	p.playing = true
+	p.position = p.playlist[0].Start
+	p.resumed = time.Now()
+	p.scheduleEnd()
+}
+
+/*
+ * Move on to the next song in the playlist or go idle when there isn't one.
+ * The caller must hold the lock.
+ */
+func (p *nullPlayer) nextTrack() {
+	p.playlist = p.playlist[1:]
+	if len(p.playlist) > 0 {
+		p.startTrack()
+		return
+	}
+
+	p.playing = false
+	p.generation++
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	p.send(PlayerEvent{Type: EventIdle})
+}
+
+/*
+ * Queue an event for the interaction loop. The interaction loop may be waiting
+ * on the lock, so the event is passed on by deliverEvents instead of here. The
+ * caller must hold the lock.
+ */
+func (p *nullPlayer) send(event PlayerEvent) {
+	p.queued = append(p.queued, event)
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+ * Pass the queued events on to the interaction loop in order until the player
+ * quits
+ */
+func (p *nullPlayer) deliverEvents() {
+	for {
+		select {
+		case <-p.wake:
+		case <-p.closed:
+			return
+		}
+
+		for {
+			p.lock.Lock()
+			if len(p.queued) == 0 {
+				p.lock.Unlock()
+				break
+			}
+
+			event := p.queued[0]
+			p.queued = p.queued[1:]
+			p.lock.Unlock()
+
+			select {
+			case p.events <- event:
+			case <-p.closed:
+				return
+			}
+		}
+	}
+}
+
+/*
+ * Pause or unpause playback. The caller must hold the lock.
+ */
+func (p *nullPlayer) setPause(paused bool) {
+	if p.paused == paused {
+		return
+	}
+
+	if p.playing {
+		p.position = p.currentPosition()
+		p.resumed = time.Now()
+	}
+
+	p.paused = paused
+	if p.playing {
+		p.scheduleEnd()
+	}
+}
+
+/*
+ * Returns the position of the current song in seconds. The caller must hold
+ * the lock.
+ */
+func (p *nullPlayer) currentPosition() float64 {
+	if p.paused {
+		return p.position
+	}
+
+	return p.position + time.Since(p.resumed).Seconds()
+}
+
+/*
+ * Replace the timer that ends the current song. Nothing ends while playback
+ * is paused. The caller must hold the lock.
+ */
+func (p *nullPlayer) scheduleEnd() {
+	p.generation++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	if p.paused {
+		return
+	}
+
+	length := nullDefaultLength
+	if p.playlist[0].Duration > 0 {
+		length = time.Duration(p.playlist[0].Duration * float64(time.Second))
+	}
+
+	remaining := length - time.Duration(p.position*float64(time.Second))
+	generation := p.generation
+	p.timer = time.AfterFunc(remaining, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		// the song was skipped, seeked or paused since the timer was set
+		if generation != p.generation || !p.playing {
+			return
+		}
+
+		p.send(PlayerEvent{Type: EventEnded})
+		p.nextTrack()
+	})
+}
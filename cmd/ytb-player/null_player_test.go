@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testEventWait = 2 * time.Second // longest a test waits for a player event
+
+/*
+ * Wait for the next event from the player
+ */
+func nextEvent(t *testing.T, player *nullPlayer) PlayerEvent {
+	select {
+	case event := <-player.Events():
+		return event
+	case <-time.After(testEventWait):
+		t.Fatal("Timed out waiting for a player event")
+		return PlayerEvent{}
+	}
+}
+
+func expectNoEvent(t *testing.T, player *nullPlayer, wait time.Duration) {
+	select {
+	case event := <-player.Events():
+		t.Error("Expected no player event but got", event)
+	case <-time.After(wait):
+	}
+}
+
+func TestNullPlayer_whenSongEnds_goesIdle(t *testing.T) {
+	player := newNullPlayer()
+	defer player.Quit()
+
+	player.Load(Track{Link: "https://example.com/song", Duration: 0.05})
+	if position, duration, paused, err := player.Progress(); err != nil || duration != 0.05 || paused || position > 0.05 {
+		t.Error("Expected the song to be playing but got", position, duration, paused, err)
+	}
+
+	if event := nextEvent(t, player); event.Type != EventEnded {
+		t.Error("Expected the song to end but got", event)
+	}
+
+	if event := nextEvent(t, player); event.Type != EventIdle {
+		t.Error("Expected the player to go idle but got", event)
+	}
+
+	if _, _, _, err := player.Progress(); err != errNothingPlaying {
+		t.Error("Expected nothing to be playing but got", err)
+	}
+}
+
+func TestNullPlayer_whenPreloaded_playsNextSong(t *testing.T) {
+	player := newNullPlayer()
+	defer player.Quit()
+
+	player.Load(Track{Link: "https://example.com/first", Duration: 0.05})
+	player.Preload(Track{Link: "https://example.com/second", Duration: 60})
+
+	if event := nextEvent(t, player); event.Type != EventEnded {
+		t.Error("Expected the first song to end but got", event)
+	}
+
+	if _, duration, _, err := player.Progress(); err != nil || duration != 60 {
+		t.Error("Expected the second song to be playing but got", duration, err)
+	}
+}
+
+func TestNullPlayer_whenPaused_doesNotEnd(t *testing.T) {
+	player := newNullPlayer()
+	defer player.Quit()
+
+	player.Load(Track{Link: "https://example.com/song", Duration: 0.1})
+	player.SetPause(true)
+
+	expectNoEvent(t, player, 200*time.Millisecond)
+	position, _, paused, err := player.Progress()
+	if err != nil || !paused || position >= 0.1 {
+		t.Error("Expected the song to stay paused but got", position, paused, err)
+	}
+
+	player.SetPause(false)
+	if event := nextEvent(t, player); event.Type != EventEnded {
+		t.Error("Expected the song to end once unpaused but got", event)
+	}
+}
+
+func TestNullPlayer_whenSeeked_endsFromNewPosition(t *testing.T) {
+	player := newNullPlayer()
+	defer player.Quit()
+
+	player.Load(Track{Link: "https://example.com/song", Duration: 60})
+	player.SetPause(true)
+
+	player.Seek(30, false)
+	player.Seek(-10, true)
+	if position, _, _, _ := player.Progress(); position != 20 {
+		t.Error("Expected to be 20 seconds in but got", position)
+	}
+
+	player.Seek(59.95, false)
+	player.SetPause(false)
+	if event := nextEvent(t, player); event.Type != EventEnded {
+		t.Error("Expected the song to end after seeking near its end but got", event)
+	}
+}
+
+/*
+ * Events sent while the interaction loop is busy should wait for it rather
+ * than being dropped
+ */
+func TestNullPlayer_whenEventsBackUp_keepsThemInOrder(t *testing.T) {
+	player := newNullPlayer()
+	defer player.Quit()
+
+	// every missing file fails to play and leaves the player idle
+	count := eventBuffer * 2
+	for i := 0; i < count; i++ {
+		player.Load(Track{Link: "/missing/song.mp3"})
+	}
+
+	for i := 0; i < count; i++ {
+		if event := nextEvent(t, player); event.Type != EventError {
+			t.Fatal("Expected an error event but got", event)
+		}
+
+		if event := nextEvent(t, player); event.Type != EventIdle {
+			t.Fatal("Expected an idle event but got", event)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+/*
+ * Kinds of things that happen in the player
+ */
+type EventType int
+
+const (
+	EventIdle  EventType = iota // the player ran out of songs to play
+	EventError                  // the player could not play the current song
+	EventEnded                  // the current song played to the end
+)
+
+/*
+ * Something that happened in the player
+ */
+type PlayerEvent struct {
+	Type   EventType
+	Reason string // why the song could not be played. Only set for EventError
+}
+
+/*
+ * A song loaded into a player
+ */
+type Track struct {
+	Link     string  // link or path that the player plays
+	Title    string  // title of the song
+	Duration float64 // length of the song in seconds. Zero if it isn't known
+	Start    float64 // position in seconds to start playing the song from
+}
+
+/*
+ * A media player that songs are played on. The player keeps a playlist of the
+ * songs loaded into it and moves on to the next song in the playlist when a
+ * song ends.
+ */
+type Player interface {
+	// Launch the player. Returns once the player is ready to be controlled
+	Start() error
+
+	// Add a song to the end of the playlist. The song starts playing if
+	// nothing else is playing
+	Load(track Track) error
+
+	// Add a song to the end of the playlist without starting it, so that the
+	// player can get it ready while the current song plays
+	Preload(track Track) error
+
+	// Remove every song but the current one from the playlist
+	ClearPlaylist() error
+
+	// Skip to the next song in the playlist
+	PlaylistNext() error
+
+	// Stop playing and clear the playlist
+	Stop() error
+
+	// Number of songs in the playlist
+	PlaylistCount() (int, error)
+
+	// Pause or unpause playback
+	SetPause(paused bool) error
+
+	// Toggle the pause state
+	TogglePause() error
+
+	// Seek to a position in seconds, or by a number of seconds when relative
+	Seek(seconds float64, relative bool) error
+
+	// Set the volume to a level from 0 to 100
+	SetVolume(level float64) error
+
+	// Toggle the mute state. Returns true if the player is muted afterwards
+	ToggleMute() (bool, error)
+
+	// Position and length of the current song in seconds along with the pause
+	// state. Fails if nothing is playing
+	Progress() (float64, float64, bool, error)
+
+	// Show text to the people watching the player
+	ShowText(text string, duration time.Duration) error
+
+	// Events that happen in the player
+	Events() <-chan PlayerEvent
+
+	// Closed when the player exits
+	Closed() <-chan struct{}
+
+	// Tell the player to exit
+	Quit()
+
+	// Wait for the player to exit and clean up after it
+	Wait()
+}
+
+/*
+ * Remote contoller to interface with the player
+ */
+type Remote struct {
+	player Player
+}
+
+/*
+ * Initialize the remote
+ */
+func (r *Remote) Init(player Player) {
+	r.player = player
+}
+
+/*
+ * Load a song into the player. It starts playing if nothing else is playing.
+ */
+func (r *Remote) LoadSong(track Track) {
+	if err := r.player.Load(track); err != nil {
+		fmt.Printf("Failed to load song: %v\n", err)
+	}
+}
+
+/*
+ * Load the song coming up next behind the current one so that it's ready to
+ * play once the current song ends
+ */
+func (r *Remote) Preload(track Track) {
+	if err := r.player.Preload(track); err != nil {
+		fmt.Printf("Failed to preload song: %v\n", err)
+	}
+}
+
+/*
+ * Remove every song but the current one from the playlist
+ */
+func (r *Remote) ClearPlaylist() {
+	if err := r.player.ClearPlaylist(); err != nil {
+		fmt.Printf("Failed to clear playlist: %v\n", err)
+	}
+}
+
+/*
+ * Stop playing and clear the playlist
+ */
+func (r *Remote) Stop() {
+	if err := r.player.Stop(); err != nil {
+		fmt.Printf("Failed to stop: %v\n", err)
+	}
+}
+
+/*
+ * Show the given text on the player
+ */
+func (r *Remote) ShowText(text string, duration time.Duration) {
+	if err := r.player.ShowText(text, duration); err != nil {
+		fmt.Printf("Failed to show text: %v\n", err)
+	}
+}
+
+/*
+ * Toggle the pause state
+ */
+func (r *Remote) TogglePause() {
+	if err := r.player.TogglePause(); err != nil {
+		fmt.Printf("Failed to toggle pause: %v\n", err)
+	}
+}
+
+/*
+ * Force pause to a certain state
+ */
+func (r *Remote) ForcePause(state bool) {
+	if err := r.player.SetPause(state); err != nil {
+		fmt.Printf("Failed to pause: %v\n", err)
+	}
+}
+
+/*
+ * Seek to a position in seconds, or by a number of seconds from the current
+ * position when relative is true
+ */
+func (r *Remote) Seek(seconds float64, relative bool) {
+	if err := r.player.Seek(seconds, relative); err != nil {
+		fmt.Printf("Failed to seek: %v\n", err)
+	}
+}
+
+/*
+ * Set the volume to a level from 0 to 100
+ */
+func (r *Remote) SetVolume(level float64) {
+	if err := r.player.SetVolume(level); err != nil {
+		fmt.Printf("Failed to set volume: %v\n", err)
+	}
+}
+
+/*
+ * Toggle the mute state. Returns true if the player is muted afterwards.
+ */
+func (r *Remote) ToggleMute() bool {
+	muted, err := r.player.ToggleMute()
+	if err != nil {
+		fmt.Printf("Failed to toggle mute: %v\n", err)
+	}
+
+	return muted
+}
+
+/*
+ * Play the current song again from the start
+ */
+func (r *Remote) Restart() {
+	r.Seek(0, false)
+	r.ForcePause(false)
+}
+
+/*
+ * Get the number of tracks in the player's playlist
+ */
+func (r *Remote) GetPlaylistCount() int {
+	count, err := r.player.PlaylistCount()
+	if err != nil {
+		fmt.Printf("Failed to get playlist count: %v\n", err)
+		return 0
+	}
+
+	return count
+}
+
+/*
+ * Get the playback position and length of the current song in seconds along
+ * with the pause state. Fails if the player isn't playing anything.
+ */
+func (r *Remote) GetProgress() (float64, float64, bool, error) {
+	return r.player.Progress()
+}
+
+/*
+ * Go to the next song. A track without a link stops the player. The song is
+ * left paused when play is false so that it can be started later.
+ */
+func (r *Remote) Next(track Track, play bool) {
+	if !play {
+		r.ForcePause(true)
+	}
+
+	// the current song would otherwise keep playing
+	if track.Link == "" {
+		r.Stop()
+		return
+	}
+
+	// a preloaded song would otherwise be played instead
+	r.ClearPlaylist()
+	r.LoadSong(track)
+
+	// a new player should have one track in the playlist. Players who are
+	// already playing should have two
+	if r.GetPlaylistCount() > 1 {
+		if err := r.player.PlaylistNext(); err != nil {
+			fmt.Printf("Failed to go to next song: %v\n", err)
+		} else if play {
+			r.ForcePause(false)
+		}
+	}
+}
+
+/*
+ * Tell the player to quit
+ */
+func (r *Remote) Quit() {
+	r.player.Quit()
+}
+
+/*
+ * Build the track to play for a song. Songs in the cache are played from their
+ * local copy. Returns false if the song can't be played.
+ */
+func buildTrack(song *cmpb.Song, start float64) (Track, bool) {
+	link, ok := buildSongLink(song)
+	if !ok {
+		return Track{}, false
+	}
+
+	if path, cached := songCache.Lookup(song); cached {
+		link = path
+	}
+
+	return Track{
+		Link:     link,
+		Title:    song.GetTitle(),
+		Duration: float64(song.GetDuration()),
+		Start:    start,
+	}, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func newTestRemote() (*Remote, *nullPlayer) {
+	player := newNullPlayer()
+	remote := new(Remote)
+	remote.Init(player)
+	return remote, player
+}
+
+func TestNext_playsNewSong(t *testing.T) {
+	remote, player := newTestRemote()
+	defer player.Quit()
+
+	remote.LoadSong(Track{Link: "https://example.com/first", Duration: 60})
+	remote.Preload(Track{Link: "https://example.com/preloaded", Duration: 90})
+	remote.Next(Track{Link: "https://example.com/second", Duration: 120}, true)
+
+	if _, duration, paused, err := player.Progress(); err != nil || duration != 120 || paused {
+		t.Error("Expected the second song to be playing but got", duration, paused, err)
+	}
+
+	if count := remote.GetPlaylistCount(); count != 1 {
+		t.Error("Expected only the second song in the playlist but got", count)
+	}
+}
+
+func TestNext_whenQueueEmpty_stopsPlaying(t *testing.T) {
+	remote, player := newTestRemote()
+	defer player.Quit()
+
+	remote.LoadSong(Track{Link: "https://example.com/first", Duration: 60})
+	remote.Preload(Track{Link: "https://example.com/preloaded", Duration: 90})
+	remote.Next(Track{}, true)
+
+	if _, _, _, err := player.Progress(); err != errNothingPlaying {
+		t.Error("Expected nothing to be playing but got", err)
+	}
+
+	if event := nextEvent(t, player); event.Type != EventIdle {
+		t.Error("Expected the player to go idle but got", event)
+	}
+}
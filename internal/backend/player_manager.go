@@ -1,9 +1,17 @@
 /*
  * Manages all connected remote player clients. The manager acts a funnel where
  * new messages from player clients are fanned into the manager and new control
- * messages are sent out. The manager is responsible for popping songs off the
- * front of the playlist when all the remote player clients send in a ready
- * status.
+ * messages are sent out. Each player is bound to a single room and only
+ * receives the control messages meant for that room. The manager is
+ * responsible for popping songs off the front of a room's playlist when all
+ * the remote player clients in the room send in a ready status. Once the first
+ * player in a room is ready, the others are given a grace period to catch up
+ * before the manager stops waiting for them and moves on. When the last player
+ * leaves a room, the room's song and progress are held for a while so that a
+ * player reconnecting to the room can pick up where it left off. Songs are
+ * scheduled to start a moment after they are sent out so that every player in
+ * a room starts them together. Players are also told which song comes up next
+ * while the current one plays so that they can load it ahead of time.
  */
 
 package backend
@@ -11,6 +19,7 @@ package backend
 import (
 	"log"
 	"sync"
+	"time"
 
 	queuer "github.com/nguyenmq/ytbox-go/internal/backend/song_queuer"
 	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
@@ -27,59 +36,161 @@ const (
  */
 type playerMessage struct {
 	Id     int
+	RoomId uint32
 	Status *bepb.PlayerStatus
 }
 
+/*
+ * An outgoing control message for all the players in a room
+ */
+type roomControl struct {
+	RoomId  uint32
+	Control *bepb.PlayerControl
+}
+
 /*
  * Keeps track of state data belonging to a player
  */
 type playerState struct {
-	out  bepb.YtbBePlayer_SongPlayerServer
-	stop chan struct{}
+	out    bepb.YtbBePlayer_SongPlayerServer
+	stop   chan struct{}
+	roomId uint32
+
+	// controls waiting to be written to the stream, wake is signalled as
+	// they're queued and left is closed once the player is removed
+	sendLock sync.Mutex
+	queued   []*bepb.PlayerControl
+	wake     chan struct{}
+	left     chan struct{}
+	written  chan struct{} // closed once the writer stops
 }
 
 /*
- * Manages communication between the backend server and remote player clients.
+ * A grace period for the players in a room ran out. The generation identifies
+ * which song the players were getting ready for.
  */
-type playerManager struct {
-	fanIn      chan playerMessage
-	fanOut     chan *bepb.PlayerControl
+type graceTimeout struct {
+	RoomId     uint32
+	Generation int
+}
+
+/*
+ * The last playback progress reported by a player in a room
+ */
+type playerProgress struct {
+	songId   uint32
+	position float64   // seconds into the song
+	duration float64   // length of the song in seconds
+	paused   bool      // true if playback was paused
+	reported time.Time // when the progress was received
+}
+
+/*
+ * The players serving a single room
+ */
+type playerRoom struct {
 	streams    map[int]*playerState
 	ready      map[int]bool
-	playerLock sync.RWMutex
-	streamIds  int
-	queueMgr   *queuer.SongQueueManager
+	timer      *time.Timer     // grace period for slow players
+	generation int             // incremented each time a new song is sent out
+	fetching   bool            // true while the next song is being fetched
+	progress   *playerProgress // last progress reported by the room's players
+	failed     uint32          // id of the last song the room's players failed to play
+	upcoming   uint32          // id of the song the room's players were told comes up next
+	done       chan struct{}   // closed when the room's last player leaves
 }
 
 /*
- * Initialize the player manager. It still needs to be started after being
- * initialized.
+ * The progress of a room whose players have all left. The room's song is held
+ * until the timer fires.
  */
-func (mgr *playerManager) init(queueMgr *queuer.SongQueueManager) {
+type heldRoom struct {
+	progress *playerProgress
+	timer    *time.Timer
+}
+
+/*
+ * Manages communication between the backend server and remote player clients.
+ */
+type playerManager struct {
+	fanIn          chan playerMessage
+	fanOut         chan roomControl
+	timeouts       chan graceTimeout
+	recheck        chan uint32
+	stopped        chan struct{} // closed once the manager stops
+	rooms          map[uint32]*playerRoom
+	held           map[uint32]*heldRoom
+	players        map[int]*playerState
+	playerLock     sync.RWMutex
+	streamIds      int
+	queueMgr       *queuer.SongQueueManager
+	readyTimeout   time.Duration
+	reconnectGrace time.Duration
+	startDelay     time.Duration
+}
+
+/*
+ * Initialize the player manager. The ready timeout is how long the players in
+ * a room are waited on once the first of them is ready for the next song. The
+ * reconnect grace is how long the song of a room is held after its last player
+ * leaves. The start delay is how far in the future songs are scheduled to
+ * start. It still needs to be started after being initialized.
+ */
+func (mgr *playerManager) init(queueMgr *queuer.SongQueueManager, readyTimeout time.Duration, reconnectGrace time.Duration, startDelay time.Duration) {
 	mgr.fanIn = make(chan playerMessage)
-	mgr.fanOut = make(chan *bepb.PlayerControl)
-	mgr.streams = make(map[int]*playerState, 2)
-	mgr.ready = make(map[int]bool, 2)
+	mgr.fanOut = make(chan roomControl)
+	mgr.timeouts = make(chan graceTimeout)
+	mgr.recheck = make(chan uint32)
+	mgr.stopped = make(chan struct{})
+	mgr.rooms = make(map[uint32]*playerRoom)
+	mgr.held = make(map[uint32]*heldRoom)
+	mgr.players = make(map[int]*playerState, 2)
 	mgr.streamIds = 0
 	mgr.queueMgr = queueMgr
+	mgr.readyTimeout = readyTimeout
+	mgr.reconnectGrace = reconnectGrace
+	mgr.startDelay = startDelay
 }
 
 /*
- * Add a player stream for the manager to keep track of. Returns a player id
- * and a channel to signal stop
+ * Add a player stream serving the given room for the manager to keep track of.
+ * Returns a player id and a channel to signal stop
  */
-func (mgr *playerManager) add(out bepb.YtbBePlayer_SongPlayerServer) (int, chan struct{}) {
+func (mgr *playerManager) add(roomId uint32, out bepb.YtbBePlayer_SongPlayerServer) (int, chan struct{}) {
 	mgr.playerLock.Lock()
 	defer mgr.playerLock.Unlock()
 
+	room, exists := mgr.rooms[roomId]
+	if !exists {
+		room = &playerRoom{
+			streams: make(map[int]*playerState, 2),
+			ready:   make(map[int]bool, 2),
+			done:    make(chan struct{}),
+		}
+		mgr.rooms[roomId] = room
+
+		// a player came back before the room's song was given up on
+		if held, isHeld := mgr.held[roomId]; isHeld {
+			held.timer.Stop()
+			room.progress = held.progress
+			delete(mgr.held, roomId)
+		}
+	}
+
 	mgr.streamIds++
 	state := new(playerState)
 	state.out = out
 	state.stop = make(chan struct{})
-
-	mgr.streams[mgr.streamIds] = state
-	mgr.ready[mgr.streamIds] = PLAYER_BUSY
-	log.Printf("New player %d", mgr.streamIds)
+	state.roomId = roomId
+	state.wake = make(chan struct{}, 1)
+	state.left = make(chan struct{})
+	state.written = make(chan struct{})
+	go state.writeControls()
+
+	mgr.players[mgr.streamIds] = state
+	room.streams[mgr.streamIds] = state
+	room.ready[mgr.streamIds] = PLAYER_BUSY
+	log.Printf("New player %d in room %d", mgr.streamIds, roomId)
 	return mgr.streamIds, state.stop
 }
 
@@ -87,27 +198,138 @@ func (mgr *playerManager) add(out bepb.YtbBePlayer_SongPlayerServer) (int, chan
  * Receive messages from all remote players
  */
 func (mgr *playerManager) receiveFromPlayers(id int, status *bepb.PlayerStatus) {
-	mgr.fanIn <- playerMessage{Id: id, Status: status}
+	mgr.playerLock.RLock()
+	state, exists := mgr.players[id]
+	mgr.playerLock.RUnlock()
+
+	if exists {
+		mgr.fanIn <- playerMessage{Id: id, RoomId: state.roomId, Status: status}
+	}
+}
+
+/*
+ * Send commands to all player clients in the room
+ */
+func (mgr *playerManager) sendToPlayers(roomId uint32, control *bepb.PlayerControl) {
+	mgr.fanOut <- roomControl{RoomId: roomId, Control: control}
 }
 
 /*
- * Send commands to all player clients
+ * Catch up on a player that joined the room. A player that reconnects while
+ * still playing the room's song carries on with it rather than having the song
+ * sent to it again. A player that reconnects idle finished the song or lost it
+ * while it was away, so the song isn't played again.
  */
-func (mgr *playerManager) sendToPlayers(control *bepb.PlayerControl) {
-	mgr.fanOut <- control
+func (mgr *playerManager) rejoin(roomId uint32, join *bepb.PlayerStatus) {
+	if join.GetSongId() == 0 {
+		if mgr.queueMgr.CancelResume(roomId) {
+			log.Printf("Player rejoined room %d idle, dropped the song it was holding", roomId)
+			mgr.queueMgr.ClearNowPlaying(roomId)
+		}
+		return
+	}
+
+	nowPlaying := mgr.queueMgr.NowPlaying(roomId)
+	if nowPlaying == nil || nowPlaying.SongId != join.GetSongId() {
+		return
+	}
+
+	log.Printf("Player rejoined room %d while playing song %d", roomId, join.GetSongId())
+	mgr.queueMgr.CancelResume(roomId)
+	mgr.updateProgress(roomId, join)
 }
 
 /*
- * Remove a player stream that the player manager was keeping track of
+ * Remove a player stream that the player manager was keeping track of. Returns
+ * the number of players left in the room that the player was serving.
  */
 func (mgr *playerManager) remove(id int) int {
 	mgr.playerLock.Lock()
+
+	state, exists := mgr.players[id]
+	if !exists {
+		mgr.playerLock.Unlock()
+		return 0
+	}
+
+	// the stream can't be written to once its rpc returns, so wait for the
+	// writer after letting go of the lock
+	defer state.stopWriting()
 	defer mgr.playerLock.Unlock()
 
-	delete(mgr.streams, id)
-	delete(mgr.ready, id)
-	log.Printf("Removed player %d", id)
-	return len(mgr.streams)
+	delete(mgr.players, id)
+	room := mgr.rooms[state.roomId]
+	delete(room.streams, id)
+	delete(room.ready, id)
+	log.Printf("Removed player %d from room %d", id, state.roomId)
+
+	remaining := len(room.streams)
+	if remaining == 0 {
+		if room.timer != nil {
+			room.timer.Stop()
+		}
+		delete(mgr.rooms, state.roomId)
+		mgr.hold(state.roomId, room.progress)
+
+		// a song fetched for the room's players now would go to nobody
+		close(room.done)
+		mgr.queueMgr.InterruptWait(state.roomId)
+	} else {
+		// the players left behind may have only been waiting on this one
+		go func() {
+			select {
+			case mgr.recheck <- state.roomId:
+			case <-mgr.stopped:
+			}
+		}()
+	}
+
+	return remaining
+}
+
+/*
+ * Holds the song of a room whose players have all left so that it is played
+ * again from the same position when a player comes back. The song is given up
+ * on once the reconnect grace runs out. The caller must hold the player lock.
+ */
+func (mgr *playerManager) hold(roomId uint32, progress *playerProgress) {
+	if mgr.reconnectGrace <= 0 || !mgr.queueMgr.ResumeNowPlaying(roomId) {
+		mgr.queueMgr.ClearNowPlaying(roomId)
+		return
+	}
+
+	// nothing plays while the room is empty, so the position stands still
+	held := new(heldRoom)
+	if progress != nil {
+		now := time.Now()
+		frozen := *progress
+		frozen.position = progress.positionAt(now)
+		frozen.paused = true
+		frozen.reported = now
+		held.progress = &frozen
+	}
+
+	held.timer = time.AfterFunc(mgr.reconnectGrace, func() {
+		mgr.release(roomId, held)
+	})
+	mgr.held[roomId] = held
+	log.Printf("Holding the song of room %d for %v", roomId, mgr.reconnectGrace)
+}
+
+/*
+ * No player came back to the room in time, so its song is given up on
+ */
+func (mgr *playerManager) release(roomId uint32, held *heldRoom) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	if mgr.held[roomId] != held {
+		return
+	}
+
+	delete(mgr.held, roomId)
+	mgr.queueMgr.ClearNowPlaying(roomId)
+	log.Printf("No player came back to room %d, stopped holding its song", roomId)
 }
 
 /*
@@ -115,20 +337,32 @@ func (mgr *playerManager) remove(id int) int {
  */
 func (mgr *playerManager) start() {
 	go func() {
-		nextSong := make(chan bepb.PlayerControl)
+		nextSong := make(chan roomControl)
 
 		for {
 			select {
-			case control, ok := <-mgr.fanOut:
+			case out, ok := <-mgr.fanOut:
 				if !ok {
 					close(nextSong)
 					return
 				}
 
-				log.Printf("Sending out command: %v", control.GetCommand())
+				if out.Control.GetCommand() == bepb.CommandType_Next {
+					mgr.scheduleStart(out.Control)
+					mgr.attachUpcoming(out.RoomId, out.Control)
+				}
+
+				// the players only hear about a change to the upcoming song
+				if out.Control.GetCommand() == bepb.CommandType_Preload && !mgr.attachUpcoming(out.RoomId, out.Control) {
+					break
+				}
+
+				log.Printf("Sending out command to room %d: %v", out.RoomId, out.Control.GetCommand())
 				mgr.playerLock.RLock()
-				for _, state := range mgr.streams {
-					go sendToStream(control, state.out)
+				if room, exists := mgr.rooms[out.RoomId]; exists {
+					for _, state := range room.streams {
+						state.send(out.Control)
+					}
 				}
 				mgr.playerLock.RUnlock()
 
@@ -138,29 +372,69 @@ func (mgr *playerManager) start() {
 					return
 				}
 
+				// progress and clock syncs are too frequent to be worth logging
+				if msg.Status.GetCommand() == bepb.CommandType_Progress {
+					mgr.updateProgress(msg.RoomId, msg.Status)
+					break
+				}
+
+				if msg.Status.GetCommand() == bepb.CommandType_Sync {
+					mgr.answerSync(msg)
+					break
+				}
+
 				log.Printf("Player %d status: %v", msg.Id, msg.Status.GetCommand())
 				if msg.Status.GetCommand() == bepb.CommandType_Ready {
 					// Update the ready status of the current player
 					mgr.playerLock.Lock()
-					mgr.ready[msg.Id] = PLAYER_READY
+					if room, exists := mgr.rooms[msg.RoomId]; exists {
+						room.ready[msg.Id] = PLAYER_READY
+					}
 					mgr.playerLock.Unlock()
 
-					// Check if they're all ready
-					if mgr.playersReady() {
-						go mgr.getNextSong(nextSong)
-					}
+					mgr.tryNextSong(msg.RoomId, nextSong)
 				}
 
-			case control, ok := <-nextSong:
-				// Send the song popped off the playlist to all the players and
-				// then reset their ready flags
-				if ok && control.GetCommand() == bepb.CommandType_Play {
-					mgr.playerLock.Lock()
-					for id, state := range mgr.streams {
-						go sendToStream(&control, state.out)
-						mgr.ready[id] = PLAYER_BUSY
+			case roomId := <-mgr.recheck:
+				mgr.tryNextSong(roomId, nextSong)
+
+			case timeout := <-mgr.timeouts:
+				mgr.stopWaiting(timeout)
+				mgr.tryNextSong(timeout.RoomId, nextSong)
+
+			case out, ok := <-nextSong:
+				if !ok {
+					break
+				}
+
+				if out.Control.GetCommand() == bepb.CommandType_Play {
+					mgr.attachUpcoming(out.RoomId, out.Control)
+				}
+
+				// Send the song popped off the playlist to all the players in
+				// the room and then reset their ready flags
+				mgr.playerLock.Lock()
+				if room, exists := mgr.rooms[out.RoomId]; exists {
+					room.fetching = false
+					if out.Control.GetCommand() == bepb.CommandType_Play {
+						if room.timer != nil {
+							room.timer.Stop()
+							room.timer = nil
+						}
+						room.generation++
+						mgr.scheduleStart(out.Control)
+
+						for id, state := range room.streams {
+							state.send(out.Control)
+							room.ready[id] = PLAYER_BUSY
+						}
 					}
-					mgr.playerLock.Unlock()
+				}
+				mgr.playerLock.Unlock()
+
+				// players may have become ready while the song was fetched
+				if out.Control.GetCommand() != bepb.CommandType_Play {
+					mgr.tryNextSong(out.RoomId, nextSong)
 				}
 			}
 		}
@@ -168,31 +442,245 @@ func (mgr *playerManager) start() {
 }
 
 /*
- * Get the next song from the playlist. This should run in a separate goroutine
- * because it will block and wait for more songs to be added to the playist if
- * the function is called while the playlist is empty.
+ * Sets the time at which the players should start the song in the control.
+ * Nothing is scheduled when there is no song to play.
+ */
+func (mgr *playerManager) scheduleStart(control *bepb.PlayerControl) {
+	if mgr.startDelay <= 0 || control.GetSong().GetSongId() == 0 {
+		return
+	}
+
+	control.StartAt = time.Now().Add(mgr.startDelay).UnixNano()
+}
+
+/*
+ * Tells the room's players about the song coming up next if it changed since
+ * they were last told. Should be called whenever the room's queue changes.
  */
-func (mgr *playerManager) getNextSong(nextSong chan<- bepb.PlayerControl) {
-	// Wait for there to be at least one song in the playlist
-	mgr.queueMgr.WaitForMoreSongs()
+func (mgr *playerManager) preview(roomId uint32) {
+	mgr.sendToPlayers(roomId, &bepb.PlayerControl{Command: bepb.CommandType_Preload})
+}
+
+/*
+ * Adds the song queued after the one playing to the control so that the
+ * players can load it ahead of time. Returns false if the players were already
+ * told that the song comes up next.
+ */
+func (mgr *playerManager) attachUpcoming(roomId uint32, control *bepb.PlayerControl) bool {
+	playing := control.GetSong()
+	if control.GetCommand() == bepb.CommandType_Preload {
+		playing = mgr.queueMgr.NowPlaying(roomId)
+	}
+
+	// nothing comes up next while nothing is playing
+	upcoming := mgr.queueMgr.PeekQueue(roomId)
+	if playing == nil || upcoming.GetSongId() == playing.GetSongId() {
+		upcoming = nil
+	}
+
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	room, exists := mgr.rooms[roomId]
+	if !exists {
+		return false
+	}
+
+	control.Upcoming = upcoming
+	changed := room.upcoming != upcoming.GetSongId()
+	room.upcoming = upcoming.GetSongId()
+	return changed
+}
+
+/*
+ * Answers a clock sync ping with the backend's time so the player can work out
+ * how far its clock is from the backend's
+ */
+func (mgr *playerManager) answerSync(msg playerMessage) {
+	control := &bepb.PlayerControl{
+		Command:    bepb.CommandType_Sync,
+		SentAt:     msg.Status.GetSentAt(),
+		ServerTime: time.Now().UnixNano(),
+	}
+
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	if state, exists := mgr.players[msg.Id]; exists {
+		state.send(control)
+	}
+}
+
+/*
+ * Records the playback progress reported by one of the room's players
+ */
+func (mgr *playerManager) updateProgress(roomId uint32, status *bepb.PlayerStatus) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	room, exists := mgr.rooms[roomId]
+	if !exists {
+		return
+	}
+
+	room.progress = &playerProgress{
+		songId:   status.GetSongId(),
+		position: status.GetPosition(),
+		duration: status.GetDuration(),
+		paused:   status.GetPaused(),
+		reported: time.Now(),
+	}
+}
+
+/*
+ * Claims the failure of a song in the room so that it's only dealt with once
+ * no matter how many of the room's players fail to play it. Returns false if
+ * the failure was already claimed.
+ */
+func (mgr *playerManager) claimFailure(roomId uint32, songId uint32) bool {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	room, exists := mgr.rooms[roomId]
+	if !exists || room.failed == songId {
+		return false
+	}
+
+	room.failed = songId
+	return true
+}
+
+/*
+ * Returns the playback progress of the song in the room. The position is moved
+ * on by the time that passed since it was reported unless playback is paused.
+ * Returns false if the room's players haven't reported progress on the song.
+ */
+func (mgr *playerManager) playback(roomId uint32, songId uint32) (playerProgress, bool) {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	room, exists := mgr.rooms[roomId]
+	if !exists || room.progress == nil || room.progress.songId != songId {
+		return playerProgress{}, false
+	}
+
+	progress := *room.progress
+	progress.position = progress.positionAt(time.Now())
+	return progress, true
+}
+
+/*
+ * Estimates the playback position at the given time from the last report. The
+ * position never runs past the end of the song.
+ */
+func (progress *playerProgress) positionAt(now time.Time) float64 {
+	position := progress.position
+	if !progress.paused {
+		position += now.Sub(progress.reported).Seconds()
+	}
+
+	if progress.duration > 0 && position > progress.duration {
+		position = progress.duration
+	}
+
+	return position
+}
+
+/*
+ * Start fetching the next song for the room if all of its players are ready.
+ * Otherwise start the grace period for the players that aren't ready yet.
+ */
+func (mgr *playerManager) tryNextSong(roomId uint32, nextSong chan<- roomControl) {
+	if mgr.playersReady(roomId) {
+		mgr.playerLock.Lock()
+		room, exists := mgr.rooms[roomId]
+		if !exists || room.fetching {
+			mgr.playerLock.Unlock()
+			return
+		}
+		room.fetching = true
+		mgr.playerLock.Unlock()
+
+		go mgr.getNextSong(roomId, room.done, nextSong)
+		return
+	}
+
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	room, exists := mgr.rooms[roomId]
+	if !exists || room.timer != nil || !anyReady(room) {
+		return
+	}
+
+	timeout := graceTimeout{RoomId: roomId, Generation: room.generation}
+	room.timer = time.AfterFunc(mgr.readyTimeout, func() {
+		select {
+		case mgr.timeouts <- timeout:
+		case <-mgr.stopped:
+		}
+	})
+}
+
+/*
+ * The grace period ran out so stop waiting on the players in the room that are
+ * still busy. They are treated as ready until the next song is sent out.
+ */
+func (mgr *playerManager) stopWaiting(timeout graceTimeout) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	room, exists := mgr.rooms[timeout.RoomId]
+	if !exists || room.generation != timeout.Generation {
+		return
+	}
+
+	room.timer = nil
+	for id, ready := range room.ready {
+		if ready == PLAYER_BUSY {
+			log.Printf("Stopped waiting for player %d in room %d after %v", id, timeout.RoomId, mgr.readyTimeout)
+			room.ready[id] = PLAYER_READY
+		}
+	}
+}
+
+/*
+ * Get the next song from the room's playlist. This should run in a separate
+ * goroutine because it will block and wait for more songs to be added to the
+ * playist if the function is called while the playlist is empty. A control is
+ * sent back so the manager knows the fetch has finished, unless the room's
+ * players all left and closed the done channel before a song came.
+ */
+func (mgr *playerManager) getNextSong(roomId uint32, done <-chan struct{}, nextSong chan<- roomControl) {
+	// Wait for there to be at least one song in the playlist. A player that
+	// joins the room later starts a wait of its own.
+	if !mgr.queueMgr.WaitForMoreSongs(roomId, done) {
+		log.Printf("Stopped waiting for songs in room %d after its players left", roomId)
+		return
+	}
 
 	// Do a final check to see if all players are ready for the next song
-	if mgr.playersReady() {
-		song := mgr.queueMgr.PopQueue()
-		mgr.queueMgr.SavePlaylist(queuer.QueueSnapshot)
-		log.Println("Popped song")
-		control := bepb.PlayerControl{}
+	control := &bepb.PlayerControl{Command: bepb.CommandType_None}
+	if mgr.playersReady(roomId) {
+		song := mgr.queueMgr.PopQueue(roomId)
+		mgr.queueMgr.SaveSnapshot(roomId)
+		log.Printf("Popped song for room %d", roomId)
 
 		if song != nil {
 			control.Command = bepb.CommandType_Play
 			control.Song = song
+
+			// a song played again after the players came back starts where
+			// they left off
+			if progress, ok := mgr.playback(roomId, song.SongId); ok {
+				control.Seconds = progress.position
+			}
 		} else {
-			mgr.queueMgr.ClearNowPlaying()
-			control.Command = bepb.CommandType_None
+			mgr.queueMgr.ClearNowPlaying(roomId)
 		}
-
-		nextSong <- control
 	}
+
+	nextSong <- roomControl{RoomId: roomId, Control: control}
 }
 
 /*
@@ -203,36 +691,103 @@ func (mgr *playerManager) stop() {
 	mgr.playerLock.Lock()
 	defer mgr.playerLock.Unlock()
 
-	for _, state := range mgr.streams {
+	for _, state := range mgr.players {
 		state.stop <- struct{}{}
 	}
 
+	// nothing is left to hear about the grace periods running out
+	for _, room := range mgr.rooms {
+		if room.timer != nil {
+			room.timer.Stop()
+		}
+	}
+	close(mgr.stopped)
+
 	close(mgr.fanIn)
 }
 
 /*
- * Check to see if enough remote players have returned a ready status to play
- * the next song
+ * Check to see if enough remote players in the room have returned a ready
+ * status to play the next song
  */
-func (mgr *playerManager) playersReady() bool {
+func (mgr *playerManager) playersReady(roomId uint32) bool {
 	mgr.playerLock.RLock()
 	defer mgr.playerLock.RUnlock()
 
-	if len(mgr.ready) == 0 {
+	room, exists := mgr.rooms[roomId]
+	if !exists || len(room.ready) == 0 {
 		return false
 	}
 
 	allReady := true
-	//for _, ready := range mgr.ready {
-	//	allReady = allReady && ready
-	//}
+	for _, ready := range room.ready {
+		allReady = allReady && ready
+	}
 
 	return allReady
 }
 
 /*
- * Provides a goroutine for sending out the player control to the given stream
+ * Returns true if at least one of the players in the room is ready. The
+ * caller must hold the player lock.
+ */
+func anyReady(room *playerRoom) bool {
+	for _, ready := range room.ready {
+		if ready == PLAYER_READY {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Queue up a control to be sent to the player. The controls are written to
+ * the stream by writeControls so that the player gets them one at a time in
+ * the order they were sent without holding up the manager.
+ */
+func (state *playerState) send(control *bepb.PlayerControl) {
+	state.sendLock.Lock()
+	state.queued = append(state.queued, control)
+	state.sendLock.Unlock()
+
+	select {
+	case state.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+ * Write the queued controls to the player's stream in order until the player
+ * is removed
+ */
+func (state *playerState) writeControls() {
+	defer close(state.written)
+
+	for {
+		select {
+		case <-state.wake:
+		case <-state.left:
+			return
+		}
+
+		state.sendLock.Lock()
+		controls := state.queued
+		state.queued = nil
+		state.sendLock.Unlock()
+
+		for _, control := range controls {
+			if err := state.out.Send(control); err != nil {
+				log.Printf("Error sending %v to remote player: %v", control.GetCommand(), err)
+			}
+		}
+	}
+}
+
+/*
+ * Stop the writer and wait for it to finish any send it's in the middle of
  */
-func sendToStream(control *bepb.PlayerControl, out bepb.YtbBePlayer_SongPlayerServer) {
-	out.Send(control)
+func (state *playerState) stopWriting() {
+	close(state.left)
+	<-state.written
 }
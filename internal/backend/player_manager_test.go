@@ -0,0 +1,434 @@
+package backend
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	queuer "github.com/nguyenmq/ytbox-go/internal/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+func setupPlayerManager() *playerManager {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.RoundRobinStrategy, nil)
+
+	mgr := new(playerManager)
+	mgr.init(queueMgr, time.Second, time.Minute, time.Second)
+	return mgr
+}
+
+func TestPlayersReady_whenOnePlayerBusy_returnsFalse(t *testing.T) {
+	mgr := setupPlayerManager()
+	first, _ := mgr.add(testRoomId, nil)
+	mgr.add(testRoomId, nil)
+
+	mgr.rooms[testRoomId].ready[first] = PLAYER_READY
+
+	if mgr.playersReady(testRoomId) {
+		t.Fatalf("Players should not be ready while one of them is busy")
+	}
+}
+
+func TestPlayersReady_whenAllPlayersReady_returnsTrue(t *testing.T) {
+	mgr := setupPlayerManager()
+	first, _ := mgr.add(testRoomId, nil)
+	second, _ := mgr.add(testRoomId, nil)
+
+	mgr.rooms[testRoomId].ready[first] = PLAYER_READY
+	mgr.rooms[testRoomId].ready[second] = PLAYER_READY
+
+	if !mgr.playersReady(testRoomId) {
+		t.Fatalf("Players should be ready once all of them are ready")
+	}
+}
+
+func TestPlayersReady_ignoresOtherRooms(t *testing.T) {
+	mgr := setupPlayerManager()
+	first, _ := mgr.add(testRoomId, nil)
+	mgr.add(testRoomId+1, nil)
+
+	mgr.rooms[testRoomId].ready[first] = PLAYER_READY
+
+	if !mgr.playersReady(testRoomId) {
+		t.Fatalf("A busy player in another room should not block this room")
+	}
+}
+
+func TestStopWaiting_marksSlowPlayersReady(t *testing.T) {
+	mgr := setupPlayerManager()
+	first, _ := mgr.add(testRoomId, nil)
+	mgr.add(testRoomId, nil)
+
+	mgr.rooms[testRoomId].ready[first] = PLAYER_READY
+	mgr.stopWaiting(graceTimeout{RoomId: testRoomId, Generation: 0})
+
+	if !mgr.playersReady(testRoomId) {
+		t.Fatalf("Slow players should be treated as ready after the grace period")
+	}
+}
+
+/*
+ * A grace period still running when the manager stops should never fire
+ */
+func TestStop_stopsGraceTimers(t *testing.T) {
+	mgr := setupPlayerManager()
+	first, firstStop := mgr.add(testRoomId, nil)
+	_, secondStop := mgr.add(testRoomId, nil)
+	go func() { <-firstStop }()
+	go func() { <-secondStop }()
+
+	mgr.rooms[testRoomId].ready[first] = PLAYER_READY
+	mgr.tryNextSong(testRoomId, nil)
+	timer := mgr.rooms[testRoomId].timer
+	if timer == nil {
+		t.Fatal("Expected the slow player to be given a grace period")
+	}
+
+	mgr.stop()
+	if timer.Stop() {
+		t.Error("Expected the grace period to be stopped with the manager")
+	}
+}
+
+func TestStopWaiting_whenGenerationIsStale_keepsWaiting(t *testing.T) {
+	mgr := setupPlayerManager()
+	first, _ := mgr.add(testRoomId, nil)
+	mgr.add(testRoomId, nil)
+
+	mgr.rooms[testRoomId].ready[first] = PLAYER_READY
+	mgr.rooms[testRoomId].generation = 1
+	mgr.stopWaiting(graceTimeout{RoomId: testRoomId, Generation: 0})
+
+	if mgr.playersReady(testRoomId) {
+		t.Fatalf("A grace period from an earlier song should not mark players ready")
+	}
+}
+
+func TestPlayback_movesPositionOnWhilePlaying(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.add(testRoomId, nil)
+
+	mgr.updateProgress(testRoomId, &bepb.PlayerStatus{Command: bepb.CommandType_Progress, SongId: 1, Position: 30, Duration: 200})
+	mgr.rooms[testRoomId].progress.reported = time.Now().Add(-5 * time.Second)
+
+	progress, ok := mgr.playback(testRoomId, 1)
+	if !ok || progress.position < 35 || progress.position > 36 {
+		t.Errorf("Expected position of about 35 seconds but got %f", progress.position)
+	}
+}
+
+func TestPlayback_whenPaused_keepsPosition(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.add(testRoomId, nil)
+
+	mgr.updateProgress(testRoomId, &bepb.PlayerStatus{Command: bepb.CommandType_Progress, SongId: 1, Position: 30, Duration: 200, Paused: true})
+	mgr.rooms[testRoomId].progress.reported = time.Now().Add(-5 * time.Second)
+
+	progress, ok := mgr.playback(testRoomId, 1)
+	if !ok || progress.position != 30 || !progress.paused {
+		t.Errorf("Expected paused position of 30 seconds but got %f", progress.position)
+	}
+}
+
+func TestPlayback_whenSongChanged_returnsFalse(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.add(testRoomId, nil)
+
+	mgr.updateProgress(testRoomId, &bepb.PlayerStatus{Command: bepb.CommandType_Progress, SongId: 1, Position: 30, Duration: 200})
+
+	if _, ok := mgr.playback(testRoomId, 2); ok {
+		t.Error("Progress of an earlier song should not be returned")
+	}
+}
+
+func TestPositionAt_stopsAtEndOfSong(t *testing.T) {
+	progress := playerProgress{position: 190, duration: 200, reported: time.Now()}
+
+	if position := progress.positionAt(progress.reported.Add(time.Minute)); position != 200 {
+		t.Errorf("Expected position to stop at 200 seconds but got %f", position)
+	}
+}
+
+func TestRemove_whenLastPlayerLeaves_holdsSong(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+	mgr.queueMgr.PopQueue(testRoomId)
+
+	id, _ := mgr.add(testRoomId, nil)
+	mgr.updateProgress(testRoomId, &bepb.PlayerStatus{Command: bepb.CommandType_Progress, SongId: 1, Position: 30, Duration: 200})
+	mgr.remove(id)
+
+	if mgr.queueMgr.NowPlaying(testRoomId) == nil {
+		t.Fatalf("The song should be held after the last player leaves")
+	}
+
+	// a player coming back picks the song up from the same position
+	mgr.add(testRoomId, nil)
+	progress, ok := mgr.playback(testRoomId, 1)
+	if !ok || progress.position < 30 || progress.position > 31 {
+		t.Errorf("Expected position of about 30 seconds but got %f", progress.position)
+	}
+
+	if song := mgr.queueMgr.PopQueue(testRoomId); song == nil || song.SongId != 1 {
+		t.Errorf("Expected the held song to be played again but got %v", song)
+	}
+}
+
+func TestRemove_whenGraceRunsOut_clearsNowPlaying(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.reconnectGrace = 10 * time.Millisecond
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+	mgr.queueMgr.PopQueue(testRoomId)
+
+	id, _ := mgr.add(testRoomId, nil)
+	mgr.remove(id)
+	time.Sleep(50 * time.Millisecond)
+
+	if song := mgr.queueMgr.NowPlaying(testRoomId); song != nil {
+		t.Errorf("Expected nothing to be playing once the grace ran out but got %v", song)
+	}
+}
+
+func TestRejoin_whenStillPlaying_cancelsResume(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 2, UserId: 2, RoomId: testRoomId})
+	mgr.queueMgr.PopQueue(testRoomId)
+
+	id, _ := mgr.add(testRoomId, nil)
+	mgr.remove(id)
+
+	mgr.add(testRoomId, nil)
+	mgr.rejoin(testRoomId, &bepb.PlayerStatus{Command: bepb.CommandType_Join, SongId: 1, Position: 45})
+
+	if song := mgr.queueMgr.PopQueue(testRoomId); song == nil || song.SongId != 2 {
+		t.Errorf("Expected the next song after the one still playing but got %v", song)
+	}
+}
+
+func TestRejoin_whenIdle_dropsHeldSong(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 2, UserId: 2, RoomId: testRoomId})
+	mgr.queueMgr.PopQueue(testRoomId)
+
+	id, _ := mgr.add(testRoomId, nil)
+	mgr.updateProgress(testRoomId, &bepb.PlayerStatus{SongId: 1, Position: 200, Duration: 210})
+	mgr.remove(id)
+
+	// the player finished the song while it was away
+	mgr.add(testRoomId, nil)
+	mgr.rejoin(testRoomId, &bepb.PlayerStatus{Command: bepb.CommandType_Join})
+
+	if song := mgr.queueMgr.NowPlaying(testRoomId); song != nil {
+		t.Errorf("Expected the held song to be dropped but got %v", song)
+	}
+
+	if song := mgr.queueMgr.PopQueue(testRoomId); song == nil || song.SongId != 2 {
+		t.Errorf("Expected the next song after the held one but got %v", song)
+	}
+}
+
+/*
+ * A fetch left waiting on an empty queue when the room's players leave should
+ * give up rather than pop a song for the players who join later
+ */
+func TestGetNextSong_whenPlayersLeave_stopsWaiting(t *testing.T) {
+	mgr := setupPlayerManager()
+	id, _ := mgr.add(testRoomId, nil)
+	mgr.rooms[testRoomId].ready[id] = PLAYER_READY
+
+	done := mgr.rooms[testRoomId].done
+	nextSong := make(chan roomControl, 1)
+	finished := make(chan struct{})
+	go func() {
+		mgr.getNextSong(testRoomId, done, nextSong)
+		close(finished)
+	}()
+
+	mgr.remove(id)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the fetch to stop waiting once the players left")
+	}
+
+	second, _ := mgr.add(testRoomId, nil)
+	mgr.rooms[testRoomId].ready[second] = PLAYER_READY
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+
+	if len(nextSong) != 0 || mgr.queueMgr.Len(testRoomId) != 1 {
+		t.Errorf("Expected the song to be left for the new player but got %d in the queue", mgr.queueMgr.Len(testRoomId))
+	}
+}
+
+func TestScheduleStart_setsStartInTheFuture(t *testing.T) {
+	mgr := setupPlayerManager()
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Play, Song: &cmpb.Song{SongId: 1}}
+
+	before := time.Now()
+	mgr.scheduleStart(control)
+
+	startAt := time.Unix(0, control.StartAt)
+	if startAt.Before(before.Add(mgr.startDelay)) || startAt.After(time.Now().Add(mgr.startDelay)) {
+		t.Errorf("Expected the song to start %v from now but got %v", mgr.startDelay, startAt)
+	}
+}
+
+func TestScheduleStart_whenNoSong_leavesStartUnset(t *testing.T) {
+	mgr := setupPlayerManager()
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Next}
+
+	mgr.scheduleStart(control)
+
+	if control.StartAt != 0 {
+		t.Errorf("Expected no start time without a song but got %d", control.StartAt)
+	}
+}
+
+func TestClaimFailure_onlyClaimsSongOnce(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.add(testRoomId, nil)
+
+	if !mgr.claimFailure(testRoomId, testSongId) {
+		t.Fatalf("The first failure of a song should be claimed")
+	}
+
+	if mgr.claimFailure(testRoomId, testSongId) {
+		t.Errorf("Another player failing the same song should not be claimed again")
+	}
+
+	if !mgr.claimFailure(testRoomId, testSongId+1) {
+		t.Errorf("The failure of the next song should be claimed")
+	}
+}
+
+func TestAttachUpcoming_addsNextSongToPlay(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.add(testRoomId, nil)
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 2, UserId: 2, RoomId: testRoomId})
+	song := mgr.queueMgr.PopQueue(testRoomId)
+
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Play, Song: song}
+	mgr.attachUpcoming(testRoomId, control)
+
+	if control.Upcoming.GetSongId() != 2 {
+		t.Errorf("Expected song 2 to come up next but got %v", control.Upcoming)
+	}
+}
+
+func TestAttachUpcoming_onlyPreloadsChanges(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.add(testRoomId, nil)
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1, RoomId: testRoomId})
+	mgr.queueMgr.AddSong(&cmpb.Song{SongId: 2, UserId: 2, RoomId: testRoomId})
+	mgr.queueMgr.PopQueue(testRoomId)
+
+	if !mgr.attachUpcoming(testRoomId, &bepb.PlayerControl{Command: bepb.CommandType_Preload}) {
+		t.Fatalf("The players should be told about a new upcoming song")
+	}
+
+	if mgr.attachUpcoming(testRoomId, &bepb.PlayerControl{Command: bepb.CommandType_Preload}) {
+		t.Errorf("The players should not be told about the same upcoming song twice")
+	}
+
+	// the upcoming song was removed after the players were told about it
+	mgr.queueMgr.RemoveSong(testRoomId, 2, 2)
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Preload}
+	if !mgr.attachUpcoming(testRoomId, control) || control.Upcoming != nil {
+		t.Errorf("The players should be told that no song comes up next but got %v", control.Upcoming)
+	}
+}
+
+/*
+ * A player stream that records the controls sent to it and whether any of
+ * them were sent at the same time
+ */
+type fakePlayerStream struct {
+	grpc.ServerStream
+	lock       sync.Mutex
+	sending    int
+	overlapped bool
+	sent       []*bepb.PlayerControl
+}
+
+func (stream *fakePlayerStream) Send(control *bepb.PlayerControl) error {
+	stream.lock.Lock()
+	stream.sending++
+	stream.overlapped = stream.overlapped || stream.sending > 1
+	stream.lock.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	stream.lock.Lock()
+	stream.sent = append(stream.sent, control)
+	stream.sending--
+	stream.lock.Unlock()
+	return nil
+}
+
+func (stream *fakePlayerStream) sentCount() int {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	return len(stream.sent)
+}
+
+func (stream *fakePlayerStream) Recv() (*bepb.PlayerStatus, error) {
+	return nil, nil
+}
+
+func TestSendToPlayers_sendsOneAtATimeInOrder(t *testing.T) {
+	mgr := setupPlayerManager()
+	mgr.start()
+	defer mgr.stop()
+
+	stream := new(fakePlayerStream)
+	id, _ := mgr.add(testRoomId, stream)
+
+	count := 20
+	for i := 0; i < count; i++ {
+		mgr.sendToPlayers(testRoomId, &bepb.PlayerControl{Command: bepb.CommandType_Pause, SentAt: int64(i)})
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); stream.sentCount() < count && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mgr.remove(id)
+
+	if stream.overlapped {
+		t.Error("Expected the controls to be sent one at a time")
+	}
+
+	if len(stream.sent) != count {
+		t.Fatal("Expected", count, "controls to be sent but got", len(stream.sent))
+	}
+
+	for i, control := range stream.sent {
+		if control.GetSentAt() != int64(i) {
+			t.Error("Expected control", i, "to be sent in order but got", control.GetSentAt())
+		}
+	}
+}
+
+/*
+ * Nothing should be written to a player's stream once it's been removed
+ */
+func TestRemove_stopsSendingToPlayer(t *testing.T) {
+	mgr := setupPlayerManager()
+	stream := new(fakePlayerStream)
+	id, _ := mgr.add(testRoomId, stream)
+	state := mgr.players[id]
+
+	mgr.remove(id)
+	state.send(&bepb.PlayerControl{Command: bepb.CommandType_Pause})
+
+	time.Sleep(50 * time.Millisecond)
+	if stream.sentCount() != 0 {
+		t.Error("Expected nothing to be sent to a removed player but got", stream.sentCount())
+	}
+}
@@ -8,28 +8,58 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/rickb777/date/period"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	queuer "github.com/nguyenmq/ytbox-go/internal/backend/song_queuer"
 	db "github.com/nguyenmq/ytbox-go/internal/database"
+	"github.com/nguyenmq/ytbox-go/internal/library"
 	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+	"github.com/nguyenmq/ytbox-go/internal/provider"
 )
 
 const (
-	LogPrefix      string = "ytb-be" // logging prefix name
-	allowedMinutes        = 10
+	LogPrefix        string = "ytb-be"         // logging prefix name
+	allowedMinutes          = 10               // longest song in minutes allowed in new rooms
+	activeUserWindow        = 30 * time.Minute // how recently a user must have been seen to count as active
+	maxVolume               = 100              // loudest volume the players can be set to
+	searchResults           = 5                // songs returned by a search
+	libraryResults          = 25               // tracks returned by a library search
 )
 
+/*
+ * Settings used to create a backend server
+ */
+type ServerConfig struct {
+	Addr           string          // address and port to listen on
+	LoadFile       string          // serialized playlist to load on start up
+	DbPath         string          // path to the sqlite database
+	YtApiKey       string          // YouTube data api key
+	ReadyTimeout   time.Duration   // how long to wait on slow players before moving on
+	ReconnectGrace time.Duration   // how long to hold a room's song after its last player leaves
+	StartDelay     time.Duration   // how far ahead songs are scheduled to start so players start together
+	Strategy       queuer.Strategy // queuing strategy of rooms that don't pick one
+	SkipShare      float64         // share of a room's active users needed to skip a song
+	Cooldown       time.Duration   // how long after a song plays before it can be submitted again
+	PlaylistCap    int             // most songs a user can have queued in a room after adding a playlist
+	LibraryDirs    []string        // directories of the local music library
+	LibraryRescan  time.Duration   // how often the local music library is scanned for changes
+	CliControl     bool            // let requests that only name a room control it
+}
+
 /*
  * Implements the backend rpc server interface
  */
@@ -42,6 +72,14 @@ type BackendServer struct {
 	playerMgr *playerManager           // player manager
 	streamWG  sync.WaitGroup           // wait group for streaming goroutines
 	fetcher   *SongFetcher             // Song metadata fetcher
+	strategy  queuer.Strategy          // queuing strategy of new rooms
+	skipVotes *skipVoter               // votes to skip the playing songs
+	skipShare float64                  // share of active users needed to skip a song
+	cooldown  time.Duration            // how long after a song plays before it can be submitted again
+	queueCap  int                      // most songs a user can have queued after adding a playlist
+	library   *library.Indexer         // indexer of the local music library, nil without one
+	rescan    time.Duration            // how often the local music library is scanned
+	cliAccess bool                     // true if requests that only name a room may control it
 	bepb.UnimplementedYtbBackendServer
 	bepb.UnimplementedYtbBePlayerServer
 }
@@ -49,14 +87,14 @@ type BackendServer struct {
 /*
  * Create a new yt_box backend server
  */
-func NewServer(addr string, loadFile string, dbPath string, ytApiKey string) *BackendServer {
+func NewServer(config ServerConfig) *BackendServer {
 	var err error
 
 	// initialize the backend server struct
 	server := new(BackendServer)
-	server.listener, err = net.Listen("tcp", addr)
+	server.listener, err = net.Listen("tcp", config.Addr)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s with error: %v", addr, err)
+		log.Fatalf("Failed to listen on %s with error: %v", config.Addr, err)
 	}
 
 	// initialize the rpc server
@@ -64,30 +102,49 @@ func NewServer(addr string, loadFile string, dbPath string, ytApiKey string) *Ba
 	bepb.RegisterYtbBackendServer(server.beServer, server)
 	bepb.RegisterYtbBePlayerServer(server.beServer, server)
 
-	// initialize the song queue
-	server.queueMgr = new(queuer.SongQueueManager)
-	server.queueMgr.Init(queuer.NewRoundRobinQueuer())
-
 	// initialize the database manager
 	server.dbManager = new(db.SqliteManager)
-	server.dbManager.Init(dbPath)
+	server.dbManager.Init(config.DbPath)
+
+	// initialize the song queue and restore the queues saved in the database
+	server.queueMgr = new(queuer.SongQueueManager)
+	server.strategy = config.Strategy
+	server.queueMgr.Init(config.Strategy, server.dbManager)
+	server.queueMgr.LoadSnapshots()
 
 	// initialize the user identity cache
 	server.userCache = new(UserCache)
 	server.userCache.Init()
 
+	// initialize the skip votes
+	server.skipVotes = new(skipVoter)
+	server.skipVotes.init()
+	server.skipShare = config.SkipShare
+	server.cooldown = config.Cooldown
+	server.queueCap = config.PlaylistCap
+	server.cliAccess = config.CliControl
+
+	// index the local music library
+	if len(config.LibraryDirs) > 0 {
+		server.library = library.NewIndexer(config.LibraryDirs, server.dbManager)
+		server.rescan = config.LibraryRescan
+	}
+
+	// restore the queuing strategy of every room
+	server.loadRoomStrategies()
+
 	// load a snapshot playlist if provided
-	if loadFile != "" {
-		server.loadPlaylistFromFile(loadFile)
+	if config.LoadFile != "" {
+		server.loadPlaylistFromFile(config.LoadFile)
 	}
 
 	// initialize the player manager
 	server.playerMgr = new(playerManager)
-	server.playerMgr.init(server.queueMgr)
+	server.playerMgr.init(server.queueMgr, config.ReadyTimeout, config.ReconnectGrace, config.StartDelay)
 
 	// initialize the song fetcher
 	server.fetcher = new(SongFetcher)
-	server.fetcher.init(ytApiKey)
+	server.fetcher.init(config.YtApiKey)
 
 	return server
 }
@@ -97,6 +154,9 @@ func NewServer(addr string, loadFile string, dbPath string, ytApiKey string) *Ba
  */
 func (s *BackendServer) Serve() {
 	s.playerMgr.start()
+	if s.library != nil {
+		s.library.Start(s.rescan)
+	}
 	s.beServer.Serve(s.listener)
 }
 
@@ -107,11 +167,19 @@ func (s *BackendServer) Stop() {
 	// stop the player manager
 	s.playerMgr.stop()
 
+	// stop scanning the music library
+	if s.library != nil {
+		s.library.Stop()
+	}
+
 	// wait for all the rpc streaming connections to close
 	s.streamWG.Wait()
 
 	// stop the rpc server
 	s.beServer.GracefulStop()
+
+	// finish writing the queues to the database
+	s.queueMgr.Close()
 }
 
 /*
@@ -131,25 +199,238 @@ func (s *BackendServer) SendSong(con context.Context, sub *bepb.Submission) (*be
 		return response, nil
 	}
 
+	if sub.GetExpandPlaylist() && s.fetcher.isPlaylist(sub.Link) {
+		return s.sendPlaylist(sub, song.Username, song.RoomId), nil
+	}
+
 	err := s.fetcher.fetchSongData(sub.Link, song)
-	if err != nil {
+	if errors.Is(err, provider.ErrLiveStream) || errors.Is(err, provider.ErrUpcomingStream) {
+		response.Message = err.Error()
+		log.Printf("Rejected %s in room %d: %v", sub.Link, song.RoomId, err)
+		return response, nil
+	} else if err != nil && s.fetcher.isPlaylist(sub.Link) {
+		response.Message = "That link is a playlist. Submit it as a playlist to queue its songs."
+		log.Println(err.Error())
+		return response, nil
+	} else if err != nil {
 		response.Message = "Failed to fetch metadata for your song. Please check your link."
 		log.Println(err.Error())
 		return response, nil
 	}
 
+	if response.Message = s.queueSong(song); response.Message != "" {
+		return response, nil
+	}
+
 	response.Success = true
 	response.Message = "Success"
-	s.queueMgr.AddSong(song)
-	s.dbManager.AddSong(song)
-	s.queueMgr.SavePlaylist(queuer.QueueSnapshot)
-	log.Printf("Song data: { %v}", song)
+	s.queueMgr.SaveSnapshot(song.RoomId)
+	s.playerMgr.preview(song.RoomId)
 
 	return response, nil
 }
 
 /*
- * Load a playlist from a serialized protobuf file
+ * Queue each song of the submitted playlist as if the user had submitted it on
+ * its own. Songs are only queued until the user has as many songs waiting in
+ * the room as the playlist cap allows.
+ */
+func (s *BackendServer) sendPlaylist(sub *bepb.Submission, username string, roomId uint32) *bepb.Error {
+	response := &bepb.Error{Success: false}
+
+	if s.queueCap <= 0 {
+		response.Message = "Playlists can't be submitted. Please submit their songs one at a time."
+		return response
+	}
+
+	allowance := s.queueCap - s.queueMgr.CountUserSongs(roomId, sub.UserId)
+	if allowance <= 0 {
+		response.Message = fmt.Sprintf("You already have %d or more songs queued. Wait for some of them to play before adding a playlist.", s.queueCap)
+		return response
+	}
+
+	// look at more songs than can be queued in case some of them are rejected
+	limit := allowance * 2
+	links, err := s.fetcher.expandPlaylist(sub.Link, limit)
+	if err != nil || len(links) == 0 {
+		response.Message = "Failed to fetch the songs of your playlist. Please check your link."
+		log.Printf("Failed to expand playlist %s: %v", sub.Link, err)
+		return response
+	}
+
+	queued, rejected := 0, 0
+	for _, link := range links {
+		if queued >= allowance {
+			break
+		}
+
+		song := &cmpb.Song{UserId: sub.UserId, Username: username, RoomId: roomId}
+		if err := s.fetcher.fetchSongData(link, song); err != nil {
+			log.Printf("Skipped %s of playlist %s: %v", link, sub.Link, err)
+			rejected++
+			continue
+		}
+
+		if message := s.queueSong(song); message != "" {
+			rejected++
+			continue
+		}
+
+		queued++
+	}
+
+	if queued == 0 {
+		response.Message = "None of the songs in your playlist could be queued."
+		return response
+	}
+
+	s.queueMgr.SaveSnapshot(roomId)
+	s.playerMgr.preview(roomId)
+
+	response.Success = true
+	response.Message = fmt.Sprintf("Queued %d songs from your playlist.", queued)
+	if rejected > 0 {
+		response.Message += fmt.Sprintf(" %d songs were skipped since they couldn't be queued.", rejected)
+	}
+	if queued == allowance && (queued+rejected < len(links) || len(links) == limit) {
+		response.Message += fmt.Sprintf(" The rest were left out since you can have at most %d songs queued.", s.queueCap)
+	}
+	log.Printf("Queued %d songs of playlist %s in room %d", queued, sub.Link, roomId)
+
+	return response
+}
+
+/*
+ * Add the song to its room's queue unless the room rejects it. Returns a
+ * message for the user explaining why the song was rejected, or an empty
+ * string if the song was queued.
+ */
+func (s *BackendServer) queueSong(song *cmpb.Song) string {
+	if message := s.checkDuration(song); message != "" {
+		log.Printf("Rejected song %s in room %d: %s", song.ServiceId, song.RoomId, message)
+		return message
+	}
+
+	if message := s.checkCooldown(song); message != "" {
+		log.Printf("Rejected repeat of song %s in room %d: %s", song.ServiceId, song.RoomId, message)
+		return message
+	}
+
+	// the song gets its id before a player can pop it
+	if err := s.dbManager.AddSong(song); err != nil {
+		log.Printf("Failed to add song %s in room %d: %v", song.ServiceId, song.RoomId, err)
+		return "Could not save that song. Please try again."
+	}
+
+	// the check and the add happen together so that two submissions of the
+	// same song can't both get in
+	message := s.queueMgr.AddSongIf(song, checkRepeat)
+	if message != "" {
+		log.Printf("Rejected repeat of song %s in room %d: %s", song.ServiceId, song.RoomId, message)
+		s.dbManager.RemoveSong(song.SongId)
+		return message
+	}
+
+	log.Printf("Song data: { %v}", song)
+
+	return ""
+}
+
+/*
+ * Checks the length of the song against the longest song allowed in its room.
+ * Returns a message for the user explaining why the song was rejected, or an
+ * empty string if the song may be queued.
+ */
+func (s *BackendServer) checkDuration(song *cmpb.Song) string {
+	roomData, err := s.dbManager.GetRoomById(song.RoomId)
+	if err != nil {
+		log.Printf("Failed to look up room %d: %v", song.RoomId, err)
+		return ""
+	}
+
+	maxDuration := roomData.Room.MaxDuration
+	if maxDuration == 0 || isValidDuration(song.Duration, maxDuration) {
+		return ""
+	}
+
+	if song.Duration == 0 {
+		return "Could not tell how long that song is. Only songs of a known length can be queued in this room."
+	}
+
+	return fmt.Sprintf("That song is too long. Songs in this room can be at most %s long.", formatSeconds(maxDuration))
+}
+
+/*
+ * Checks whether the song started playing in its room within the cooldown
+ * window. Returns a message for the user explaining why the song was rejected,
+ * or an empty string if the song may be queued.
+ */
+func (s *BackendServer) checkCooldown(song *cmpb.Song) string {
+	if s.cooldown <= 0 {
+		return ""
+	}
+
+	date, err := s.dbManager.GetLastPlayDate(song.RoomId, song.Service, song.ServiceId)
+	if err != nil || date.IsZero() {
+		return ""
+	}
+
+	if wait := s.cooldown - time.Since(date); wait > 0 {
+		return fmt.Sprintf("That song was played recently. It can be queued again in %d minutes.",
+			int(math.Ceil(wait.Minutes())))
+	}
+
+	return ""
+}
+
+/*
+ * Checks whether a song is already queued or playing in its room. The repeat
+ * is the copy of the song that is queued or playing in the room, if there is
+ * one. Runs while the queue is locked, so it must not touch the database.
+ * Returns a message for the user explaining why the song was rejected, or an
+ * empty string if the song may be queued.
+ */
+func checkRepeat(repeat *cmpb.Song, playing bool) string {
+	if playing {
+		return "That song is playing right now."
+	}
+
+	if repeat != nil {
+		return "That song is already in the queue."
+	}
+
+	return ""
+}
+
+/*
+ * Apply the queuing strategy stored for each room in the database to the
+ * room's queue
+ */
+func (s *BackendServer) loadRoomStrategies() {
+	rooms, err := s.dbManager.GetRooms()
+	if err != nil {
+		log.Printf("Failed to load rooms: %v", err)
+		return
+	}
+
+	for _, roomData := range rooms {
+		if roomData.Room.Strategy == "" {
+			continue
+		}
+
+		strategy, err := queuer.ParseStrategy(roomData.Room.Strategy)
+		if err != nil {
+			log.Printf("Room %d has an invalid strategy: %v", roomData.Room.Id, err)
+			continue
+		}
+
+		s.queueMgr.SetStrategy(roomData.Room.Id, strategy)
+	}
+}
+
+/*
+ * Load a playlist from a serialized protobuf file. Each song is added back to
+ * the queue of the room it was submitted to.
  */
 func (s *BackendServer) loadPlaylistFromFile(file string) {
 	in, err := ioutil.ReadFile(file)
@@ -166,22 +447,29 @@ func (s *BackendServer) loadPlaylistFromFile(file string) {
 	}
 
 	log.Printf("Loading songs from file \"%s\":", file)
+	rooms := make(map[uint32]bool)
 	for index, song := range playlist.Songs {
 		s.queueMgr.AddSong(song)
+		rooms[song.RoomId] = true
 		log.Printf("%3d. { %v}", index+1, song)
 	}
+
+	for roomId := range rooms {
+		s.queueMgr.SaveSnapshot(roomId)
+	}
 }
 
 /*
- * Returns the songs in the queue back to the requesting client
+ * Returns the songs in the room's queue back to the requesting client
  */
-func (s *BackendServer) GetPlaylist(con context.Context, arg *cmpb.Empty) (*bepb.Playlist, error) {
-	return s.queueMgr.GetPlaylist(), nil
+func (s *BackendServer) GetPlaylist(con context.Context, user *bepb.User) (*bepb.Playlist, error) {
+	return s.queueMgr.GetPlaylist(s.getRoomId(user)), nil
 }
 
 /*
  * Login the given user. If the userId is zero, then a new user needs to be
- * created. A successful call will echo the username and return a userId
+ * created. The first user created in a room without an admin becomes the
+ * room's admin. A successful call will echo the username and return a userId
  * greater than zero. An id of zero indicates an error occurred and the user
  * will not be considered to be logged in. An a user already exists with the
  * given id, but with a different name, then the new name shall be applied to
@@ -204,6 +492,12 @@ func (s *BackendServer) LoginUser(con context.Context, user *bepb.User) (*bepb.U
 				response.Err.Message = "Failed to add new user."
 				return response, nil
 			}
+
+			// the first user in a room without an admin gets to control its
+			// players from the web page
+			if claimed, _ := s.dbManager.ClaimRoomAdmin(user.RoomId, userData.User.UserId); claimed {
+				log.Printf("User %d is now the admin of room %d", userData.User.UserId, user.RoomId)
+			}
 		} else {
 			response.Username = user.Username
 			response.Err.Message = "Failed to add new user."
@@ -231,11 +525,12 @@ func (s *BackendServer) LoginUser(con context.Context, user *bepb.User) (*bepb.U
 }
 
 /*
- * Pops a song off the top of the queueMgr and returns it
+ * Pops a song off the top of the room's queue and returns it
  */
-func (s *BackendServer) PopQueue(con context.Context, empty *cmpb.Empty) (*cmpb.Song, error) {
-	if s.queueMgr.Len() > 0 {
-		song := s.queueMgr.PopQueue()
+func (s *BackendServer) PopQueue(con context.Context, user *bepb.User) (*cmpb.Song, error) {
+	roomId := s.getRoomId(user)
+	if s.queueMgr.Len(roomId) > 0 {
+		song := s.queueMgr.PopQueue(roomId)
 		log.Printf("Popped song: %v\n", song)
 		return song, nil
 	}
@@ -245,17 +540,17 @@ func (s *BackendServer) PopQueue(con context.Context, empty *cmpb.Empty) (*cmpb.
 }
 
 /*
- * Saves the current playlist to the given file location
+ * Saves the current playlist of the room to the given file location
  */
 func (s *BackendServer) SavePlaylist(con context.Context, fname *bepb.FilePath) (*bepb.Error, error) {
 	response := &bepb.Error{Success: false}
-	err := s.queueMgr.SavePlaylist(fname.Path)
+	err := s.queueMgr.SavePlaylist(fname.RoomId, fname.Path)
 	if err != nil {
 		response.Message = err.Error()
 		return response, nil
 	}
 
-	log.Printf("Saved current playlist to: %s", fname.Path)
+	log.Printf("Saved current playlist of room %d to: %s", fname.RoomId, fname.Path)
 	response.Success = true
 	response.Message = "Success"
 	return response, nil
@@ -274,6 +569,9 @@ func (s *BackendServer) getUserFromId(userId uint32) (string, uint32) {
 	// check the user identities cache for the name
 	username, exists := s.userCache.LookupUsername(userId)
 	if exists {
+		// every request made by a user passes through here, so it's a good
+		// place to keep track of who is still around
+		s.userCache.Touch(userId)
 		roomId, _ := s.userCache.LookupRoomId(userId)
 		return username, roomId
 	}
@@ -292,28 +590,70 @@ func (s *BackendServer) getUserFromId(userId uint32) (string, uint32) {
 }
 
 /*
- * Removes the given song from the playlist. The user identified by the song
- * eviction must match the id of the user who submitted the song.
+ * Returns the id of the room that a request applies to. The room id is used if
+ * the request provides one, otherwise the room that the user belongs to is
+ * looked up. Zero is returned if neither could be found.
+ */
+func (s *BackendServer) getRoomId(user *bepb.User) uint32 {
+	if user.GetRoomId() != 0 {
+		return user.GetRoomId()
+	}
+
+	if user.GetUserId() == 0 {
+		return 0
+	}
+
+	_, roomId := s.getUserFromId(user.GetUserId())
+	return roomId
+}
+
+/*
+ * Removes the given song from the playlist of the user's room. The user
+ * identified by the song eviction must match the id of the user who submitted
+ * the song.
  */
 func (s *BackendServer) RemoveSong(con context.Context, eviction *bepb.Eviction) (*bepb.Error, error) {
-	err := s.queueMgr.RemoveSong(eviction.GetSongId(), eviction.GetUserId())
+	roomId := s.getRoomId(&bepb.User{UserId: eviction.GetUserId()})
+	err := s.queueMgr.RemoveSong(roomId, eviction.GetSongId(), eviction.GetUserId())
 
 	if err != nil {
 		log.Printf("Failed to remove song from playlist: %v", err)
 		return &bepb.Error{Success: false, Message: err.Error()}, nil
 	} else {
 		log.Printf("Removed song: {song id: %d, user id: %d}", eviction.GetSongId(), eviction.GetUserId())
-		s.queueMgr.SavePlaylist(queuer.QueueSnapshot)
+		s.queueMgr.SaveSnapshot(roomId)
+		s.playerMgr.preview(roomId)
+		return &bepb.Error{Success: true, Message: "Success"}, nil
+	}
+}
+
+/*
+ * Moves the given song among the other songs queued by the same user in their
+ * room. The user identified by the move must match the id of the user who
+ * submitted the song.
+ */
+func (s *BackendServer) MoveSong(con context.Context, move *bepb.Move) (*bepb.Error, error) {
+	roomId := s.getRoomId(&bepb.User{UserId: move.GetUserId()})
+	err := s.queueMgr.MoveSong(roomId, move.GetSongId(), move.GetUserId(), int(move.GetOffset()))
+
+	if err != nil {
+		log.Printf("Failed to move song in playlist: %v", err)
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	} else {
+		log.Printf("Moved song: {song id: %d, user id: %d, offset: %d}",
+			move.GetSongId(), move.GetUserId(), move.GetOffset())
+		s.queueMgr.SaveSnapshot(roomId)
+		s.playerMgr.preview(roomId)
 		return &bepb.Error{Success: true, Message: "Success"}, nil
 	}
 }
 
 /*
- * Returns the song that should be considered "now playing". If there isn't a
- * current song, then an empty Song struct is returned.
+ * Returns the song that should be considered "now playing" in the room. If
+ * there isn't a current song, then an empty Song struct is returned.
  */
-func (s *BackendServer) GetNowPlaying(con context.Context, empty *cmpb.Empty) (*cmpb.Song, error) {
-	nowPlaying := s.queueMgr.NowPlaying()
+func (s *BackendServer) GetNowPlaying(con context.Context, user *bepb.User) (*cmpb.Song, error) {
+	nowPlaying := s.queueMgr.NowPlaying(s.getRoomId(user))
 
 	if nowPlaying == nil {
 		return &cmpb.Song{}, nil
@@ -323,32 +663,405 @@ func (s *BackendServer) GetNowPlaying(con context.Context, empty *cmpb.Empty) (*
 }
 
 /*
- * Forwards the command to skip the currently playing song onto the remote
- * player
+ * Returns the song playing in the user's room along with its progress. Until
+ * the room's players report on the song, it is assumed to be at the start.
  */
-func (s *BackendServer) NextSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
-	nextSong := s.queueMgr.PopQueue()
+func (s *BackendServer) GetPlayback(con context.Context, user *bepb.User) (*bepb.Playback, error) {
+	roomId := s.getRoomId(user)
+	nowPlaying := s.queueMgr.NowPlaying(roomId)
+
+	if nowPlaying == nil {
+		return &bepb.Playback{Song: &cmpb.Song{}}, nil
+	}
+
+	playback := &bepb.Playback{Song: nowPlaying, Duration: float64(nowPlaying.Duration)}
+	if progress, ok := s.playerMgr.playback(roomId, nowPlaying.SongId); ok {
+		playback.Position = progress.position
+		playback.Paused = progress.paused
+		if progress.duration > 0 {
+			playback.Duration = progress.duration
+		}
+	}
+
+	return playback, nil
+}
+
+/*
+ * Forwards the command to skip the song currently playing in the room onto the
+ * remote player. Only the room's admin can skip a song without a vote.
+ */
+func (s *BackendServer) NextSong(con context.Context, user *bepb.User) (*bepb.Error, error) {
+	roomId, response := s.authorizeControl(user)
+	if !response.Success {
+		return response, nil
+	}
+
+	nowPlaying := s.queueMgr.NowPlaying(roomId)
+	if nowPlaying == nil || !s.skipSong(roomId, nowPlaying.SongId) {
+		return &bepb.Error{Success: false, Message: "No song is currently playing."}, nil
+	}
+
+	return response, nil
+}
+
+/*
+ * Skips the song with the given id if it's still playing in the room and tells
+ * the room's players to move on to the next song. Returns false if a different
+ * song is playing by now, such as when it was already skipped.
+ */
+func (s *BackendServer) skipSong(roomId uint32, songId uint32) bool {
+	nextSong, skipped := s.queueMgr.SkipSong(roomId, songId)
+	if !skipped {
+		return false
+	}
+
+	s.skipVotes.reset(roomId)
+	s.queueMgr.SaveSnapshot(roomId)
 	control := &bepb.PlayerControl{Command: bepb.CommandType_Next, Song: nextSong}
-	s.playerMgr.sendToPlayers(control)
-	return &bepb.Error{Success: true, Message: "Success"}, nil
+	s.playerMgr.sendToPlayers(roomId, control)
+	return true
+}
+
+/*
+ * A player in the room could not play the song it was sent. The song is marked
+ * as failed for its submitter to find out about and the room's players are
+ * stopped so that they all move on to the next song.
+ */
+func (s *BackendServer) songFailed(roomId uint32, status *bepb.PlayerStatus) {
+	nowPlaying := s.queueMgr.NowPlaying(roomId)
+	if nowPlaying == nil || nowPlaying.SongId != status.GetSongId() {
+		return
+	}
+
+	if !s.playerMgr.claimFailure(roomId, nowPlaying.SongId) {
+		return
+	}
+
+	log.Printf("Player failed to play song %d in room %d: %s", nowPlaying.SongId, roomId, status.GetReason())
+	s.dbManager.MarkSongFailed(nowPlaying.SongId, status.GetReason())
+	s.skipVotes.reset(roomId)
+	s.queueMgr.ClearNowPlaying(roomId)
+	s.queueMgr.SaveSnapshot(roomId)
+	s.playerMgr.sendToPlayers(roomId, &bepb.PlayerControl{Command: bepb.CommandType_Stop})
+}
+
+/*
+ * Returns the songs submitted by the user that the players failed to play
+ * since the user last asked
+ */
+func (s *BackendServer) GetFailedSongs(con context.Context, user *bepb.User) (*bepb.FailedSongs, error) {
+	failures, err := s.dbManager.PopFailedSongs(user.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &bepb.FailedSongs{Songs: failures}, nil
+}
+
+/*
+ * Search for songs the user could submit. Songs from the local music library
+ * come first. Songs that are too long for the user's room are left out.
+ */
+func (s *BackendServer) SearchSongs(con context.Context, query *bepb.SearchQuery) (*bepb.SearchResults, error) {
+	username, roomId := s.getUserFromId(query.GetUserId())
+	if username == "" {
+		return nil, status.Errorf(codes.PermissionDenied, "Search by unknown user")
+	}
+
+	text := strings.TrimSpace(query.GetText())
+	if text == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Nothing to search for")
+	}
+
+	// songs in the local music library come before songs found online
+	var songs []*cmpb.Song
+	tracks, err := s.dbManager.SearchLibrary(text, searchResults)
+	if err == nil {
+		for _, track := range tracks {
+			songs = append(songs, librarySong(track))
+		}
+	}
+
+	found, err := s.fetcher.searchSongs(text, searchResults)
+	if err != nil && len(songs) == 0 {
+		log.Printf("Search for %q failed: %v", text, err)
+		return nil, status.Errorf(codes.Unavailable, "Failed to search for songs. Please try again or submit a link.")
+	}
+	songs = append(songs, found...)
+
+	results := new(bepb.SearchResults)
+	for _, song := range songs {
+		song.RoomId = roomId
+		if s.checkDuration(song) == "" {
+			results.Songs = append(results.Songs, song)
+		}
+	}
+	log.Printf("Search for %q by user %d found %d songs", text, query.GetUserId(), len(results.Songs))
+
+	return results, nil
+}
+
+/*
+ * Lists the artists of the local music library, the albums of an artist or
+ * the tracks of an album
+ */
+func (s *BackendServer) BrowseLibrary(con context.Context, browse *bepb.LibraryBrowse) (*bepb.LibraryListing, error) {
+	listing, err := s.dbManager.BrowseLibrary(browse.GetArtist(), browse.GetAlbum())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to browse the music library")
+	}
+
+	return listing, nil
+}
+
+/*
+ * Searches the tracks of the local music library by artist, album and title
+ */
+func (s *BackendServer) SearchLibrary(con context.Context, query *bepb.SearchQuery) (*bepb.LibraryListing, error) {
+	tracks, err := s.dbManager.SearchLibrary(query.GetText(), libraryResults)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to search the music library")
+	}
+
+	return &bepb.LibraryListing{Tracks: tracks}, nil
+}
+
+/*
+ * Returns the song that submitting the library track would queue
+ */
+func librarySong(track *bepb.LibraryTrack) *cmpb.Song {
+	title := track.Title
+	if track.Artist != library.UnknownArtist {
+		title = fmt.Sprintf("%s - %s", track.Artist, track.Title)
+	}
+
+	return &cmpb.Song{
+		Title:     title,
+		Duration:  track.Duration,
+		Service:   cmpb.ServiceType_Local,
+		ServiceId: track.Path,
+		Metadata:  &cmpb.Metadata{},
+	}
+}
+
+/*
+ * Records the user's vote to skip the song playing in their room. The song is
+ * skipped once the share of the room's active users who voted reaches the
+ * configured skip share. The submitter of a song may always skip it.
+ */
+func (s *BackendServer) SkipVote(con context.Context, user *bepb.User) (*bepb.SkipTally, error) {
+	tally := &bepb.SkipTally{Err: &bepb.Error{Success: false}}
+
+	roomId := s.getRoomId(&bepb.User{UserId: user.GetUserId()})
+	if roomId == 0 {
+		tally.Err.Message = "Skip vote cast by unknown user"
+		log.Printf(tally.Err.Message)
+		return tally, nil
+	}
+
+	nowPlaying := s.queueMgr.NowPlaying(roomId)
+	if nowPlaying == nil {
+		tally.Err.Message = "No song is currently playing"
+		return tally, nil
+	}
+
+	votes := s.skipVotes.vote(roomId, nowPlaying.SongId, user.GetUserId())
+	tally.SongId = nowPlaying.SongId
+	tally.Votes = uint32(votes)
+	tally.Needed = s.skipsNeeded(roomId, votes)
+	log.Printf("Skip vote: {room: %d, song id: %d, user id: %d, votes: %d/%d}",
+		roomId, nowPlaying.SongId, user.GetUserId(), tally.Votes, tally.Needed)
+
+	// votes crossing the threshold together only skip the song once
+	if tally.Votes >= tally.Needed || nowPlaying.UserId == user.GetUserId() {
+		tally.Skipped = s.skipSong(roomId, nowPlaying.SongId)
+	}
+
+	tally.Err.Success = true
+	tally.Err.Message = "Success"
+	return tally, nil
+}
+
+/*
+ * Returns the skip votes of the song playing in the user's room
+ */
+func (s *BackendServer) GetSkipTally(con context.Context, user *bepb.User) (*bepb.SkipTally, error) {
+	tally := &bepb.SkipTally{Err: &bepb.Error{Success: true, Message: "Success"}}
+	roomId := s.getRoomId(user)
+
+	if nowPlaying := s.queueMgr.NowPlaying(roomId); nowPlaying != nil {
+		votes := s.skipVotes.count(roomId, nowPlaying.SongId)
+		tally.SongId = nowPlaying.SongId
+		tally.Votes = uint32(votes)
+		tally.Needed = s.skipsNeeded(roomId, votes)
+	}
+
+	return tally, nil
+}
+
+/*
+ * Returns the number of skip votes needed to skip the song playing in the
+ * room. Users who voted always count as active, and at least one vote is
+ * needed.
+ */
+func (s *BackendServer) skipsNeeded(roomId uint32, votes int) uint32 {
+	active := s.userCache.ActiveUsers(roomId, time.Now().Add(-activeUserWindow))
+	if active < votes {
+		active = votes
+	}
+
+	needed := uint32(math.Ceil(s.skipShare * float64(active)))
+	if needed < 1 {
+		needed = 1
+	}
+
+	return needed
+}
+
+/*
+ * Forwards the command to pause the song currently playing in the room onto
+ * the remote player
+ */
+func (s *BackendServer) PauseSong(con context.Context, user *bepb.User) (*bepb.Error, error) {
+	roomId, response := s.authorizeControl(user)
+	if response.Success {
+		s.playerMgr.sendToPlayers(roomId, &bepb.PlayerControl{Command: bepb.CommandType_Pause})
+	}
+
+	return response, nil
+}
+
+/*
+ * Seeks the song playing in the room to an absolute position or by an offset
+ * from its current position
+ */
+func (s *BackendServer) SeekSong(con context.Context, seek *bepb.SeekPosition) (*bepb.Error, error) {
+	roomId, response := s.authorizeControl(seek.GetUser())
+	if !response.Success {
+		return response, nil
+	}
+
+	if !seek.Relative && seek.Seconds < 0 {
+		return &bepb.Error{Success: false, Message: "Can't seek to a negative position."}, nil
+	}
+
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Seek, Seconds: seek.Seconds, Relative: seek.Relative}
+	s.playerMgr.sendToPlayers(roomId, control)
+	return response, nil
+}
+
+/*
+ * Sets the volume of the players in the room
+ */
+func (s *BackendServer) SetVolume(con context.Context, volume *bepb.VolumeLevel) (*bepb.Error, error) {
+	roomId, response := s.authorizeControl(volume.GetUser())
+	if !response.Success {
+		return response, nil
+	}
+
+	if volume.Level < 0 || volume.Level > maxVolume {
+		return &bepb.Error{Success: false, Message: fmt.Sprintf("Volume must be between 0 and %d.", maxVolume)}, nil
+	}
+
+	s.playerMgr.sendToPlayers(roomId, &bepb.PlayerControl{Command: bepb.CommandType_Volume, Level: volume.Level})
+	return response, nil
+}
+
+/*
+ * Toggles mute on the players in the room
+ */
+func (s *BackendServer) MuteSong(con context.Context, user *bepb.User) (*bepb.Error, error) {
+	roomId, response := s.authorizeControl(user)
+	if response.Success {
+		s.playerMgr.sendToPlayers(roomId, &bepb.PlayerControl{Command: bepb.CommandType_Mute})
+	}
+
+	return response, nil
 }
 
 /*
- * Forwards the command to pause the currently playing song onto the remote
- * player
+ * Plays the song in the room again from the start
  */
-func (s *BackendServer) PauseSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
-	s.playerMgr.sendToPlayers(&bepb.PlayerControl{Command: bepb.CommandType_Pause})
-	return &bepb.Error{Success: true, Message: "Success"}, nil
+func (s *BackendServer) RestartSong(con context.Context, user *bepb.User) (*bepb.Error, error) {
+	roomId, response := s.authorizeControl(user)
+	if response.Success {
+		s.playerMgr.sendToPlayers(roomId, &bepb.PlayerControl{Command: bepb.CommandType_Restart})
+	}
+
+	return response, nil
 }
 
 /*
- * Stream RPC connection with the remote player client
+ * Returns the room that a player command applies to. Requests made by a user
+ * are only allowed if the user is the admin of the room. Requests that only
+ * name a room come from the command line and are only allowed if the server
+ * was started with command line control turned on.
+ */
+func (s *BackendServer) authorizeControl(user *bepb.User) (uint32, *bepb.Error) {
+	roomId := s.getRoomId(user)
+	if roomId == 0 {
+		return 0, &bepb.Error{Success: false, Message: "Room does not exist."}
+	}
+
+	if user.GetUserId() == 0 && !s.cliAccess {
+		return 0, &bepb.Error{Success: false, Message: "Command line control is turned off on the server."}
+	}
+
+	if user.GetUserId() != 0 && !s.isRoomAdmin(roomId, user.GetUserId()) {
+		return 0, &bepb.Error{Success: false, Message: "Only the room's admin can control the player."}
+	}
+
+	return roomId, &bepb.Error{Success: true, Message: "Success"}
+}
+
+/*
+ * Returns true if the user is the admin of the room
+ */
+func (s *BackendServer) isRoomAdmin(roomId uint32, userId uint32) bool {
+	roomData, err := s.dbManager.GetRoomById(roomId)
+	if err != nil {
+		log.Printf("Failed to look up room %d: %v", roomId, err)
+		return false
+	}
+
+	return roomData.Room.AdminId != 0 && roomData.Room.AdminId == userId
+}
+
+/*
+ * Returns the user with the given id along with whether they are the admin of
+ * their room
+ */
+func (s *BackendServer) GetUser(con context.Context, user *bepb.User) (*bepb.User, error) {
+	response := &bepb.User{Err: &bepb.Error{Success: false}}
+
+	username, roomId := s.getUserFromId(user.GetUserId())
+	if roomId == 0 {
+		response.Err.Message = "User does not exist."
+		return response, nil
+	}
+
+	response.UserId = user.GetUserId()
+	response.Username = username
+	response.RoomId = roomId
+	response.Admin = s.isRoomAdmin(roomId, user.GetUserId())
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Stream RPC connection with the remote player client. The player must first
+ * join a room before it will be sent any songs.
  */
 func (s *BackendServer) SongPlayer(stream bepb.YtbBePlayer_SongPlayerServer) error {
 	s.streamWG.Add(1)
 	defer s.streamWG.Done()
-	id, stop := s.playerMgr.add(stream)
+
+	roomId, join, err := s.joinRoom(stream)
+	if err != nil {
+		return err
+	}
+	id, stop := s.playerMgr.add(roomId, stream)
+	s.playerMgr.rejoin(roomId, join)
 
 	go func() {
 		for {
@@ -368,6 +1081,10 @@ func (s *BackendServer) SongPlayer(stream bepb.YtbBePlayer_SongPlayerServer) err
 				break
 			}
 
+			if status.GetCommand() == bepb.CommandType_Failed {
+				s.songFailed(roomId, status)
+			}
+
 			// write the received status to the player manager
 			s.playerMgr.receiveFromPlayers(id, status)
 		}
@@ -375,25 +1092,69 @@ func (s *BackendServer) SongPlayer(stream bepb.YtbBePlayer_SongPlayerServer) err
 	}()
 
 	<-stop
-	if s.playerMgr.remove(id) == 0 {
-		s.queueMgr.ClearNowPlaying()
-	}
+	s.playerMgr.remove(id)
 	return nil
 }
 
+/*
+ * Wait for the remote player to send its join handshake and return the id of
+ * the room it named along with the handshake
+ */
+func (s *BackendServer) joinRoom(stream bepb.YtbBePlayer_SongPlayerServer) (uint32, *bepb.PlayerStatus, error) {
+	join, err := stream.Recv()
+	if err != nil {
+		log.Printf("Error receiving join from remote player: %v", err)
+		return 0, nil, err
+	}
+
+	if join.GetCommand() != bepb.CommandType_Join {
+		log.Printf("Remote player sent %v before joining a room", join.GetCommand())
+		return 0, nil, status.Errorf(codes.FailedPrecondition, "Player must join a room first")
+	}
+
+	roomData, err := s.dbManager.GetRoomByName(join.GetRoom())
+	if roomData == nil && errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Remote player tried to join unknown room: %s", join.GetRoom())
+		return 0, nil, status.Errorf(codes.NotFound, "Room %s does not exist", join.GetRoom())
+	} else if err != nil {
+		log.Printf("Failed to look up room %s for remote player: %v", join.GetRoom(), err)
+		return 0, nil, status.Errorf(codes.Internal, "Failed to look up room %s", join.GetRoom())
+	}
+
+	log.Printf("Remote player joined room: {name: %s, id: %d}", roomData.Room.Name, roomData.Room.Id)
+	return roomData.Room.Id, join, nil
+}
+
 /*
  * Handles command to create a new room. Room names should be unique. Will
- * return an error if the room already exists.
+ * return an error if the room already exists. The room uses the server's
+ * default queuing strategy if one isn't given.
  */
 func (s *BackendServer) CreateRoom(con context.Context, room *bepb.Room) (*bepb.Room, error) {
 	response := new(bepb.Room)
 	response.Err = new(bepb.Error)
 	response.Err.Success = false
+
+	strategy := s.strategy
+	if room.Strategy != "" {
+		parsed, err := queuer.ParseStrategy(room.Strategy)
+		if err != nil {
+			response.Err.Message = err.Error()
+			return response, nil
+		}
+		strategy = parsed
+	}
+
+	maxDuration := room.MaxDuration
+	if maxDuration == 0 {
+		maxDuration = allowedMinutes * 60
+	}
+
 	roomData, err := s.dbManager.GetRoomByName(room.Name)
 
 	// room doesn't exist so create it
 	if roomData == nil && errors.Is(err, sql.ErrNoRows) {
-		roomData, err = s.dbManager.AddRoom(room.Name)
+		roomData, err = s.dbManager.AddRoom(room.Name, string(strategy), maxDuration)
 
 		if err != nil {
 			log.Printf("Failed to create a new room: {name: %s, error: %v}", room.Name, err)
@@ -401,8 +1162,11 @@ func (s *BackendServer) CreateRoom(con context.Context, room *bepb.Room) (*bepb.
 			return response, nil
 		}
 
+		s.queueMgr.SetStrategy(roomData.Room.Id, strategy)
 		response.Name = roomData.Room.Name
 		response.Id = roomData.Room.Id
+		response.Strategy = string(strategy)
+		response.MaxDuration = maxDuration
 		response.Err.Success = true
 		return response, nil
 	}
@@ -427,12 +1191,189 @@ func (s *BackendServer) GetRoom(con context.Context, room *bepb.Room) (*bepb.Roo
 	} else {
 		response.Name = roomData.Room.Name
 		response.Id = roomData.Room.Id
+		response.Strategy = string(s.queueMgr.Strategy(roomData.Room.Id))
+		response.MaxDuration = roomData.Room.MaxDuration
+		response.AdminId = roomData.Room.AdminId
 		response.Err.Success = true
 	}
 
 	return response, nil
 }
 
-func isValidDuration(duration period.Period) bool {
-	return !duration.IsZero() && duration.Minutes() < allowedMinutes
+/*
+ * Switches the queuing strategy of a live room. The new strategy is saved to
+ * the database so the room keeps it across restarts. Only allowed with command
+ * line control turned on.
+ */
+func (s *BackendServer) SetRoomStrategy(con context.Context, room *bepb.Room) (*bepb.Room, error) {
+	response, _ := s.GetRoom(con, room)
+	if !response.Err.Success {
+		return response, nil
+	}
+
+	if _, authorized := s.authorizeControl(&bepb.User{RoomId: response.Id}); !authorized.Success {
+		response.Err = authorized
+		return response, nil
+	}
+
+	strategy, err := queuer.ParseStrategy(room.Strategy)
+	if err != nil {
+		response.Err.Success = false
+		response.Err.Message = err.Error()
+		return response, nil
+	}
+
+	err = s.dbManager.UpdateRoomStrategy(response.Id, string(strategy))
+	if err != nil {
+		log.Printf("Failed to save strategy of room %d: %v", response.Id, err)
+		response.Err.Success = false
+		response.Err.Message = "Failed to update room."
+		return response, nil
+	}
+
+	s.queueMgr.SetStrategy(response.Id, strategy)
+	s.queueMgr.SaveSnapshot(response.Id)
+	s.playerMgr.preview(response.Id)
+	response.Strategy = string(strategy)
+	return response, nil
+}
+
+/*
+ * Changes the longest song that can be submitted to a room. A max duration of
+ * zero lets songs of any length into the room. Songs already in the queue are
+ * left alone. Only allowed with command line control turned on.
+ */
+func (s *BackendServer) SetRoomMaxDuration(con context.Context, room *bepb.Room) (*bepb.Room, error) {
+	response, _ := s.GetRoom(con, room)
+	if !response.Err.Success {
+		return response, nil
+	}
+
+	if _, authorized := s.authorizeControl(&bepb.User{RoomId: response.Id}); !authorized.Success {
+		response.Err = authorized
+		return response, nil
+	}
+
+	err := s.dbManager.UpdateRoomMaxDuration(response.Id, room.MaxDuration)
+	if err != nil {
+		log.Printf("Failed to save max duration of room %d: %v", response.Id, err)
+		response.Err.Success = false
+		response.Err.Message = "Failed to update room."
+		return response, nil
+	}
+
+	response.MaxDuration = room.MaxDuration
+	return response, nil
+}
+
+/*
+ * Makes the given user the admin of a room. The user must belong to the room.
+ * An admin id of zero leaves the room without an admin. Only allowed with
+ * command line control turned on.
+ */
+func (s *BackendServer) SetRoomAdmin(con context.Context, room *bepb.Room) (*bepb.Room, error) {
+	response, _ := s.GetRoom(con, room)
+	if !response.Err.Success {
+		return response, nil
+	}
+
+	if _, authorized := s.authorizeControl(&bepb.User{RoomId: response.Id}); !authorized.Success {
+		response.Err = authorized
+		return response, nil
+	}
+
+	if room.AdminId != 0 {
+		userData, err := s.dbManager.GetUserById(room.AdminId)
+		if err != nil || userData.User.RoomId != response.Id {
+			response.Err.Success = false
+			response.Err.Message = fmt.Sprintf("User %d is not in room %s.", room.AdminId, response.Name)
+			return response, nil
+		}
+	}
+
+	err := s.dbManager.UpdateRoomAdmin(response.Id, room.AdminId)
+	if err != nil {
+		log.Printf("Failed to save admin of room %d: %v", response.Id, err)
+		response.Err.Success = false
+		response.Err.Message = "Failed to update room."
+		return response, nil
+	}
+
+	response.AdminId = room.AdminId
+	return response, nil
+}
+
+/*
+ * Records a user's vote on a song in their room's queue. The net votes of the
+ * song are handed to the queue which may reorder or drop the song.
+ */
+func (s *BackendServer) VoteSong(con context.Context, vote *bepb.Vote) (*bepb.Error, error) {
+	response := &bepb.Error{Success: false}
+
+	if vote.GetValue() < -1 || vote.GetValue() > 1 {
+		response.Message = "A vote must be one of -1, 0 or 1"
+		return response, nil
+	}
+
+	roomId := s.getRoomId(&bepb.User{UserId: vote.GetUserId()})
+	if roomId == 0 {
+		response.Message = "Vote cast by unknown user"
+		log.Printf(response.Message)
+		return response, nil
+	}
+
+	song := s.queueMgr.GetSong(roomId, vote.GetSongId())
+	if song == nil {
+		response.Message = "The song is no longer in the queue"
+		return response, nil
+	}
+
+	if song.UserId == vote.GetUserId() {
+		response.Message = "You can't vote on your own song"
+		return response, nil
+	}
+
+	err := s.dbManager.AddVote(vote.GetSongId(), vote.GetUserId(), vote.GetValue())
+	if err != nil {
+		response.Message = "Failed to record your vote"
+		return response, nil
+	}
+
+	votes, err := s.dbManager.GetVotes(vote.GetSongId())
+	if err != nil {
+		response.Message = "Failed to record your vote"
+		return response, nil
+	}
+
+	dropped, err := s.queueMgr.VoteSong(roomId, vote.GetSongId(), votes)
+	if err != nil {
+		log.Printf("Failed to apply vote to queue: %v", err)
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	if dropped {
+		log.Printf("Dropped song %d from room %d with %d votes", vote.GetSongId(), roomId, votes)
+	}
+
+	s.queueMgr.SaveSnapshot(roomId)
+	s.playerMgr.preview(roomId)
+	response.Success = true
+	response.Message = "Success"
+	return response, nil
+}
+
+/*
+ * Returns true if a song of the given length in seconds fits within the max
+ * duration. Songs of unknown length are not valid.
+ */
+func isValidDuration(duration uint32, maxDuration uint32) bool {
+	return duration != 0 && duration <= maxDuration
+}
+
+/*
+ * Formats a number of seconds as minutes and seconds
+ */
+func formatSeconds(seconds uint32) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
 }
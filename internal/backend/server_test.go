@@ -0,0 +1,372 @@
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/internal/backend/song_queuer"
+	db "github.com/nguyenmq/ytbox-go/internal/database"
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+const testRoomName = "People's Palace"
+
+/*
+ * Create a server backed by a fresh database without listening for requests
+ */
+func setupServer(t *testing.T, cliAccess bool) *BackendServer {
+	dbManager := new(db.SqliteManager)
+	if err := dbManager.Init(filepath.Join(t.TempDir(), "ytbox.db")); err != nil {
+		t.Fatal("Failed to create the database:", err)
+	}
+	t.Cleanup(dbManager.Close)
+
+	server := new(BackendServer)
+	server.dbManager = dbManager
+	server.strategy = queuer.RoundRobinStrategy
+	server.queueMgr = new(queuer.SongQueueManager)
+	server.queueMgr.Init(queuer.RoundRobinStrategy, dbManager)
+	t.Cleanup(server.queueMgr.Close)
+	server.userCache = new(UserCache)
+	server.userCache.Init()
+	server.skipVotes = new(skipVoter)
+	server.skipVotes.init()
+	server.skipShare = 0.5
+	server.cliAccess = cliAccess
+	server.playerMgr = new(playerManager)
+	server.playerMgr.init(server.queueMgr, time.Second, time.Minute, 0)
+	server.playerMgr.start()
+	t.Cleanup(server.playerMgr.stop)
+	return server
+}
+
+/*
+ * Create a room with an admin and another user in it. Returns the ids of the
+ * room, its admin and the other user.
+ */
+func setupRoom(t *testing.T, server *BackendServer) (uint32, uint32, uint32) {
+	room, _ := server.CreateRoom(context.Background(), &bepb.Room{Name: testRoomName})
+	if !room.Err.Success {
+		t.Fatal("Failed to create the room:", room.Err.Message)
+	}
+
+	admin, err := server.dbManager.AddUser("Richard", room.Id)
+	if err != nil {
+		t.Fatal("Failed to add the admin:", err)
+	}
+
+	other, err := server.dbManager.AddUser("Zedd", room.Id)
+	if err != nil {
+		t.Fatal("Failed to add the user:", err)
+	}
+
+	if err = server.dbManager.UpdateRoomAdmin(room.Id, admin.User.UserId); err != nil {
+		t.Fatal("Failed to make the admin:", err)
+	}
+
+	return room.Id, admin.User.UserId, other.User.UserId
+}
+
+func TestAuthorizeControl_whenUserIsAdmin_allows(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, adminId, _ := setupRoom(t, server)
+
+	authorizedRoom, response := server.authorizeControl(&bepb.User{UserId: adminId})
+	if !response.Success || authorizedRoom != roomId {
+		t.Error("Expected the admin to control the room but got", response.Message)
+	}
+}
+
+func TestAuthorizeControl_whenUserIsNotAdmin_rejects(t *testing.T) {
+	server := setupServer(t, true)
+	roomId, _, otherId := setupRoom(t, server)
+
+	if _, response := server.authorizeControl(&bepb.User{UserId: otherId}); response.Success {
+		t.Error("Expected a user who isn't the admin to be rejected")
+	}
+
+	// naming the room doesn't get around being a user who isn't the admin
+	if _, response := server.authorizeControl(&bepb.User{UserId: otherId, RoomId: roomId}); response.Success {
+		t.Error("Expected a user who isn't the admin to be rejected when naming the room")
+	}
+}
+
+func TestAuthorizeControl_whenOnlyRoomGiven_needsCliAccess(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, _, _ := setupRoom(t, server)
+
+	if _, response := server.authorizeControl(&bepb.User{RoomId: roomId}); response.Success {
+		t.Error("Expected a request without a user to be rejected")
+	}
+
+	server.cliAccess = true
+	if authorizedRoom, response := server.authorizeControl(&bepb.User{RoomId: roomId}); !response.Success || authorizedRoom != roomId {
+		t.Error("Expected a request without a user to be allowed with command line control but got", response.Message)
+	}
+}
+
+func TestSetRoomSettings_withoutCliAccess_rejects(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, adminId, otherId := setupRoom(t, server)
+	con := context.Background()
+
+	room, _ := server.SetRoomStrategy(con, &bepb.Room{Name: testRoomName, Strategy: string(queuer.FifoStrategy)})
+	if room.Err.Success || server.queueMgr.Strategy(roomId) != queuer.RoundRobinStrategy {
+		t.Error("Expected the strategy to stay the same")
+	}
+
+	room, _ = server.SetRoomMaxDuration(con, &bepb.Room{Name: testRoomName, MaxDuration: 1})
+	if roomData, _ := server.dbManager.GetRoomById(roomId); room.Err.Success || roomData.Room.MaxDuration == 1 {
+		t.Error("Expected the max duration to stay the same")
+	}
+
+	room, _ = server.SetRoomAdmin(con, &bepb.Room{Name: testRoomName, AdminId: otherId})
+	if room.Err.Success || !server.isRoomAdmin(roomId, adminId) {
+		t.Error("Expected the admin to stay the same")
+	}
+}
+
+func TestSetRoomSettings_withCliAccess_allows(t *testing.T) {
+	server := setupServer(t, true)
+	roomId, _, otherId := setupRoom(t, server)
+	con := context.Background()
+
+	room, _ := server.SetRoomStrategy(con, &bepb.Room{Name: testRoomName, Strategy: string(queuer.FifoStrategy)})
+	if !room.Err.Success || server.queueMgr.Strategy(roomId) != queuer.FifoStrategy {
+		t.Error("Expected the strategy to change but got", room.Err.Message)
+	}
+
+	room, _ = server.SetRoomMaxDuration(con, &bepb.Room{Name: testRoomName, MaxDuration: 1})
+	if roomData, _ := server.dbManager.GetRoomById(roomId); !room.Err.Success || roomData.Room.MaxDuration != 1 {
+		t.Error("Expected the max duration to change but got", room.Err.Message)
+	}
+
+	room, _ = server.SetRoomAdmin(con, &bepb.Room{Name: testRoomName, AdminId: otherId})
+	if !room.Err.Success || !server.isRoomAdmin(roomId, otherId) {
+		t.Error("Expected the admin to change but got", room.Err.Message)
+	}
+}
+
+/*
+ * A room set up from the web page should have an admin who can control its
+ * players without the server allowing command line control
+ */
+func TestLoginUser_firstUserInRoom_becomesAdmin(t *testing.T) {
+	server := setupServer(t, false)
+	con := context.Background()
+	room, _ := server.CreateRoom(con, &bepb.Room{Name: testRoomName})
+
+	first, _ := server.LoginUser(con, &bepb.User{Username: "Richard", RoomId: room.Id})
+	second, _ := server.LoginUser(con, &bepb.User{Username: "Zedd", RoomId: room.Id})
+	if !first.Err.Success || !second.Err.Success {
+		t.Fatal("Failed to log in the users")
+	}
+
+	if user, _ := server.GetUser(con, &bepb.User{UserId: first.UserId}); !user.Admin {
+		t.Error("Expected the first user in the room to be its admin")
+	}
+
+	if user, _ := server.GetUser(con, &bepb.User{UserId: second.UserId}); user.Admin {
+		t.Error("Expected only the first user in the room to be its admin")
+	}
+
+	if response, _ := server.MuteSong(con, &bepb.User{UserId: first.UserId}); !response.Success {
+		t.Error("Expected the admin to control the players but got", response.Message)
+	}
+
+	if response, _ := server.MuteSong(con, &bepb.User{UserId: second.UserId}); response.Success {
+		t.Error("Expected a user who isn't the admin not to control the players")
+	}
+}
+
+func newServerTestSong(roomId uint32, userId uint32) *cmpb.Song {
+	return &cmpb.Song{Title: "Wizard's First Rule", Duration: 200, UserId: userId, RoomId: roomId,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "dQw4w9WgXcQ"}
+}
+
+/*
+ * Wait for the queue to record that the song played
+ */
+func waitForPlayed(t *testing.T, server *BackendServer, song *cmpb.Song) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		date, _ := server.dbManager.GetLastPlayDate(song.RoomId, song.Service, song.ServiceId)
+		if !date.IsZero() {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("Timed out waiting for the song to be marked played")
+}
+
+/*
+ * The cooldown should count from when a song played rather than when it was
+ * submitted
+ */
+func TestQueueSong_cooldownStartsWhenPlayed(t *testing.T) {
+	server := setupServer(t, false)
+	server.cooldown = time.Hour
+	roomId, userId, _ := setupRoom(t, server)
+
+	song := newServerTestSong(roomId, userId)
+	if message := server.queueSong(song); message != "" {
+		t.Fatal("Expected the song to be queued but got", message)
+	}
+
+	// submitted just now but never played
+	server.queueMgr.RemoveSong(roomId, song.SongId, userId)
+	if message := server.queueSong(newServerTestSong(roomId, userId)); message != "" {
+		t.Error("Expected a song that never played to be queued again but got", message)
+	}
+
+	played := server.queueMgr.PopQueue(roomId)
+	server.queueMgr.PopQueue(roomId)
+	waitForPlayed(t, server, played)
+	if message := server.queueSong(newServerTestSong(roomId, userId)); message == "" {
+		t.Error("Expected a song that just played to be rejected")
+	}
+}
+
+func TestQueueSong_whenRepeatRejected_removesSong(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, userId, _ := setupRoom(t, server)
+	server.cooldown = time.Hour
+
+	if message := server.queueSong(newServerTestSong(roomId, userId)); message != "" {
+		t.Fatal("Expected the song to be queued but got", message)
+	}
+
+	repeat := newServerTestSong(roomId, userId)
+	if message := server.queueSong(repeat); message == "" {
+		t.Fatal("Expected a song that is already queued to be rejected")
+	}
+
+	// marking a removed song played doesn't start its cooldown
+	server.dbManager.MarkSongPlayed(repeat.SongId)
+	if date, _ := server.dbManager.GetLastPlayDate(roomId, repeat.Service, repeat.ServiceId); !date.IsZero() {
+		t.Error("Expected the rejected song to be removed from the database")
+	}
+}
+
+func TestQueueSong_whenAddFails_doesNotQueue(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, userId, _ := setupRoom(t, server)
+
+	// a room that doesn't exist fails the database's constraints
+	song := newServerTestSong(roomId+1, userId)
+	if message := server.queueSong(song); message == "" {
+		t.Error("Expected a song that couldn't be saved to be rejected")
+	}
+
+	if server.queueMgr.Len(roomId+1) != 0 {
+		t.Error("Expected a song that couldn't be saved not to be queued")
+	}
+}
+
+/*
+ * Only one of many submissions of the same song at once should be queued
+ */
+func TestQueueSong_whenSubmittedAtOnce_queuesOne(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, userId, _ := setupRoom(t, server)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	queued := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if server.queueSong(newServerTestSong(roomId, userId)) == "" {
+				lock.Lock()
+				queued++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if queued != 1 || server.queueMgr.Len(roomId) != 1 {
+		t.Error("Expected the song to be queued once but it was queued", queued, "times")
+	}
+}
+
+/*
+ * Votes that cross the skip threshold together should only skip one song
+ */
+func TestSkipVote_whenVotesCrossTogether_skipsOnce(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, adminId, otherId := setupRoom(t, server)
+
+	for _, serviceId := range []string{"song-one", "song-two", "song-three", "song-four"} {
+		song := newServerTestSong(roomId, adminId)
+		song.ServiceId = serviceId
+		if message := server.queueSong(song); message != "" {
+			t.Fatal("Failed to queue song:", message)
+		}
+	}
+	server.queueMgr.PopQueue(roomId)
+
+	// with six active users it takes three votes to skip a song, so the votes
+	// left over after a skip can't skip the next song too
+	server.userCache.AddUserToCache(adminId, "Richard", roomId)
+	server.userCache.AddUserToCache(otherId, "Zedd", roomId)
+
+	var voters []uint32
+	for _, name := range []string{"Kahlan", "Cara", "Nicci", "Chase"} {
+		user, err := server.dbManager.AddUser(name, roomId)
+		if err != nil {
+			t.Fatal("Failed to add user:", err)
+		}
+		voters = append(voters, user.User.UserId)
+
+		server.userCache.AddUserToCache(user.User.UserId, name, roomId)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	skips := 0
+	for _, userId := range voters {
+		wg.Add(1)
+		go func(userId uint32) {
+			defer wg.Done()
+			tally, _ := server.SkipVote(context.Background(), &bepb.User{UserId: userId})
+			if tally.Skipped {
+				lock.Lock()
+				skips++
+				lock.Unlock()
+			}
+		}(userId)
+	}
+	wg.Wait()
+
+	if skips != 1 || server.queueMgr.Len(roomId) != 2 {
+		t.Error("Expected one song to be skipped but got", skips, "skips and", server.queueMgr.Len(roomId), "songs left")
+	}
+}
+
+func TestNextSong_onlySkipsForAdmin(t *testing.T) {
+	server := setupServer(t, false)
+	roomId, adminId, otherId := setupRoom(t, server)
+	server.queueSong(newServerTestSong(roomId, adminId))
+	playing := server.queueMgr.PopQueue(roomId)
+
+	response, _ := server.NextSong(context.Background(), &bepb.User{UserId: otherId})
+	if response.Success || server.queueMgr.NowPlaying(roomId) != playing {
+		t.Error("Expected a user who isn't the admin not to skip the song")
+	}
+
+	response, _ = server.NextSong(context.Background(), &bepb.User{UserId: adminId})
+	if !response.Success || server.queueMgr.NowPlaying(roomId) != nil {
+		t.Error("Expected the admin to skip the song but got", response.Message)
+	}
+
+	if server.skipSong(roomId, playing.SongId) {
+		t.Error("Expected a song that was already skipped not to be skipped again")
+	}
+}
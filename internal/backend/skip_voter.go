@@ -0,0 +1,82 @@
+/*
+ * Keeps track of the users who voted to skip the song playing in each room
+ */
+
+package backend
+
+import (
+	"sync"
+)
+
+/*
+ * The skip votes cast on a single song
+ */
+type skipBallot struct {
+	songId uint32          // id of the song being voted on
+	voters map[uint32]bool // ids of the users who voted to skip
+}
+
+type skipVoter struct {
+	lock    *sync.Mutex            // lock on the ballots
+	ballots map[uint32]*skipBallot // ballot of each room keyed by room id
+}
+
+/*
+ * Initialize the skip voter
+ */
+func (v *skipVoter) init() {
+	v.lock = new(sync.Mutex)
+	v.ballots = make(map[uint32]*skipBallot)
+}
+
+/*
+ * Returns the room's ballot for the given song. The ballot starts over when
+ * the song has changed since the last vote.
+ */
+func (v *skipVoter) unsyncGetBallot(roomId uint32, songId uint32) *skipBallot {
+	ballot, exists := v.ballots[roomId]
+	if !exists || ballot.songId != songId {
+		ballot = &skipBallot{songId: songId, voters: make(map[uint32]bool)}
+		v.ballots[roomId] = ballot
+	}
+
+	return ballot
+}
+
+/*
+ * Records the user's vote to skip the song and returns the number of distinct
+ * users who have voted to skip it
+ */
+func (v *skipVoter) vote(roomId uint32, songId uint32, userId uint32) int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	ballot := v.unsyncGetBallot(roomId, songId)
+	ballot.voters[userId] = true
+	return len(ballot.voters)
+}
+
+/*
+ * Returns the number of users who have voted to skip the song
+ */
+func (v *skipVoter) count(roomId uint32, songId uint32) int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	ballot, exists := v.ballots[roomId]
+	if !exists || ballot.songId != songId {
+		return 0
+	}
+
+	return len(ballot.voters)
+}
+
+/*
+ * Throws away the skip votes of the room
+ */
+func (v *skipVoter) reset(roomId uint32) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.ballots, roomId)
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"testing"
+)
+
+const testSongId = 7
+
+func setupSkipVoter() *skipVoter {
+	voter := new(skipVoter)
+	voter.init()
+	return voter
+}
+
+func TestSkipVote_countsDistinctUsers(t *testing.T) {
+	voter := setupSkipVoter()
+	voter.vote(testRoomId, testSongId, testUserId)
+	voter.vote(testRoomId, testSongId, testUserId)
+	votes := voter.vote(testRoomId, testSongId, testUserId+1)
+
+	if votes != 2 {
+		t.Fatalf("Skip voter should count 2 users, but counted %d", votes)
+	}
+
+	if count := voter.count(testRoomId+1, testSongId); count != 0 {
+		t.Fatalf("Votes should not carry over to another room, but counted %d", count)
+	}
+}
+
+func TestSkipVote_whenSongChanges_startsOver(t *testing.T) {
+	voter := setupSkipVoter()
+	voter.vote(testRoomId, testSongId, testUserId)
+	voter.vote(testRoomId, testSongId, testUserId+1)
+
+	if count := voter.count(testRoomId, testSongId+1); count != 0 {
+		t.Fatalf("Next song should have no votes, but had %d", count)
+	}
+
+	if votes := voter.vote(testRoomId, testSongId+1, testUserId); votes != 1 {
+		t.Fatalf("Next song should have 1 vote, but had %d", votes)
+	}
+}
+
+func TestSkipVote_whenReset_clearsVotes(t *testing.T) {
+	voter := setupSkipVoter()
+	voter.vote(testRoomId, testSongId, testUserId)
+	voter.reset(testRoomId)
+
+	if count := voter.count(testRoomId, testSongId); count != 0 {
+		t.Fatalf("Skip voter should have no votes after reset, but had %d", count)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ * A DurationFairQueuer sorts the songs in the queue so that every user gets a
+ * fair share of play time. Each user accumulates the play time of the songs
+ * they've queued and the next song always goes to the user who would have had
+ * the least time so far. A user who submits long songs has to wait longer for
+ * their next turn than a user who submits short songs.
+ */
+
+package song_queue
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rickb777/date/period"
+
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+const (
+	// play time charged for songs whose duration isn't known
+	defaultSongLength = 4 * time.Minute
+)
+
+// A user submission managed by the duration fair queuer
+type timedSubmission struct {
+	song   *cmpb.Song    // A song in the queue
+	start  time.Duration // Play time at which the song's turn starts
+	length time.Duration // Play time charged to the user for the song
+	time   time.Time     // Time at which the song was submitted
+}
+
+// Implements sort.Interface for a slice of timed submissions
+type byPlayTime []*timedSubmission
+
+func (list byPlayTime) Len() int {
+	return len(list)
+}
+
+// Sort by the start of the song's turn and then by the earliest submitted song
+func (list byPlayTime) Less(i, j int) bool {
+	if list[i].start == list[j].start {
+		return list[i].time.Before(list[j].time)
+	} else {
+		return list[i].start < list[j].start
+	}
+}
+
+func (list byPlayTime) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+type DurationFairQueuer struct {
+	queue []*timedSubmission       // the queue of songs
+	users map[uint32]time.Duration // play time at which each user's songs end
+	clock time.Duration            // play time at which the last popped song started
+}
+
+func NewDurationFairQueuer() *DurationFairQueuer {
+	fair := new(DurationFairQueuer)
+	fair.queue = make([]*timedSubmission, 0)
+	fair.users = make(map[uint32]time.Duration)
+	fair.clock = 0
+	return fair
+}
+
+func (fair *DurationFairQueuer) push(song *cmpb.Song) {
+	start := fair.users[song.UserId]
+
+	// bump the user up to the current play time if they're behind
+	if start < fair.clock {
+		start = fair.clock
+	}
+
+	sub := &timedSubmission{
+		song:   song,
+		start:  start,
+		length: songLength(song),
+		time:   time.Now(),
+	}
+
+	fair.users[song.UserId] = sub.start + sub.length
+	fair.queue = append(fair.queue, sub)
+	sort.Sort(byPlayTime(fair.queue))
+}
+
+func (fair *DurationFairQueuer) length() int {
+	return len(fair.queue)
+}
+
+func (fair *DurationFairQueuer) pop() *cmpb.Song {
+	if fair.length() > 0 {
+		sub := fair.queue[0]
+		fair.queue[0] = nil
+		fair.queue = fair.queue[1:]
+
+		// advance the play time as songs are popped off
+		fair.clock = sub.start
+
+		return sub.song
+	}
+
+	return nil
+}
+
+func (fair *DurationFairQueuer) remove(songId uint32, userId uint32) error {
+	for i, sub := range fair.queue {
+		if sub.song.SongId == songId && sub.song.UserId == userId {
+			fair.queue = append(fair.queue[:i], fair.queue[i+1:]...)
+
+			// give the user back the play time of the removed song by moving
+			// their later songs forward
+			for _, later := range fair.queue {
+				if later.song.UserId == userId && later.start > sub.start {
+					later.start -= sub.length
+				}
+			}
+			fair.users[userId] -= sub.length
+
+			sort.Sort(byPlayTime(fair.queue))
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+}
+
+func (fair *DurationFairQueuer) move(songId uint32, userId uint32, offset int) error {
+	slots := make([]*timedSubmission, 0)
+	songs := make([]*cmpb.Song, 0)
+
+	for _, sub := range fair.queue {
+		if sub.song.UserId == userId {
+			slots = append(slots, sub)
+			songs = append(songs, sub.song)
+		}
+	}
+
+	songs, err := reorderUserSongs(songs, songId, offset)
+	if err != nil {
+		return err
+	}
+
+	// the user's turns shift by however much longer or shorter the songs
+	// before them have become
+	var shift time.Duration = 0
+	for i, sub := range slots {
+		sub.start += shift
+		sub.song = songs[i]
+		length := songLength(sub.song)
+		shift += length - sub.length
+		sub.length = length
+	}
+	fair.users[userId] += shift
+
+	sort.Sort(byPlayTime(fair.queue))
+	return nil
+}
+
+func (fair *DurationFairQueuer) save(state *bepb.QueueState) {
+	state.Clock = int64(fair.clock)
+
+	for _, sub := range fair.queue {
+		state.Entries = append(state.Entries, &bepb.QueueEntry{
+			Song:      sub.song,
+			Slot:      int64(sub.start),
+			Length:    int64(sub.length),
+			Submitted: sub.time.UnixNano(),
+		})
+	}
+
+	for userId, end := range fair.users {
+		state.Users[userId] = int64(end)
+	}
+}
+
+func (fair *DurationFairQueuer) restore(state *bepb.QueueState) {
+	fair.clock = time.Duration(state.Clock)
+
+	for _, entry := range state.Entries {
+		fair.queue = append(fair.queue, &timedSubmission{
+			song:   entry.Song,
+			start:  time.Duration(entry.Slot),
+			length: time.Duration(entry.Length),
+			time:   time.Unix(0, entry.Submitted),
+		})
+	}
+
+	for userId, end := range state.Users {
+		fair.users[userId] = time.Duration(end)
+	}
+
+	sort.Sort(byPlayTime(fair.queue))
+}
+
+func (fair *DurationFairQueuer) front() queueElement {
+	if len(fair.queue) > 0 {
+		return durationFairElement{
+			queue: fair.queue,
+			index: 0,
+		}
+	}
+
+	return nil
+}
+
+type durationFairElement struct {
+	queue []*timedSubmission // song queue
+	index int                // index of element
+}
+
+func (e durationFairElement) value() *cmpb.Song {
+	return e.queue[e.index].song
+}
+
+func (e durationFairElement) next() queueElement {
+	if e.index+1 < len(e.queue) {
+		return durationFairElement{
+			queue: e.queue,
+			index: e.index + 1,
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the play time of the song from its duration or metadata. Songs
+ * without a known duration are charged a default length.
+ */
+func songLength(song *cmpb.Song) time.Duration {
+	if song.Duration > 0 {
+		return time.Duration(song.Duration) * time.Second
+	}
+
+	duration, err := period.Parse(song.GetMetadata().GetDuration())
+	if err != nil || duration.IsZero() {
+		return defaultSongLength
+	}
+
+	return duration.DurationApprox()
+}
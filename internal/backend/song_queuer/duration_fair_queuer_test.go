@@ -0,0 +1,126 @@
+package song_queue
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+/*
+ * Kid A submits songs that are three times longer than Kid B's songs
+ */
+var timedSongs = []cmpb.Song{
+	{Title: "long 1", SongId: 1, Username: "Kid A", UserId: 1, Metadata: &cmpb.Metadata{Duration: "PT9M"}},
+	{Title: "long 2", SongId: 2, Username: "Kid A", UserId: 1, Metadata: &cmpb.Metadata{Duration: "PT9M"}},
+	{Title: "short 1", SongId: 3, Username: "Kid B", UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+	{Title: "short 2", SongId: 4, Username: "Kid B", UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+	{Title: "short 3", SongId: 5, Username: "Kid B", UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+	{Title: "short 4", SongId: 6, Username: "Kid B", UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+}
+
+func TestDurationFairPushPop(t *testing.T) {
+	queuer := NewDurationFairQueuer()
+
+	for i := range timedSongs {
+		queuer.push(&timedSongs[i])
+	}
+
+	// Kid B gets three short songs in for every long song from Kid A
+	expectedIds := []uint32{1, 3, 4, 5, 2, 6}
+	for _, expectedId := range expectedIds {
+		actualSong := queuer.pop()
+
+		if actualSong == nil || actualSong.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", actualSong)
+		}
+	}
+
+	if queuer.length() != 0 {
+		t.Error("Did not pop off all songs from queue. Length:", queuer.length())
+	}
+}
+
+func TestDurationFairLateUserDoesNotGetCredit(t *testing.T) {
+	queuer := NewDurationFairQueuer()
+	queuer.push(&timedSongs[0])
+	queuer.push(&timedSongs[1])
+	queuer.pop()
+	queuer.pop()
+
+	// Kid B shows up while Kid A's second song is playing. Kid B catches up to
+	// the current play time instead of getting credit for all the time they
+	// weren't around, so they only get the rest of Kid A's song to themselves.
+	queuer.push(&timedSongs[2])
+	queuer.push(&timedSongs[3])
+	queuer.push(&timedSongs[4])
+	queuer.push(&timedSongs[0])
+
+	expectedIds := []uint32{3, 4, 5, 1}
+	for _, expectedId := range expectedIds {
+		actualSong := queuer.pop()
+
+		if actualSong == nil || actualSong.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", actualSong)
+		}
+	}
+}
+
+func TestDurationFairRemove(t *testing.T) {
+	queuer := NewDurationFairQueuer()
+
+	for i := range timedSongs {
+		queuer.push(&timedSongs[i])
+	}
+
+	removed := &timedSongs[0]
+	if err := queuer.remove(removed.SongId, removed.UserId); err != nil {
+		t.Fatal("Failed to remove song:", err)
+	}
+
+	// Kid A's second song takes over the turn of the removed song
+	actualSong := queuer.pop()
+	if actualSong == nil || actualSong.SongId != timedSongs[1].SongId {
+		t.Error("Expected", &timedSongs[1], "but got", actualSong)
+	}
+
+	if queuer.length() != len(timedSongs)-2 {
+		t.Error("Expected length", len(timedSongs)-2, "but got", queuer.length())
+	}
+}
+
+func TestSongLength_whenDurationMissing_usesDefault(t *testing.T) {
+	song := &cmpb.Song{Title: "no metadata"}
+
+	if length := songLength(song); length != defaultSongLength {
+		t.Error("Expected default length", defaultSongLength, "but got", length)
+	}
+}
+
+func TestDurationFairMove_shiftsUserTurns(t *testing.T) {
+	songs := []*cmpb.Song{
+		{Title: "long", SongId: 1, UserId: 1, Metadata: &cmpb.Metadata{Duration: "PT9M"}},
+		{Title: "short", SongId: 2, UserId: 1, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+		{Title: "short 1", SongId: 3, UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+		{Title: "short 2", SongId: 4, UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+		{Title: "short 3", SongId: 5, UserId: 2, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+	}
+
+	queuer := NewDurationFairQueuer()
+	for _, song := range songs {
+		queuer.push(song)
+	}
+
+	// playing the short song first lets the long song start sooner
+	if err := queuer.move(2, 1, -1); err != nil {
+		t.Fatal("Failed to move song:", err)
+	}
+
+	expectedIds := []uint32{2, 3, 1, 4, 5}
+	for _, expectedId := range expectedIds {
+		actualSong := queuer.pop()
+
+		if actualSong == nil || actualSong.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", actualSong)
+		}
+	}
+}
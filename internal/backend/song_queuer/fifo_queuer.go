@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
 
@@ -41,7 +42,7 @@ func (fifo *FifoQueuer) pop() *cmpb.Song {
 	return nil
 }
 
-func (fifo *FifoQueuer) RemoveSong(songId uint32, userId uint32) error {
+func (fifo *FifoQueuer) remove(songId uint32, userId uint32) error {
 	for e := fifo.queue.Front(); e != nil; e = e.Next() {
 		var song *cmpb.Song = e.Value.(*cmpb.Song)
 
@@ -54,12 +55,51 @@ func (fifo *FifoQueuer) RemoveSong(songId uint32, userId uint32) error {
 	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
 }
 
-func (fifo *FifoQueuer) front() fifoElement {
-	return fifoElement{
-		current: fifo.queue.Front(),
+func (fifo *FifoQueuer) move(songId uint32, userId uint32, offset int) error {
+	slots := make([]*list.Element, 0)
+	songs := make([]*cmpb.Song, 0)
+
+	for e := fifo.queue.Front(); e != nil; e = e.Next() {
+		if song := e.Value.(*cmpb.Song); song.GetUserId() == userId {
+			slots = append(slots, e)
+			songs = append(songs, song)
+		}
+	}
+
+	songs, err := reorderUserSongs(songs, songId, offset)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range slots {
+		e.Value = songs[i]
+	}
+
+	return nil
+}
+
+func (fifo *FifoQueuer) save(state *bepb.QueueState) {
+	for e := fifo.queue.Front(); e != nil; e = e.Next() {
+		state.Entries = append(state.Entries, &bepb.QueueEntry{Song: e.Value.(*cmpb.Song)})
 	}
 }
 
+func (fifo *FifoQueuer) restore(state *bepb.QueueState) {
+	for _, entry := range state.Entries {
+		fifo.push(entry.Song)
+	}
+}
+
+func (fifo *FifoQueuer) front() queueElement {
+	if fifo.queue.Len() > 0 {
+		return fifoElement{
+			current: fifo.queue.Front(),
+		}
+	}
+
+	return nil
+}
+
 type fifoElement struct {
 	current *list.Element
 }
@@ -68,8 +108,12 @@ func (e fifoElement) value() *cmpb.Song {
 	return e.current.Value.(*cmpb.Song)
 }
 
-func (e fifoElement) next() fifoElement {
-	return fifoElement{
-		current: e.current.Next(),
+func (e fifoElement) next() queueElement {
+	if e.current.Next() != nil {
+		return fifoElement{
+			current: e.current.Next(),
+		}
 	}
+
+	return nil
 }
@@ -10,11 +10,11 @@ import (
  * List of sample song data to test against
  */
 var sampleSongs = []cmpb.Song{
-	{"title 1", 1, "Kid A", 1, cmpb.ServiceType_Youtube, "0xdeadbeef"},
-	{"title 2", 2, "Kid B", 2, cmpb.ServiceType_Youtube, "0xba5eba11"},
-	{"title 3", 3, "Kid A", 1, cmpb.ServiceType_Youtube, "0xf01dab1e"},
-	{"title 4", 4, "Kid B", 2, cmpb.ServiceType_Youtube, "0xb01dface"},
-	{"title 5", 5, "Kid A", 1, cmpb.ServiceType_Youtube, "0xca55e77e"},
+	{Title: "title 1", SongId: 1, Username: "Kid A", UserId: 1, Service: cmpb.ServiceType_Youtube, ServiceId: "0xdeadbeef"},
+	{Title: "title 2", SongId: 2, Username: "Kid B", UserId: 2, Service: cmpb.ServiceType_Youtube, ServiceId: "0xba5eba11"},
+	{Title: "title 3", SongId: 3, Username: "Kid A", UserId: 1, Service: cmpb.ServiceType_Youtube, ServiceId: "0xf01dab1e"},
+	{Title: "title 4", SongId: 4, Username: "Kid B", UserId: 2, Service: cmpb.ServiceType_Youtube, ServiceId: "0xb01dface"},
+	{Title: "title 5", SongId: 5, Username: "Kid A", UserId: 1, Service: cmpb.ServiceType_Youtube, ServiceId: "0xca55e77e"},
 }
 
 /*
@@ -88,3 +88,24 @@ func TestManyQueue(t *testing.T) {
 		t.Error("Expected nil, but got", nextSong)
 	}
 }
+
+func TestFifoMove(t *testing.T) {
+	fifo := NewFifoQueuer()
+
+	for i := 0; i < len(sampleSongs); i++ {
+		fifo.push(&sampleSongs[i])
+	}
+
+	if err := fifo.move(3, 1, 1); err != nil {
+		t.Fatal("Failed to move song:", err)
+	}
+
+	expectedIds := []uint32{1, 2, 5, 4, 3}
+	for _, expectedId := range expectedIds {
+		actualSong := fifo.pop()
+
+		if actualSong == nil || actualSong.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", actualSong)
+		}
+	}
+}
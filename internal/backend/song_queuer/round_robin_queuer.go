@@ -10,6 +10,7 @@ import (
 	"sort"
 	"time"
 
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
 
@@ -119,6 +120,64 @@ func (roundRobin *RoundRobinQueuer) remove(songId uint32, userId uint32) error {
 	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
 }
 
+func (roundRobin *RoundRobinQueuer) move(songId uint32, userId uint32, offset int) error {
+	slots := make([]*submission, 0)
+	songs := make([]*cmpb.Song, 0)
+
+	for _, sub := range roundRobin.queue {
+		if sub.song.UserId == userId {
+			slots = append(slots, sub)
+			songs = append(songs, sub.song)
+		}
+	}
+
+	songs, err := reorderUserSongs(songs, songId, offset)
+	if err != nil {
+		return err
+	}
+
+	// the songs trade places while the rounds stay with the slots
+	for i, sub := range slots {
+		sub.song = songs[i]
+	}
+
+	return nil
+}
+
+func (roundRobin *RoundRobinQueuer) save(state *bepb.QueueState) {
+	state.Clock = int64(roundRobin.round)
+
+	for _, sub := range roundRobin.queue {
+		state.Entries = append(state.Entries, &bepb.QueueEntry{
+			Song:      sub.song,
+			Slot:      int64(sub.round),
+			Submitted: sub.time.UnixNano(),
+		})
+	}
+
+	for userId, round := range roundRobin.users {
+		state.Users[userId] = int64(round)
+	}
+}
+
+func (roundRobin *RoundRobinQueuer) restore(state *bepb.QueueState) {
+	roundRobin.round = int(state.Clock)
+
+	for _, entry := range state.Entries {
+		roundRobin.queue = append(roundRobin.queue, &submission{
+			song:  entry.Song,
+			round: int(entry.Slot),
+			time:  time.Unix(0, entry.Submitted),
+		})
+	}
+
+	for userId, round := range state.Users {
+		roundRobin.users[userId] = int(round)
+	}
+
+	sort.Sort(byRoundRobin(roundRobin.queue))
+}
+
 func (roundRobin *RoundRobinQueuer) front() queueElement {
 	if len(roundRobin.queue) > 0 {
 		new_element := roundRobinElement{
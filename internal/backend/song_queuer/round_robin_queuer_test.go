@@ -84,3 +84,47 @@ func TestRoundRobinSort(t *testing.T) {
 		}
 	}
 }
+
+func TestMove_keepsOtherUsersTurns(t *testing.T) {
+	queuer := NewRoundRobinQueuer()
+
+	for i := 0; i < len(sampleSongs); i++ {
+		queuer.push(&sampleSongs[i])
+	}
+
+	// Kid A's last song takes their first turn and the rest of Kid A's songs
+	// shift back a turn
+	if err := queuer.move(5, 1, -2); err != nil {
+		t.Fatal("Failed to move song:", err)
+	}
+
+	expectedIds := []uint32{5, 2, 1, 4, 3}
+	for _, expectedId := range expectedIds {
+		actualSong := queuer.pop()
+
+		if actualSong == nil || actualSong.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", actualSong)
+		}
+	}
+}
+
+func TestMove_whenOutOfTurns_fails(t *testing.T) {
+	queuer := NewRoundRobinQueuer()
+
+	for i := 0; i < len(sampleSongs); i++ {
+		queuer.push(&sampleSongs[i])
+	}
+
+	if err := queuer.move(1, 1, -1); err == nil {
+		t.Error("Expected an error when moving the first song up")
+	}
+
+	if err := queuer.move(4, 2, 1); err == nil {
+		t.Error("Expected an error when moving the last song down")
+	}
+
+	// only the submitter can move their song
+	if err := queuer.move(3, 2, -1); err == nil {
+		t.Error("Expected an error when moving another user's song")
+	}
+}
@@ -1,10 +1,12 @@
 /*
- * Manages the song queue and the state currently playing song.
+ * Manages the song queues and the state of the currently playing song of each
+ * room.
  */
 
 package song_queue
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"sync"
@@ -15,87 +17,276 @@ import (
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
 
-const (
-	QueueSnapshot string = "/tmp/ytbox.queue" // location of the queue snapshot
-)
+/*
+ * Persistent storage for the state of the room queues
+ */
+type QueueStore interface {
+	// Save the state of a room's queue, replacing the room's earlier state
+	SaveQueueState(state *bepb.QueueState) error
+
+	// Get the saved state of every room's queue
+	GetQueueStates() ([]*bepb.QueueState, error)
+
+	// Record that a song started playing
+	MarkSongPlayed(songId uint32) error
+}
+
+/*
+ * The queue and now playing state belonging to a single room
+ */
+type roomQueue struct {
+	queue      songQueuer // the playlist of songs
+	strategy   Strategy   // the strategy implemented by the queue
+	nowPlaying *cmpb.Song // the currently playing song
+	resume     bool       // true if the now playing song was restored and should be played again
+	cond       *sync.Cond // condition variable on the queue
+
+	// writes to the store waiting on the room's saver, guarded by the lock
+	played   []uint32      // songs that started playing but aren't recorded yet
+	snapshot bool          // true if a snapshot of the queue should be saved
+	save     chan struct{} // wakes the room's saver
+}
 
 /*
- * Manages the song queue
+ * Manages the song queue of every room
  */
 type SongQueueManager struct {
-	queue      songQueuer    // the playlist of songs
-	lock       *sync.RWMutex // read/write lock on the playlist
-	npLock     *sync.Mutex   // lock on the now playing value
-	cLock      *sync.Mutex   // mutex for condition variable
-	cond       *sync.Cond    // condition variable on the queue
-	nowPlaying *cmpb.Song    // the currently playing song
+	rooms    map[uint32]*roomQueue // queue state keyed by room id
+	strategy Strategy              // queuing strategy of new rooms
+	store    QueueStore            // storage for the queue snapshots
+	lock     *sync.RWMutex         // read/write lock on the playlists
+	npLock   *sync.Mutex           // lock on the now playing values
+	cLock    *sync.Mutex           // mutex for the condition variables
+
+	closed chan struct{}  // closed to stop the room savers
+	savers sync.WaitGroup // the running room savers
 }
 
 /*
- * Initializes the queue
+ * Initializes the queue. Rooms order their songs using the given strategy
+ * unless they were added with a strategy of their own. Snapshots of the queues
+ * are saved to the store, which may be nil to keep the queues in memory only.
  */
-func (manager *SongQueueManager) Init(queuer songQueuer) {
-	manager.queue = queuer
+func (manager *SongQueueManager) Init(strategy Strategy, store QueueStore) {
+	manager.rooms = make(map[uint32]*roomQueue)
+	manager.strategy = strategy
+	manager.store = store
 	manager.lock = new(sync.RWMutex)
 	manager.npLock = new(sync.Mutex)
 	manager.cLock = new(sync.Mutex)
-	manager.cond = sync.NewCond(manager.cLock)
+	manager.closed = make(chan struct{})
 }
 
 /*
- * Adds a song to the queue
+ * Stops the room savers once they have written what is waiting on them
  */
-func (manager *SongQueueManager) AddSong(song *cmpb.Song) {
+func (manager *SongQueueManager) Close() {
+	close(manager.closed)
+	manager.savers.Wait()
+}
+
+/*
+ * Returns the queue state of the given room, creating it if this is the first
+ * time the room has been seen. The caller must hold the write lock.
+ */
+func (manager *SongQueueManager) unsyncGetRoom(roomId uint32) *roomQueue {
+	room, exists := manager.rooms[roomId]
+	if !exists {
+		room = &roomQueue{
+			queue:    newQueuer(manager.strategy),
+			strategy: manager.strategy,
+			cond:     sync.NewCond(manager.cLock),
+		}
+		manager.rooms[roomId] = room
+
+		if manager.store != nil {
+			room.save = make(chan struct{}, 1)
+			manager.savers.Add(1)
+			go manager.runSaver(roomId, room)
+		}
+	}
+
+	return room
+}
+
+/*
+ * Returns the queue state of the given room, creating it if needed
+ */
+func (manager *SongQueueManager) getRoom(roomId uint32) *roomQueue {
+	manager.lock.RLock()
+	room, exists := manager.rooms[roomId]
+	manager.lock.RUnlock()
+
+	if exists {
+		return room
+	}
+
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
+	return manager.unsyncGetRoom(roomId)
+}
+
+/*
+ * Returns the queuing strategy used by the room
+ */
+func (manager *SongQueueManager) Strategy(roomId uint32) Strategy {
+	room := manager.getRoom(roomId)
 
-	manager.queue.push(song)
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return room.strategy
+}
 
-	if manager.queue.length() == 1 {
-		manager.cond.Broadcast()
+/*
+ * Switch the queuing strategy of the room. The songs already in the room's
+ * queue are pushed onto the new queue in their current order.
+ */
+func (manager *SongQueueManager) SetStrategy(roomId uint32, strategy Strategy) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	room := manager.unsyncGetRoom(roomId)
+	if room.strategy == strategy {
+		return
+	}
+
+	queue := newQueuer(strategy)
+	for e := room.queue.front(); e != nil; e = e.next() {
+		queue.push(e.value())
+	}
+
+	log.Printf("Switched room %d from %s to %s with %d songs", roomId, room.strategy, strategy, queue.length())
+	room.queue = queue
+	room.strategy = strategy
+}
+
+/*
+ * Adds a song to the queue of the room the song was submitted to
+ */
+func (manager *SongQueueManager) AddSong(song *cmpb.Song) {
+	manager.AddSongIf(song, func(repeat *cmpb.Song, playing bool) string { return "" })
+}
+
+/*
+ * Adds a song to the queue of its room if the admit function allows it. The
+ * function is given the copy of the song that is already queued or playing in
+ * the room, if there is one, and returns why the song is rejected or an empty
+ * string to queue it. It runs while the room's queue is locked, so no other
+ * copy of the song can be queued before the song is.
+ */
+func (manager *SongQueueManager) AddSongIf(song *cmpb.Song, admit func(repeat *cmpb.Song, playing bool) string) string {
+	room := manager.getRoom(song.RoomId)
+
+	manager.npLock.Lock()
+	manager.lock.Lock()
+
+	var repeat *cmpb.Song
+	playing := room.nowPlaying != nil && room.nowPlaying.Service == song.Service && room.nowPlaying.ServiceId == song.ServiceId
+	if playing {
+		repeat = room.nowPlaying
+	} else {
+		repeat = findQueued(room.queue, song.Service, song.ServiceId)
+	}
+
+	message := admit(repeat, playing)
+	if message == "" {
+		room.queue.push(song)
+	}
+	wasEmpty := room.queue.length() == 1
+	manager.lock.Unlock()
+	manager.npLock.Unlock()
+
+	// the queue lock is released before signalling so that waiters checking the
+	// queue length while holding the condition lock can't deadlock with us
+	if message == "" && wasEmpty {
+		manager.cLock.Lock()
+		room.cond.Broadcast()
+		manager.cLock.Unlock()
 	}
+
+	return message
 }
 
 /*
- * Returns the length of the queue
+ * Returns the length of the room's queue
  */
-func (manager *SongQueueManager) Len() int {
+func (manager *SongQueueManager) Len(roomId uint32) int {
+	room := manager.getRoom(roomId)
+
 	manager.lock.RLock()
 	defer manager.lock.RUnlock()
-	return manager.queue.length()
+	return room.queue.length()
 }
 
 /*
- * Clear the now playing state
+ * Clear the now playing state of the room
  */
-func (manager *SongQueueManager) ClearNowPlaying() {
+func (manager *SongQueueManager) ClearNowPlaying(roomId uint32) {
+	room := manager.getRoom(roomId)
+
 	manager.npLock.Lock()
 	defer manager.npLock.Unlock()
 
-	manager.nowPlaying = nil
+	room.nowPlaying = nil
+	room.resume = false
 }
 
 /*
- * Returns the data for the currently playing song
+ * Marks the song playing in the room to be played again by the next pop of
+ * the queue. Used when every player left the room in the middle of the song.
+ * Returns false if no song is playing.
  */
-func (manager *SongQueueManager) NowPlaying() *cmpb.Song {
+func (manager *SongQueueManager) ResumeNowPlaying(roomId uint32) bool {
+	room := manager.getRoom(roomId)
+
 	manager.npLock.Lock()
 	defer manager.npLock.Unlock()
 
-	return manager.nowPlaying
+	room.resume = room.nowPlaying != nil
+	return room.resume
 }
 
 /*
- * Returns a list of songs in the queue
+ * Stops the song playing in the room from being played again by the next pop
+ * of the queue. Used when a player comes back still playing the song or done
+ * with it. Returns true if the song was going to be played again.
  */
-func (manager *SongQueueManager) GetPlaylist() *bepb.Playlist {
-	songs := make([]*cmpb.Song, manager.queue.length())
-	var idx int = 0
+func (manager *SongQueueManager) CancelResume(roomId uint32) bool {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	resume := room.resume
+	room.resume = false
+	return resume
+}
+
+/*
+ * Returns the data for the song currently playing in the room
+ */
+func (manager *SongQueueManager) NowPlaying(roomId uint32) *cmpb.Song {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	return room.nowPlaying
+}
+
+/*
+ * Returns a list of songs in the room's queue
+ */
+func (manager *SongQueueManager) GetPlaylist(roomId uint32) *bepb.Playlist {
+	room := manager.getRoom(roomId)
 
 	manager.lock.RLock()
 	defer manager.lock.RUnlock()
 
-	for e := manager.queue.front(); e != nil; e = e.next() {
+	songs := make([]*cmpb.Song, room.queue.length())
+	var idx int = 0
+
+	for e := room.queue.front(); e != nil; e = e.next() {
 		songs[idx] = e.value()
 		idx++
 	}
@@ -104,52 +295,270 @@ func (manager *SongQueueManager) GetPlaylist() *bepb.Playlist {
 }
 
 /*
- * Blocks the current thread while the size of the playlist is zero. The playlist
- * will notify all blocked threads that the size is once again greater than one
- * when a new song is added.
+ * Returns the number of songs the user has waiting in the room's queue
  */
-func (manager *SongQueueManager) WaitForMoreSongs() {
-	manager.cond.L.Lock()
-	for manager.Len() == 0 {
-		manager.ClearNowPlaying()
-		manager.cond.Wait()
+func (manager *SongQueueManager) CountUserSongs(roomId uint32, userId uint32) int {
+	room := manager.getRoom(roomId)
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	count := 0
+	for e := room.queue.front(); e != nil; e = e.next() {
+		if e.value().GetUserId() == userId {
+			count++
+		}
 	}
-	manager.cond.L.Unlock()
+
+	return count
 }
 
 /*
- * Pops the next song off the queue and returns it
+ * Blocks the current thread while the size of the room's playlist is zero. The
+ * playlist will notify all blocked threads that the size is once again greater
+ * than one when a new song is added. Returns false as soon as the done channel
+ * is closed. Threads that are already blocked notice only after being woken by
+ * InterruptWait.
  */
-func (manager *SongQueueManager) PopQueue() *cmpb.Song {
+func (manager *SongQueueManager) WaitForMoreSongs(roomId uint32, done <-chan struct{}) bool {
+	room := manager.getRoom(roomId)
+
+	room.cond.L.Lock()
+	defer room.cond.L.Unlock()
+
+	for {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+
+		if manager.hasNext(room) {
+			return true
+		}
+
+		manager.ClearNowPlaying(roomId)
+		manager.SaveSnapshot(roomId)
+		room.cond.Wait()
+	}
+}
+
+/*
+ * Wakes the threads waiting on the room's playlist so that they check whether
+ * they should stop waiting
+ */
+func (manager *SongQueueManager) InterruptWait(roomId uint32) {
+	room := manager.getRoom(roomId)
+
+	manager.cLock.Lock()
+	room.cond.Broadcast()
+	manager.cLock.Unlock()
+}
+
+/*
+ * Returns true if there is a song for the room to play next
+ */
+func (manager *SongQueueManager) hasNext(room *roomQueue) bool {
 	manager.npLock.Lock()
 	defer manager.npLock.Unlock()
-	manager.nowPlaying = nil
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	return room.resume || room.queue.length() > 0
+}
+
+/*
+ * Pops the next song off the room's queue and returns it
+ */
+func (manager *SongQueueManager) PopQueue(roomId uint32) *cmpb.Song {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	// a song that was playing before the queue was restored gets played again
+	if room.resume {
+		room.resume = false
+		return room.nowPlaying
+	}
+
+	return manager.unsyncPop(room)
+}
+
+/*
+ * Pops the next song off the room's queue if the song with the given id is
+ * still the one playing in the room. Returns the next song and true if the
+ * song was skipped, or false if a different song is playing by now.
+ */
+func (manager *SongQueueManager) SkipSong(roomId uint32, songId uint32) (*cmpb.Song, bool) {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	if room.nowPlaying == nil || room.nowPlaying.SongId != songId {
+		return nil, false
+	}
+
+	room.resume = false
+	return manager.unsyncPop(room), true
+}
+
+/*
+ * Replaces the room's now playing song with the next song popped off its
+ * queue. The caller must hold the now playing lock.
+ */
+func (manager *SongQueueManager) unsyncPop(room *roomQueue) *cmpb.Song {
+	room.nowPlaying = nil
 
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
 
-	if manager.queue.length() > 0 {
-		manager.nowPlaying = manager.queue.pop()
+	if room.queue.length() > 0 {
+		room.nowPlaying = room.queue.pop()
+	}
+
+	if room.nowPlaying != nil && manager.store != nil {
+		room.played = append(room.played, room.nowPlaying.SongId)
+		room.wakeSaver()
 	}
 
-	return manager.nowPlaying
+	return room.nowPlaying
 }
 
 /*
- * Removes the identified song from the queue. Both the song id and uesr id
- * must match in order for the song to be successfully removed.
+ * Returns the song that the next pop of the room's queue would return without
+ * popping it. Returns nil if the queue is empty.
  */
-func (manager *SongQueueManager) RemoveSong(songId uint32, userId uint32) error {
+func (manager *SongQueueManager) PeekQueue(roomId uint32) *cmpb.Song {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	if room.resume {
+		return room.nowPlaying
+	}
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	if e := room.queue.front(); e != nil {
+		return e.value()
+	}
+
+	return nil
+}
+
+/*
+ * Removes the identified song from the room's queue. Both the song id and user
+ * id must match in order for the song to be successfully removed.
+ */
+func (manager *SongQueueManager) RemoveSong(roomId uint32, songId uint32, userId uint32) error {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
-	return manager.queue.remove(songId, userId)
+
+	room, exists := manager.rooms[roomId]
+	if !exists {
+		return fmt.Errorf("Song with id %d does not exist in the queue", songId)
+	}
+
+	return room.queue.remove(songId, userId)
+}
+
+/*
+ * Moves the identified song by offset turns among the songs queued by the same
+ * user. Both the song id and user id must match in order for the song to be
+ * moved.
+ */
+func (manager *SongQueueManager) MoveSong(roomId uint32, songId uint32, userId uint32, offset int) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	room, exists := manager.rooms[roomId]
+	if !exists {
+		return fmt.Errorf("Song with id %d does not exist in the queue", songId)
+	}
+
+	return room.queue.move(songId, userId, offset)
+}
+
+/*
+ * Returns the song with the given id from the room's queue or nil if the song
+ * isn't queued in the room
+ */
+func (manager *SongQueueManager) GetSong(roomId uint32, songId uint32) *cmpb.Song {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	room, exists := manager.rooms[roomId]
+	if !exists {
+		return nil
+	}
+
+	for e := room.queue.front(); e != nil; e = e.next() {
+		if e.value().SongId == songId {
+			return e.value()
+		}
+	}
+
+	return nil
 }
 
 /*
- * Saves the playlist to a file
+ * Returns the song from the given service with the given service id if it is
+ * queued or playing in the room. Otherwise nil is returned.
  */
-func (manager *SongQueueManager) SavePlaylist(path string) error {
-	playlist := manager.GetPlaylist()
+func (manager *SongQueueManager) FindSong(roomId uint32, service cmpb.ServiceType, serviceId string) *cmpb.Song {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	if room.nowPlaying != nil && room.nowPlaying.Service == service && room.nowPlaying.ServiceId == serviceId {
+		return room.nowPlaying
+	}
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	return findQueued(room.queue, service, serviceId)
+}
+
+/*
+ * Sets the net votes of a song in the room's queue. Rooms with a vote-aware
+ * strategy reorder their queue and may drop the song, in which case true is
+ * returned.
+ */
+func (manager *SongQueueManager) VoteSong(roomId uint32, songId uint32, votes int32) (bool, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	room, exists := manager.rooms[roomId]
+	if !exists {
+		return false, fmt.Errorf("Song with id %d does not exist in the queue", songId)
+	}
+
+	if queue, ok := room.queue.(voter); ok {
+		return queue.vote(songId, votes)
+	}
+
+	for e := room.queue.front(); e != nil; e = e.next() {
+		if e.value().SongId == songId {
+			e.value().Votes = votes
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("Song with id %d does not exist in the queue", songId)
+}
+
+/*
+ * Saves the room's playlist to a file
+ */
+func (manager *SongQueueManager) SavePlaylist(roomId uint32, path string) error {
+	playlist := manager.GetPlaylist(roomId)
 
 	out, err := proto.Marshal(playlist)
 	if err != nil {
@@ -165,3 +574,161 @@ func (manager *SongQueueManager) SavePlaylist(path string) error {
 
 	return nil
 }
+
+/*
+ * Returns the state of the room's queue, including the ordering data kept by
+ * the room's queuer
+ */
+func (manager *SongQueueManager) Snapshot(roomId uint32) *bepb.QueueState {
+	room := manager.getRoom(roomId)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	state := &bepb.QueueState{
+		RoomId:     roomId,
+		Strategy:   string(room.strategy),
+		NowPlaying: room.nowPlaying,
+		Users:      make(map[uint32]int64),
+	}
+	room.queue.save(state)
+
+	return state
+}
+
+/*
+ * Replaces the room's queue and now playing song with the saved state. The
+ * now playing song is the next song to be popped off the queue.
+ */
+func (manager *SongQueueManager) Restore(state *bepb.QueueState) {
+	strategy, err := ParseStrategy(state.Strategy)
+	if err != nil {
+		log.Printf("Restoring room %d with strategy %s instead: %v", state.RoomId, manager.strategy, err)
+		strategy = manager.strategy
+	}
+
+	queue := newQueuer(strategy)
+	queue.restore(state)
+
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	room := manager.unsyncGetRoom(state.RoomId)
+	room.queue = queue
+	room.strategy = strategy
+	room.nowPlaying = state.NowPlaying
+	room.resume = state.NowPlaying != nil
+}
+
+/*
+ * Saves a snapshot of the room's queue to the store. The snapshot is taken and
+ * written by the room's saver so that the caller doesn't wait on the store.
+ */
+func (manager *SongQueueManager) SaveSnapshot(roomId uint32) {
+	if manager.store == nil {
+		return
+	}
+
+	room := manager.getRoom(roomId)
+
+	manager.lock.Lock()
+	room.snapshot = true
+	manager.lock.Unlock()
+
+	room.wakeSaver()
+}
+
+/*
+ * Writes the room's played songs and snapshots to the store until the manager
+ * is closed. Nothing is locked while writing, so a slow store doesn't hold up
+ * the queues.
+ */
+func (manager *SongQueueManager) runSaver(roomId uint32, room *roomQueue) {
+	defer manager.savers.Done()
+
+	for {
+		select {
+		case <-room.save:
+			manager.writeRoom(roomId, room)
+		case <-manager.closed:
+			manager.writeRoom(roomId, room)
+			return
+		}
+	}
+}
+
+/*
+ * Writes what is waiting on the room's saver to the store
+ */
+func (manager *SongQueueManager) writeRoom(roomId uint32, room *roomQueue) {
+	manager.lock.Lock()
+	played := room.played
+	snapshot := room.snapshot
+	room.played = nil
+	room.snapshot = false
+	manager.lock.Unlock()
+
+	for _, songId := range played {
+		if err := manager.store.MarkSongPlayed(songId); err != nil {
+			log.Printf("Failed to mark song %d played in room %d with error: %v", songId, roomId, err)
+		}
+	}
+
+	if snapshot {
+		if err := manager.store.SaveQueueState(manager.Snapshot(roomId)); err != nil {
+			log.Printf("Failed to save snapshot of room %d with error: %v", roomId, err)
+		}
+	}
+}
+
+/*
+ * Wakes the room's saver unless it's already due to wake
+ */
+func (room *roomQueue) wakeSaver() {
+	select {
+	case room.save <- struct{}{}:
+	default:
+	}
+}
+
+/*
+ * Restores the queue of every room that has a snapshot in the store
+ */
+func (manager *SongQueueManager) LoadSnapshots() error {
+	if manager.store == nil {
+		return nil
+	}
+
+	states, err := manager.store.GetQueueStates()
+	if err != nil {
+		log.Printf("Failed to load queue snapshots with error: %v", err)
+		return err
+	}
+
+	for _, state := range states {
+		manager.Restore(state)
+		log.Printf("Restored room %d with %d songs", state.RoomId, len(state.Entries))
+	}
+
+	return nil
+}
+
+/*
+ * Returns the song from the given service with the given service id if it is
+ * in the queue. The caller must hold the lock.
+ */
+func findQueued(queue songQueuer, service cmpb.ServiceType, serviceId string) *cmpb.Song {
+	for e := queue.front(); e != nil; e = e.next() {
+		if e.value().Service == service && e.value().ServiceId == serviceId {
+			return e.value()
+		}
+	}
+
+	return nil
+}
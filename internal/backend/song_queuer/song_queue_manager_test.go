@@ -0,0 +1,464 @@
+package song_queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+const (
+	testRoomA uint32 = 1
+	testRoomB uint32 = 2
+)
+
+func newTestManager() *SongQueueManager {
+	manager := new(SongQueueManager)
+	manager.Init(RoundRobinStrategy, nil)
+	return manager
+}
+
+/*
+ * Songs added to one room should not show up in the queue of another room
+ */
+func TestAddSong_keepsRoomsSeparate(t *testing.T) {
+	manager := newTestManager()
+
+	songA := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	songB := &cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomB}
+	manager.AddSong(songA)
+	manager.AddSong(songB)
+
+	if manager.Len(testRoomA) != 1 {
+		t.Error("Expected room A to have 1 song but had", manager.Len(testRoomA))
+	}
+
+	playlist := manager.GetPlaylist(testRoomB)
+	if len(playlist.Songs) != 1 || compareSongs(playlist.Songs[0], songB) == false {
+		t.Error("Expected room B playlist to only contain", songB, "but got", playlist.Songs)
+	}
+}
+
+/*
+ * Popping a song should only change the now playing song of that room
+ */
+func TestPopQueue_setsNowPlayingPerRoom(t *testing.T) {
+	manager := newTestManager()
+
+	songA := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	songB := &cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomB}
+	manager.AddSong(songA)
+	manager.AddSong(songB)
+
+	popped := manager.PopQueue(testRoomA)
+	if popped == nil || compareSongs(popped, songA) == false {
+		t.Error("Expected", songA, "but got", popped)
+	}
+
+	if nowPlaying := manager.NowPlaying(testRoomA); nowPlaying != popped {
+		t.Error("Expected room A to be playing", popped, "but got", nowPlaying)
+	}
+
+	if nowPlaying := manager.NowPlaying(testRoomB); nowPlaying != nil {
+		t.Error("Expected room B to have nothing playing but got", nowPlaying)
+	}
+
+	if manager.Len(testRoomB) != 1 {
+		t.Error("Expected room B to still have 1 song but had", manager.Len(testRoomB))
+	}
+}
+
+/*
+ * Peeking at the queue should return the song the next pop returns without
+ * popping it
+ */
+func TestPeekQueue_returnsNextSongWithoutPopping(t *testing.T) {
+	manager := newTestManager()
+
+	if peeked := manager.PeekQueue(testRoomA); peeked != nil {
+		t.Error("Expected an empty queue to have nothing to peek at but got", peeked)
+	}
+
+	songA := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	songB := &cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomA}
+	manager.AddSong(songA)
+	manager.AddSong(songB)
+
+	peeked := manager.PeekQueue(testRoomA)
+	if peeked == nil || compareSongs(peeked, songA) == false {
+		t.Error("Expected to peek at", songA, "but got", peeked)
+	}
+
+	if manager.Len(testRoomA) != 2 {
+		t.Error("Expected peeking to leave 2 songs in the queue but had", manager.Len(testRoomA))
+	}
+
+	if popped := manager.PopQueue(testRoomA); popped != peeked {
+		t.Error("Expected to pop the peeked song", peeked, "but got", popped)
+	}
+}
+
+/*
+ * Only the user's songs waiting in the given room should be counted
+ */
+func TestCountUserSongs_countsSongsOfUserInRoom(t *testing.T) {
+	manager := newTestManager()
+
+	manager.AddSong(&cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA})
+	manager.AddSong(&cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomA})
+	manager.AddSong(&cmpb.Song{Title: "title C", SongId: 3, UserId: 1, RoomId: testRoomA})
+	manager.AddSong(&cmpb.Song{Title: "title D", SongId: 4, UserId: 1, RoomId: testRoomB})
+
+	if count := manager.CountUserSongs(testRoomA, 1); count != 2 {
+		t.Error("Expected user 1 to have 2 songs in room A but had", count)
+	}
+
+	manager.PopQueue(testRoomA)
+	if count := manager.CountUserSongs(testRoomA, 1); count != 1 {
+		t.Error("Expected the playing song not to be counted but had", count)
+	}
+}
+
+/*
+ * Removing a song should only look in the given room's queue
+ */
+func TestRemoveSong_whenSongInOtherRoom_fails(t *testing.T) {
+	manager := newTestManager()
+
+	songA := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	manager.AddSong(songA)
+
+	if err := manager.RemoveSong(testRoomB, songA.SongId, songA.UserId); err == nil {
+		t.Error("Expected an error when removing a song from the wrong room")
+	}
+
+	if err := manager.RemoveSong(testRoomA, songA.SongId, songA.UserId); err != nil {
+		t.Error("Failed to remove song from its room:", err)
+	}
+
+	if manager.Len(testRoomA) != 0 {
+		t.Error("Expected room A to be empty but had", manager.Len(testRoomA))
+	}
+}
+
+/*
+ * Switching strategies should keep every queued song
+ */
+func TestSetStrategy_keepsQueuedSongs(t *testing.T) {
+	manager := newTestManager()
+
+	for i := range sampleSongs {
+		song := &cmpb.Song{Title: sampleSongs[i].Title, SongId: sampleSongs[i].SongId,
+			UserId: sampleSongs[i].UserId, RoomId: testRoomA}
+		manager.AddSong(song)
+	}
+
+	manager.SetStrategy(testRoomA, FifoStrategy)
+
+	if strategy := manager.Strategy(testRoomA); strategy != FifoStrategy {
+		t.Error("Expected strategy", FifoStrategy, "but got", strategy)
+	}
+
+	if strategy := manager.Strategy(testRoomB); strategy != RoundRobinStrategy {
+		t.Error("Expected other room to keep strategy", RoundRobinStrategy, "but got", strategy)
+	}
+
+	// the round robin order is kept when moving over to a fifo queue
+	expectedIds := []uint32{1, 2, 3, 4, 5}
+	for _, expectedId := range expectedIds {
+		song := manager.PopQueue(testRoomA)
+		if song == nil || song.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", song)
+		}
+	}
+
+	if manager.Len(testRoomA) != 0 {
+		t.Error("Expected room A to be empty but had", manager.Len(testRoomA))
+	}
+}
+
+func TestParseStrategy_whenUnknown_fails(t *testing.T) {
+	if _, err := ParseStrategy("loudest-first"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+
+	if strategy, err := ParseStrategy("duration-fair"); err != nil || strategy != DurationFairStrategy {
+		t.Error("Expected", DurationFairStrategy, "but got", strategy, err)
+	}
+}
+
+/*
+ * A queue restored from a snapshot should order new songs the same way the
+ * original queue would have
+ */
+func TestRestore_keepsQueuingOrder(t *testing.T) {
+	for _, strategy := range []Strategy{FifoStrategy, RoundRobinStrategy, DurationFairStrategy, VotingStrategy} {
+		original := newTestManager()
+		original.SetStrategy(testRoomA, strategy)
+
+		for i := range timedSongs {
+			song := proto.Clone(&timedSongs[i]).(*cmpb.Song)
+			song.RoomId = testRoomA
+			original.AddSong(song)
+		}
+		original.PopQueue(testRoomA)
+
+		restored := newTestManager()
+		restored.Restore(original.Snapshot(testRoomA))
+
+		if restored.Strategy(testRoomA) != strategy {
+			t.Error("Expected restored strategy", strategy, "but got", restored.Strategy(testRoomA))
+		}
+
+		late := &cmpb.Song{Title: "late", SongId: 7, UserId: 3, RoomId: testRoomA}
+		original.AddSong(late)
+		restored.AddSong(late)
+
+		// the restored queue replays the song that was playing first
+		if song := restored.PopQueue(testRoomA); song == nil || song.SongId != timedSongs[0].SongId {
+			t.Error(strategy, "should resume song", timedSongs[0].SongId, "but got", song)
+		}
+
+		for original.Len(testRoomA) > 0 {
+			expected := original.PopQueue(testRoomA)
+			actual := restored.PopQueue(testRoomA)
+
+			if actual == nil || actual.SongId != expected.SongId {
+				t.Error(strategy, "expected song", expected.SongId, "but got", actual)
+			}
+		}
+
+		if restored.Len(testRoomA) != 0 {
+			t.Error(strategy, "restored queue should be empty but had", restored.Len(testRoomA))
+		}
+	}
+}
+
+/*
+ * Finding a song should look at the room's queue and now playing song
+ */
+func TestFindSong_checksQueueAndNowPlaying(t *testing.T) {
+	manager := newTestManager()
+
+	playing := &cmpb.Song{Title: "playing", SongId: 1, UserId: 1, RoomId: testRoomA,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xdeadbeef"}
+	queued := &cmpb.Song{Title: "queued", SongId: 2, UserId: 1, RoomId: testRoomA,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xba5eba11"}
+	manager.AddSong(playing)
+	manager.AddSong(queued)
+	manager.PopQueue(testRoomA)
+
+	if song := manager.FindSong(testRoomA, cmpb.ServiceType_Youtube, "0xdeadbeef"); song != playing {
+		t.Error("Expected to find", playing, "but got", song)
+	}
+
+	if song := manager.FindSong(testRoomA, cmpb.ServiceType_Youtube, "0xba5eba11"); song != queued {
+		t.Error("Expected to find", queued, "but got", song)
+	}
+
+	if song := manager.FindSong(testRoomB, cmpb.ServiceType_Youtube, "0xba5eba11"); song != nil {
+		t.Error("Expected not to find the song in another room but got", song)
+	}
+
+	if song := manager.FindSong(testRoomA, cmpb.ServiceType_Local, "0xba5eba11"); song != nil {
+		t.Error("Expected not to find the song from another service but got", song)
+	}
+}
+
+/*
+ * The admit function should see the copy of the song already in the room and
+ * only let the song in if it returns no reason
+ */
+func TestAddSongIf_passesRepeatToAdmit(t *testing.T) {
+	manager := newTestManager()
+
+	playing := &cmpb.Song{Title: "playing", SongId: 1, UserId: 1, RoomId: testRoomA,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xdeadbeef"}
+	queued := &cmpb.Song{Title: "queued", SongId: 2, UserId: 1, RoomId: testRoomA,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xba5eba11"}
+	manager.AddSong(playing)
+	manager.AddSong(queued)
+	manager.PopQueue(testRoomA)
+
+	reject := func(repeat *cmpb.Song, isPlaying bool) string {
+		if isPlaying {
+			return "playing"
+		} else if repeat != nil {
+			return "queued"
+		}
+		return ""
+	}
+
+	again := proto.Clone(playing).(*cmpb.Song)
+	if message := manager.AddSongIf(again, reject); message != "playing" {
+		t.Error("Expected the playing song to be seen but got", message)
+	}
+
+	again = proto.Clone(queued).(*cmpb.Song)
+	if message := manager.AddSongIf(again, reject); message != "queued" || manager.Len(testRoomA) != 1 {
+		t.Error("Expected the queued song to be seen and nothing added but got", message, manager.Len(testRoomA))
+	}
+
+	again.RoomId = testRoomB
+	if message := manager.AddSongIf(again, reject); message != "" || manager.Len(testRoomB) != 1 {
+		t.Error("Expected the song to be added to another room but got", message, manager.Len(testRoomB))
+	}
+}
+
+/*
+ * A song marked for resuming should be popped again before the rest of the
+ * queue
+ */
+func TestResumeNowPlaying_replaysSong(t *testing.T) {
+	manager := newTestManager()
+
+	songA := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	songB := &cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomA}
+	manager.AddSong(songA)
+	manager.AddSong(songB)
+	manager.PopQueue(testRoomA)
+
+	if !manager.ResumeNowPlaying(testRoomA) {
+		t.Error("Expected the now playing song to be resumed")
+	}
+
+	if popped := manager.PopQueue(testRoomA); popped == nil || compareSongs(popped, songA) == false {
+		t.Error("Expected", songA, "to be played again but got", popped)
+	}
+
+	if popped := manager.PopQueue(testRoomA); popped == nil || compareSongs(popped, songB) == false {
+		t.Error("Expected", songB, "but got", popped)
+	}
+}
+
+/*
+ * Nothing is resumed once the resume is cancelled or nothing is playing
+ */
+func TestResumeNowPlaying_whenCancelled_popsNextSong(t *testing.T) {
+	manager := newTestManager()
+
+	if manager.ResumeNowPlaying(testRoomA) {
+		t.Error("Expected nothing to resume in a room with nothing playing")
+	}
+
+	songA := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	songB := &cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomA}
+	manager.AddSong(songA)
+	manager.AddSong(songB)
+	manager.PopQueue(testRoomA)
+
+	manager.ResumeNowPlaying(testRoomA)
+	manager.CancelResume(testRoomA)
+
+	if popped := manager.PopQueue(testRoomA); popped == nil || compareSongs(popped, songB) == false {
+		t.Error("Expected", songB, "but got", popped)
+	}
+}
+
+/*
+ * A song should only be skipped while it's the one playing
+ */
+func TestSkipSong_whenSongChanged_doesNothing(t *testing.T) {
+	manager := newTestManager()
+
+	first := &cmpb.Song{Title: "first", SongId: 1, UserId: 1, RoomId: testRoomA}
+	second := &cmpb.Song{Title: "second", SongId: 2, UserId: 1, RoomId: testRoomA}
+	manager.AddSong(first)
+	manager.AddSong(second)
+	manager.PopQueue(testRoomA)
+
+	if next, skipped := manager.SkipSong(testRoomA, first.SongId); !skipped || next != second {
+		t.Error("Expected to skip to", second, "but got", next, skipped)
+	}
+
+	if next, skipped := manager.SkipSong(testRoomA, first.SongId); skipped || next != nil {
+		t.Error("Expected a song that isn't playing not to be skipped but got", next, skipped)
+	}
+
+	if manager.NowPlaying(testRoomA) != second {
+		t.Error("Expected the second song to keep playing but got", manager.NowPlaying(testRoomA))
+	}
+}
+
+/*
+ * A store whose snapshot writes block until released
+ */
+type slowStore struct {
+	lock    sync.Mutex
+	played  []uint32
+	saving  chan struct{} // signalled when a snapshot write starts
+	release chan struct{} // closed to let snapshot writes finish
+}
+
+func newSlowStore() *slowStore {
+	store := new(slowStore)
+	store.saving = make(chan struct{}, 1)
+	store.release = make(chan struct{})
+	return store
+}
+
+func (store *slowStore) SaveQueueState(state *bepb.QueueState) error {
+	select {
+	case store.saving <- struct{}{}:
+	default:
+	}
+
+	<-store.release
+	return nil
+}
+
+func (store *slowStore) GetQueueStates() ([]*bepb.QueueState, error) {
+	return nil, nil
+}
+
+func (store *slowStore) MarkSongPlayed(songId uint32) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.played = append(store.played, songId)
+	return nil
+}
+
+/*
+ * A slow write to the store shouldn't hold up the queues of any room
+ */
+func TestSaveSnapshot_whenStoreIsSlow_doesNotHoldUpQueues(t *testing.T) {
+	store := newSlowStore()
+	manager := new(SongQueueManager)
+	manager.Init(RoundRobinStrategy, store)
+
+	manager.AddSong(&cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA})
+	manager.SaveSnapshot(testRoomA)
+	<-store.saving
+
+	finished := make(chan struct{})
+	go func() {
+		manager.AddSong(&cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomB})
+		manager.PopQueue(testRoomB)
+		manager.PopQueue(testRoomA)
+		manager.WaitForMoreSongs(testRoomA, closedChannel())
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queues to carry on while the store is slow")
+	}
+
+	close(store.release)
+	manager.Close()
+
+	if len(store.played) != 2 {
+		t.Error("Expected both played songs to be recorded once closed but got", store.played)
+	}
+}
+
+func closedChannel() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
@@ -1,9 +1,70 @@
 package song_queue
 
 import (
+	"errors"
+	"fmt"
+
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
 
+/*
+ * Name of a queuing strategy that decides the order songs are played in
+ */
+type Strategy string
+
+const (
+	FifoStrategy         Strategy = "fifo"          // songs play in the order they were submitted
+	RoundRobinStrategy   Strategy = "round-robin"   // users take turns one song at a time
+	DurationFairStrategy Strategy = "duration-fair" // users take turns by total play time
+	VotingStrategy       Strategy = "voting"        // round-robin with votes reordering each round
+)
+
+/*
+ * Returns the names of all the queuing strategies
+ */
+func StrategyNames() []string {
+	return []string{
+		string(FifoStrategy),
+		string(RoundRobinStrategy),
+		string(DurationFairStrategy),
+		string(VotingStrategy),
+	}
+}
+
+/*
+ * Returns the strategy with the given name or an error if there isn't one
+ */
+func ParseStrategy(name string) (Strategy, error) {
+	for _, strategy := range StrategyNames() {
+		if name == strategy {
+			return Strategy(name), nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("Unknown queuing strategy: %s", name))
+}
+
+/*
+ * Create a new, empty songQueuer that implements the given strategy. Unknown
+ * strategies fall back to round-robin.
+ */
+func newQueuer(strategy Strategy) songQueuer {
+	switch strategy {
+	case FifoStrategy:
+		return NewFifoQueuer()
+
+	case DurationFairStrategy:
+		return NewDurationFairQueuer()
+
+	case VotingStrategy:
+		return NewVotingQueuer()
+
+	default:
+		return NewRoundRobinQueuer()
+	}
+}
+
 /*
  * A songQueuer maintains a list of songs
  */
@@ -22,6 +83,58 @@ type songQueuer interface {
 
 	// Remove song from the queue
 	remove(songId uint32, userId uint32) error
+
+	// Move a song by offset turns among the songs queued by the same user.
+	// The turns themselves stay where they are.
+	move(songId uint32, userId uint32, offset int) error
+
+	// Save the queued songs along with the ordering data of the queue
+	save(state *bepb.QueueState)
+
+	// Fill an empty queue with the songs and ordering data of a saved queue
+	restore(state *bepb.QueueState)
+}
+
+/*
+ * Returns the user's songs in the order they should fill the user's turns
+ * after moving the song with the given id by offset turns
+ */
+func reorderUserSongs(songs []*cmpb.Song, songId uint32, offset int) ([]*cmpb.Song, error) {
+	index := -1
+	for i, song := range songs {
+		if song.SongId == songId {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		return nil, errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+	}
+
+	target := index + offset
+	if target < 0 || target >= len(songs) {
+		return nil, errors.New(fmt.Sprintf("Song with id %d can't be moved by %d turns", songId, offset))
+	}
+
+	reordered := make([]*cmpb.Song, 0, len(songs))
+	for i, song := range songs {
+		if i != index {
+			reordered = append(reordered, song)
+		}
+	}
+
+	reordered = append(reordered[:target], append([]*cmpb.Song{songs[index]}, reordered[target:]...)...)
+	return reordered, nil
+}
+
+/*
+ * A voter is a songQueuer that reorders its songs by the votes they receive
+ */
+type voter interface {
+	// Set the net votes of a song in the queue. Returns true if the song was
+	// dropped from the queue because of its votes.
+	vote(songId uint32, votes int32) (bool, error)
 }
 
 type queueElement interface {
@@ -0,0 +1,127 @@
+/*
+ * A VotingQueuer lets the votes of users reorder the songs in the queue while
+ * keeping the round-robin rounds intact. Heavily upvoted songs move to the
+ * front of their round and heavily downvoted songs sink to the back of their
+ * round. A song that collects too many downvotes is dropped from the queue.
+ * Songs never move into another round, so every user still gets one song per
+ * round.
+ */
+
+package song_queue
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+const (
+	promoteVotes int32 = 2  // net votes at which a song moves to the front of its round
+	sinkVotes    int32 = -2 // net votes at which a song sinks to the back of its round
+	dropVotes    int32 = -4 // net votes at which a song is dropped from the queue
+)
+
+// Implements sort.Interface for a slice of submissions ordered by votes
+type byVotes []*submission
+
+func (list byVotes) Len() int {
+	return len(list)
+}
+
+// Sort by round, then by the vote standing of the song and then by the earliest
+// submitted song in the round
+func (list byVotes) Less(i, j int) bool {
+	if list[i].round != list[j].round {
+		return list[i].round < list[j].round
+	}
+
+	iStanding, jStanding := voteStanding(list[i].song), voteStanding(list[j].song)
+	if iStanding != jStanding {
+		return iStanding > jStanding
+	}
+
+	return list[i].time.Before(list[j].time)
+}
+
+func (list byVotes) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+type VotingQueuer struct {
+	RoundRobinQueuer // tracks the rounds of the submissions
+}
+
+func NewVotingQueuer() *VotingQueuer {
+	voting := new(VotingQueuer)
+	voting.RoundRobinQueuer = *NewRoundRobinQueuer()
+	return voting
+}
+
+func (voting *VotingQueuer) push(song *cmpb.Song) {
+	voting.RoundRobinQueuer.push(song)
+	sort.Sort(byVotes(voting.queue))
+}
+
+func (voting *VotingQueuer) pop() *cmpb.Song {
+	song := voting.RoundRobinQueuer.pop()
+	sort.Sort(byVotes(voting.queue))
+	return song
+}
+
+func (voting *VotingQueuer) remove(songId uint32, userId uint32) error {
+	err := voting.RoundRobinQueuer.remove(songId, userId)
+	sort.Sort(byVotes(voting.queue))
+	return err
+}
+
+func (voting *VotingQueuer) move(songId uint32, userId uint32, offset int) error {
+	err := voting.RoundRobinQueuer.move(songId, userId, offset)
+	sort.Sort(byVotes(voting.queue))
+	return err
+}
+
+func (voting *VotingQueuer) restore(state *bepb.QueueState) {
+	voting.RoundRobinQueuer.restore(state)
+	sort.Sort(byVotes(voting.queue))
+}
+
+/*
+ * Sets the net votes of the song and moves it within its round. Returns true if
+ * the song had enough downvotes to be dropped from the queue.
+ */
+func (voting *VotingQueuer) vote(songId uint32, votes int32) (bool, error) {
+	for i, sub := range voting.queue {
+		if sub.song.SongId == songId {
+			sub.song.Votes = votes
+
+			// unlike a song its user removed, the room voted this one off, so
+			// the user doesn't get their round back
+			if votes <= dropVotes {
+				voting.queue = append(voting.queue[:i], voting.queue[i+1:]...)
+				return true, nil
+			}
+
+			sort.Sort(byVotes(voting.queue))
+			return false, nil
+		}
+	}
+
+	return false, errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+}
+
+/*
+ * Returns 1 for a heavily upvoted song, -1 for a heavily downvoted song and 0
+ * for every other song
+ */
+func voteStanding(song *cmpb.Song) int {
+	if song.Votes >= promoteVotes {
+		return 1
+	} else if song.Votes <= sinkVotes {
+		return -1
+	}
+
+	return 0
+}
@@ -0,0 +1,137 @@
+package song_queue
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+/*
+ * Returns a fresh voting queue holding two rounds of songs from three users.
+ * Songs 1, 2 and 3 are in the first round and songs 4 and 5 in the second.
+ */
+func newVotedQueue() *VotingQueuer {
+	songs := []*cmpb.Song{
+		{Title: "title 1", SongId: 1, Username: "Kid A", UserId: 1},
+		{Title: "title 2", SongId: 2, Username: "Kid B", UserId: 2},
+		{Title: "title 3", SongId: 3, Username: "Kid C", UserId: 3},
+		{Title: "title 4", SongId: 4, Username: "Kid A", UserId: 1},
+		{Title: "title 5", SongId: 5, Username: "Kid B", UserId: 2},
+	}
+
+	queuer := NewVotingQueuer()
+	for _, song := range songs {
+		queuer.push(song)
+	}
+
+	return queuer
+}
+
+func expectVotedOrder(t *testing.T, queuer *VotingQueuer, expectedIds []uint32) {
+	for _, expectedId := range expectedIds {
+		actualSong := queuer.pop()
+
+		if actualSong == nil || actualSong.SongId != expectedId {
+			t.Error("Expected song", expectedId, "but got", actualSong)
+		}
+	}
+
+	if queuer.length() != 0 {
+		t.Error("Did not pop off all songs from queue. Length:", queuer.length())
+	}
+}
+
+func TestVote_whenHeavilyUpvoted_movesToFrontOfRound(t *testing.T) {
+	queuer := newVotedQueue()
+
+	if _, err := queuer.vote(3, promoteVotes); err != nil {
+		t.Fatal("Failed to vote on song:", err)
+	}
+
+	// a popular song in the second round must still wait for the first round
+	if _, err := queuer.vote(5, promoteVotes+3); err != nil {
+		t.Fatal("Failed to vote on song:", err)
+	}
+
+	expectVotedOrder(t, queuer, []uint32{3, 1, 2, 5, 4})
+}
+
+func TestVote_whenHeavilyDownvoted_sinksToBackOfRound(t *testing.T) {
+	queuer := newVotedQueue()
+
+	if _, err := queuer.vote(1, sinkVotes); err != nil {
+		t.Fatal("Failed to vote on song:", err)
+	}
+
+	// a single upvote isn't enough to move a song
+	if _, err := queuer.vote(5, 1); err != nil {
+		t.Fatal("Failed to vote on song:", err)
+	}
+
+	expectVotedOrder(t, queuer, []uint32{2, 3, 1, 4, 5})
+}
+
+func TestVote_whenTooManyDownvotes_dropsSong(t *testing.T) {
+	queuer := newVotedQueue()
+
+	dropped, err := queuer.vote(2, dropVotes)
+	if err != nil {
+		t.Fatal("Failed to vote on song:", err)
+	}
+
+	if !dropped {
+		t.Error("Expected song 2 to be dropped")
+	}
+
+	expectVotedOrder(t, queuer, []uint32{1, 3, 4, 5})
+}
+
+/*
+ * A user whose song was voted off doesn't get its round back, so their next
+ * song waits for the round after
+ */
+func TestVote_whenDropped_keepsUsersRound(t *testing.T) {
+	queuer := newVotedQueue()
+
+	if _, err := queuer.vote(4, dropVotes); err != nil {
+		t.Fatal("Failed to vote on song:", err)
+	}
+
+	queuer.push(&cmpb.Song{Title: "title 6", SongId: 6, Username: "Kid A", UserId: 1})
+	queuer.push(&cmpb.Song{Title: "title 7", SongId: 7, Username: "Kid C", UserId: 3})
+
+	expectVotedOrder(t, queuer, []uint32{1, 2, 3, 5, 7, 6})
+}
+
+func TestVote_whenSongMissing_fails(t *testing.T) {
+	queuer := newVotedQueue()
+
+	if _, err := queuer.vote(42, 1); err == nil {
+		t.Error("Expected an error when voting on a song that isn't queued")
+	}
+}
+
+/*
+ * Queues that don't use votes should still keep track of a song's votes
+ */
+func TestVoteSong_whenStrategyIgnoresVotes_keepsOrder(t *testing.T) {
+	manager := newTestManager()
+
+	first := &cmpb.Song{Title: "title A", SongId: 1, UserId: 1, RoomId: testRoomA}
+	second := &cmpb.Song{Title: "title B", SongId: 2, UserId: 2, RoomId: testRoomA}
+	manager.AddSong(first)
+	manager.AddSong(second)
+
+	dropped, err := manager.VoteSong(testRoomA, second.SongId, 10)
+	if err != nil || dropped {
+		t.Fatal("Expected vote to be applied without dropping song:", err)
+	}
+
+	if song := manager.GetSong(testRoomA, second.SongId); song == nil || song.Votes != 10 {
+		t.Error("Expected song with 10 votes but got", song)
+	}
+
+	if song := manager.PopQueue(testRoomA); song != first {
+		t.Error("Expected", first, "but got", song)
+	}
+}
@@ -7,11 +7,13 @@ package backend
 import (
 	"log"
 	"sync"
+	"time"
 )
 
 type UserEntry struct {
-	name   string
-	roomId uint32
+	name     string
+	roomId   uint32
+	lastSeen time.Time // time of the user's last request
 }
 
 type UserCache struct {
@@ -71,5 +73,34 @@ func (c *UserCache) AddUserToCache(userId uint32, username string, roomId uint32
 		log.Printf("Cached: {user id: %d, username: %s}", userId, username)
 	}
 
-	c.cache[userId] = &UserEntry{username, roomId}
+	c.cache[userId] = &UserEntry{username, roomId, time.Now()}
+}
+
+/*
+ * Marks the user as active as of now
+ */
+func (c *UserCache) Touch(userId uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry, exists := c.cache[userId]; exists {
+		entry.lastSeen = time.Now()
+	}
+}
+
+/*
+ * Counts the users in the room who have been seen since the given time
+ */
+func (c *UserCache) ActiveUsers(roomId uint32, since time.Time) int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	count := 0
+	for _, entry := range c.cache {
+		if entry.roomId == roomId && !entry.lastSeen.Before(since) {
+			count++
+		}
+	}
+
+	return count
 }
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"testing"
+	"time"
 )
 
 const (
@@ -45,3 +46,19 @@ func TestLookupRoomId_when_success(t *testing.T) {
 		t.Fatalf("Cache should return %d, but was %d", testRoomId, uint32(testRoomId))
 	}
 }
+
+func TestActiveUsers_countsRecentUsersInRoom(t *testing.T) {
+	cache := setup()
+	start := time.Now()
+	cache.AddUserToCache(testUserId, testUserName, testRoomId)
+	cache.AddUserToCache(testUserId+1, "Richard", testRoomId)
+	cache.AddUserToCache(testUserId+2, "Zedd", testRoomId+1)
+
+	if active := cache.ActiveUsers(testRoomId, start); active != 2 {
+		t.Fatalf("Cache should have 2 active users in room, but had %d", active)
+	}
+
+	if active := cache.ActiveUsers(testRoomId, time.Now().Add(time.Minute)); active != 0 {
+		t.Fatalf("Cache should have no users active in the future, but had %d", active)
+	}
+}
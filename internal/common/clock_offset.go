@@ -0,0 +1,76 @@
+// Estimates the offset between a local clock and a remote clock
+
+package common
+
+import (
+	"time"
+)
+
+// number of recent samples that the offset is picked from
+const clockSamples = 8
+
+/*
+ * A single round trip to the remote clock
+ */
+type clockSample struct {
+	offset time.Duration // remote clock minus local clock
+	rtt    time.Duration // round trip time of the exchange
+}
+
+/*
+ * Estimates how far a remote clock is ahead of the local clock from round trips
+ * to the remote. The remote's time is assumed to have been read halfway
+ * through each round trip, so the round trip with the least delay gives the
+ * best estimate.
+ */
+type ClockOffset struct {
+	samples []clockSample
+	next    int
+}
+
+/*
+ * Adds a round trip that was sent at the local time sent, read by the remote
+ * at remote and answered at the local time received
+ */
+func (clock *ClockOffset) AddSample(sent time.Time, remote time.Time, received time.Time) {
+	rtt := received.Sub(sent)
+	if rtt < 0 {
+		return
+	}
+
+	sample := clockSample{offset: remote.Sub(sent.Add(rtt / 2)), rtt: rtt}
+	if len(clock.samples) < clockSamples {
+		clock.samples = append(clock.samples, sample)
+	} else {
+		clock.samples[clock.next] = sample
+	}
+	clock.next = (clock.next + 1) % clockSamples
+}
+
+/*
+ * Returns how far the remote clock is ahead of the local clock. Returns false
+ * if there are no samples yet.
+ */
+func (clock *ClockOffset) Offset() (time.Duration, bool) {
+	if len(clock.samples) == 0 {
+		return 0, false
+	}
+
+	best := clock.samples[0]
+	for _, sample := range clock.samples[1:] {
+		if sample.rtt < best.rtt {
+			best = sample
+		}
+	}
+
+	return best.offset, true
+}
+
+/*
+ * Converts a time on the remote clock into local time. The time is left as is
+ * while the offset isn't known.
+ */
+func (clock *ClockOffset) LocalTime(remote time.Time) time.Time {
+	offset, _ := clock.Offset()
+	return remote.Add(-offset)
+}
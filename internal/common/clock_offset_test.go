@@ -0,0 +1,58 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOffset_whenNoSamples_returnsFalse(t *testing.T) {
+	clock := new(ClockOffset)
+
+	if _, ok := clock.Offset(); ok {
+		t.Error("Expected no offset without samples")
+	}
+}
+
+func TestOffset_usesHalfOfRoundTrip(t *testing.T) {
+	clock := new(ClockOffset)
+	sent := time.Unix(1000, 0)
+
+	// remote is 5s ahead and the trip took 200ms each way
+	clock.AddSample(sent, sent.Add(5*time.Second+200*time.Millisecond), sent.Add(400*time.Millisecond))
+
+	offset, ok := clock.Offset()
+	if !ok || offset != 5*time.Second {
+		t.Error("Expected an offset of 5s but got", offset)
+	}
+
+	if local := clock.LocalTime(sent.Add(10 * time.Second)); !local.Equal(sent.Add(5 * time.Second)) {
+		t.Error("Expected remote time to be converted to", sent.Add(5*time.Second), "but got", local)
+	}
+}
+
+func TestOffset_prefersFastestRoundTrip(t *testing.T) {
+	clock := new(ClockOffset)
+	sent := time.Unix(1000, 0)
+
+	// a slow trip where the reply was held up on the way back
+	clock.AddSample(sent, sent.Add(2*time.Second+10*time.Millisecond), sent.Add(900*time.Millisecond))
+	clock.AddSample(sent, sent.Add(2*time.Second+10*time.Millisecond), sent.Add(20*time.Millisecond))
+
+	if offset, _ := clock.Offset(); offset != 2*time.Second {
+		t.Error("Expected an offset of 2s but got", offset)
+	}
+}
+
+func TestOffset_forgetsOldSamples(t *testing.T) {
+	clock := new(ClockOffset)
+	sent := time.Unix(1000, 0)
+
+	clock.AddSample(sent, sent.Add(time.Second), sent)
+	for i := 0; i < clockSamples; i++ {
+		clock.AddSample(sent, sent.Add(3*time.Second+5*time.Millisecond), sent.Add(10*time.Millisecond))
+	}
+
+	if offset, _ := clock.Offset(); offset != 3*time.Second {
+		t.Error("Expected the oldest sample to be forgotten but got offset", offset)
+	}
+}
@@ -0,0 +1,420 @@
+// Reads the play length out of audio files
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrUnknownDuration = errors.New("Could not read the duration of the audio file")
+
+const (
+	oggPageHeader = 27        // length of an ogg page header up to its segment table
+	oggTailLength = 64 * 1024 // how much of the end of an ogg file to search for its last page
+	mp3SyncSearch = 64 * 1024 // how far past the ID3 tag to look for the first mp3 frame
+)
+
+// bit rates of MPEG layer III frames in kbps for MPEG-1 and MPEG-2/2.5
+var mp3BitRates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+// sample rates of MPEG frames for MPEG-1, MPEG-2 and MPEG-2.5
+var mp3SampleRates = [3][3]int{
+	{44100, 48000, 32000},
+	{22050, 24000, 16000},
+	{11025, 12000, 8000},
+}
+
+/*
+ * Returns the play length of an mp3, flac, ogg or m4a file.
+ * ErrUnknownDuration is returned if the file is in some other format or its
+ * length can't be worked out.
+ */
+func ReadDuration(file io.ReadSeeker) (time.Duration, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	start, err := skipId3v2(file)
+	if err != nil {
+		return 0, err
+	}
+
+	magic := make([]byte, 8)
+	if _, err = io.ReadFull(file, magic); err != nil {
+		return 0, ErrUnknownDuration
+	}
+
+	switch {
+	case bytes.Equal(magic[0:4], []byte("fLaC")):
+		if _, err = file.Seek(start+4, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return readFlacDuration(file)
+
+	case bytes.Equal(magic[0:4], []byte("OggS")):
+		return readOggDuration(file, start, size)
+
+	case bytes.Equal(magic[4:8], []byte("ftyp")):
+		return readMp4Duration(file, start, size)
+	}
+
+	return readMp3Duration(file, start, size)
+}
+
+/*
+ * Moves the file past the ID3v2 tag at the start of the file if there is one.
+ * Returns the offset of the audio data.
+ */
+func skipId3v2(file io.ReadSeeker) (int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.Equal(header[0:3], []byte("ID3")) {
+		_, err = file.Seek(0, io.SeekStart)
+		return 0, err
+	}
+
+	// the tag size is a syncsafe integer that leaves out the header and footer
+	start := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	start += 10
+	if header[5]&0x10 != 0 {
+		start += 10
+	}
+
+	_, err := file.Seek(start, io.SeekStart)
+	return start, err
+}
+
+/*
+ * Reads the duration out of the STREAMINFO block that follows the fLaC marker
+ */
+func readFlacDuration(file io.Reader) (time.Duration, error) {
+	block := make([]byte, 4+34)
+	if _, err := io.ReadFull(file, block); err != nil || block[0]&0x7f != 0 {
+		return 0, ErrUnknownDuration
+	}
+
+	info := block[4:]
+	sampleRate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	samples := uint64(info[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || samples == 0 {
+		return 0, ErrUnknownDuration
+	}
+
+	return time.Duration(samples * uint64(time.Second) / sampleRate), nil
+}
+
+/*
+ * Works out the duration of an mp3 from its first frame. VBR files carry the
+ * number of frames in a Xing or VBRI header, otherwise the file is assumed to
+ * have a constant bit rate.
+ */
+func readMp3Duration(file io.ReadSeeker, start int64, size int64) (time.Duration, error) {
+	start, err := findMp3Frame(file, start, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	frame := make([]byte, 4+32+4+12)
+	if _, err := io.ReadFull(file, frame); err != nil {
+		return 0, ErrUnknownDuration
+	}
+
+	version := (frame[1] >> 3) & 0x03
+	mono := frame[3]>>6 == 0x03
+	sampleRate, bitRate, samplesPerFrame := mp3FrameRates(frame)
+
+	var sideInfo int
+	switch version {
+	case 0x03: // MPEG-1
+		sideInfo = 32
+		if mono {
+			sideInfo = 17
+		}
+	default: // MPEG-2 and MPEG-2.5
+		sideInfo = 17
+		if mono {
+			sideInfo = 9
+		}
+	}
+
+	xing := frame[4+sideInfo:]
+	if bytes.Equal(xing[0:4], []byte("Xing")) || bytes.Equal(xing[0:4], []byte("Info")) {
+		if binary.BigEndian.Uint32(xing[4:8])&0x01 != 0 {
+			frames := binary.BigEndian.Uint32(xing[8:12])
+			return time.Duration(int64(frames) * int64(samplesPerFrame) * int64(time.Second) / int64(sampleRate)), nil
+		}
+	}
+
+	vbri := make([]byte, 18)
+	if _, err := file.Seek(start+4+32, io.SeekStart); err == nil {
+		if _, err = io.ReadFull(file, vbri); err == nil && bytes.Equal(vbri[0:4], []byte("VBRI")) {
+			frames := binary.BigEndian.Uint32(vbri[14:18])
+			return time.Duration(int64(frames) * int64(samplesPerFrame) * int64(time.Second) / int64(sampleRate)), nil
+		}
+	}
+
+	if bitRate == 0 {
+		return 0, ErrUnknownDuration
+	}
+
+	return time.Duration((size - start) * 8 * int64(time.Second) / int64(bitRate*1000)), nil
+}
+
+/*
+ * Works out the duration of an ogg vorbis or opus file from the granule
+ * position of its last page and the sample rate in its first page
+ */
+func readOggDuration(file io.ReadSeeker, start int64, size int64) (time.Duration, error) {
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	// the first page holds the identification header of the stream
+	first := make([]byte, oggPageHeader+255+19)
+	n, _ := io.ReadFull(file, first)
+	first = first[:n]
+	if len(first) < oggPageHeader {
+		return 0, ErrUnknownDuration
+	}
+
+	// the packet follows the segment table, which a truncated file may not have
+	packetStart := oggPageHeader + int(first[26])
+	if packetStart > len(first) {
+		return 0, ErrUnknownDuration
+	}
+
+	serial := binary.LittleEndian.Uint32(first[14:18])
+	packet := first[packetStart:]
+
+	var sampleRate, preSkip uint64
+	switch {
+	case len(packet) >= 16 && bytes.Equal(packet[0:7], []byte("\x01vorbis")):
+		sampleRate = uint64(binary.LittleEndian.Uint32(packet[12:16]))
+	case len(packet) >= 12 && bytes.Equal(packet[0:8], []byte("OpusHead")):
+		sampleRate = 48000 // opus granule positions always count 48 kHz samples
+		preSkip = uint64(binary.LittleEndian.Uint16(packet[10:12]))
+	}
+
+	if sampleRate == 0 {
+		return 0, ErrUnknownDuration
+	}
+
+	// the last page of the stream carries the total number of samples
+	tailStart := size - oggTailLength
+	if tailStart < start {
+		tailStart = start
+	}
+
+	if _, err := file.Seek(tailStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	tail := make([]byte, size-tailStart)
+	if _, err := io.ReadFull(file, tail); err != nil {
+		return 0, ErrUnknownDuration
+	}
+
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if len(tail)-i < oggPageHeader || binary.LittleEndian.Uint32(tail[i+14:i+18]) != serial {
+			continue
+		}
+
+		granule := binary.LittleEndian.Uint64(tail[i+6 : i+14])
+		if granule == ^uint64(0) {
+			continue
+		}
+
+		if granule <= preSkip {
+			return 0, ErrUnknownDuration
+		}
+
+		return time.Duration((granule - preSkip) * uint64(time.Second) / sampleRate), nil
+	}
+
+	return 0, ErrUnknownDuration
+}
+
+/*
+ * Reads the duration out of the movie header of an mp4 file, which sits in the
+ * moov box at the top level of the file
+ */
+func readMp4Duration(file io.ReadSeeker, start int64, size int64) (time.Duration, error) {
+	moov, moovSize, err := findMp4Box(file, start, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+
+	mvhd, _, err := findMp4Box(file, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = file.Seek(mvhd, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 4+8+8+4+8)
+	if _, err = io.ReadFull(file, header[:4]); err != nil {
+		return 0, ErrUnknownDuration
+	}
+
+	var timeScale, length uint64
+	if header[0] == 1 {
+		if _, err = io.ReadFull(file, header[4:32]); err != nil {
+			return 0, ErrUnknownDuration
+		}
+		timeScale = uint64(binary.BigEndian.Uint32(header[20:24]))
+		length = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		if _, err = io.ReadFull(file, header[4:20]); err != nil {
+			return 0, ErrUnknownDuration
+		}
+		timeScale = uint64(binary.BigEndian.Uint32(header[12:16]))
+		length = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+
+	if timeScale == 0 || length == 0 {
+		return 0, ErrUnknownDuration
+	}
+
+	return time.Duration(length * uint64(time.Second) / timeScale), nil
+}
+
+/*
+ * Looks through the mp4 boxes between the two offsets for one of the given
+ * type. Returns the offset of the box's contents and their size.
+ */
+func findMp4Box(file io.ReadSeeker, offset int64, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset+8 <= end {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			return 0, 0, ErrUnknownDuration
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0: // the box runs to the end
+			boxSize = end - offset
+		case 1: // the size doesn't fit in 32 bits and follows the type
+			if _, err := io.ReadFull(file, header[8:16]); err != nil {
+				return 0, 0, ErrUnknownDuration
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+
+		// a box must fit in the space it was found in, which a truncated
+		// file may not have
+		if boxSize < headerSize || boxSize > end-offset {
+			return 0, 0, ErrUnknownDuration
+		}
+
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, boxSize - headerSize, nil
+		}
+
+		offset += boxSize
+	}
+
+	return 0, 0, ErrUnknownDuration
+}
+
+/*
+ * Returns the offset of the first MPEG layer III frame at or after the start.
+ * Some files have padding or junk between the ID3 tag and the first frame.
+ * Junk can look like a frame header, so a header found past the start only
+ * counts if the frame after it starts with a header too.
+ */
+func findMp3Frame(file io.ReadSeeker, start int64, size int64) (int64, error) {
+	length := size - start
+	if length > mp3SyncSearch {
+		length = mp3SyncSearch
+	}
+
+	if length < 4 {
+		return 0, ErrUnknownDuration
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	buffer := make([]byte, length)
+	if _, err := io.ReadFull(file, buffer); err != nil {
+		return 0, ErrUnknownDuration
+	}
+
+	for i := 0; i+4 <= len(buffer); i++ {
+		if !isMp3FrameHeader(buffer[i:]) {
+			continue
+		}
+
+		next := i + mp3FrameLength(buffer[i:])
+		if i == 0 || next == i || next+4 > len(buffer) || isMp3FrameHeader(buffer[next:]) {
+			return start + int64(i), nil
+		}
+	}
+
+	return 0, ErrUnknownDuration
+}
+
+/*
+ * Returns true if the bytes start with a valid MPEG layer III frame header
+ */
+func isMp3FrameHeader(header []byte) bool {
+	if len(header) < 4 || header[0] != 0xff || header[1]&0xe0 != 0xe0 || (header[1]>>1)&0x03 != 0x01 {
+		return false
+	}
+
+	version := (header[1] >> 3) & 0x03
+	bitRateIndex := header[2] >> 4
+	sampleRateIndex := (header[2] >> 2) & 0x03
+	return version != 0x01 && bitRateIndex != 0x0f && sampleRateIndex != 0x03
+}
+
+/*
+ * Returns the sample rate, bit rate in kbps and samples per frame of a valid
+ * frame header. The bit rate is zero for free format frames.
+ */
+func mp3FrameRates(header []byte) (int, int, int) {
+	version := (header[1] >> 3) & 0x03
+	bitRateIndex := header[2] >> 4
+	sampleRateIndex := (header[2] >> 2) & 0x03
+
+	if version == 0x03 { // MPEG-1
+		return mp3SampleRates[0][sampleRateIndex], mp3BitRates[0][bitRateIndex], 1152
+	}
+
+	// MPEG-2 and MPEG-2.5
+	return mp3SampleRates[2-version/2][sampleRateIndex], mp3BitRates[1][bitRateIndex], 576
+}
+
+/*
+ * Returns the length in bytes of the frame with the given valid header, or
+ * zero for free format frames whose length isn't in the header
+ */
+func mp3FrameLength(header []byte) int {
+	sampleRate, bitRate, samplesPerFrame := mp3FrameRates(header)
+	padding := int(header[2]>>1) & 0x01
+	return samplesPerFrame/8*bitRate*1000/sampleRate + padding
+}
@@ -0,0 +1,233 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+/*
+ * Builds a flac file with a STREAMINFO block for the given samples
+ */
+func newTestFlac(sampleRate uint32, samples uint64) []byte {
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate<<4) | 0x02 // stereo
+	info[13] = 0xf0 | byte(samples>>32)   // 16 bits per sample
+	binary.BigEndian.PutUint32(info[14:18], uint32(samples))
+
+	file := []byte("fLaC")
+	file = append(file, 0x80, 0, 0, 34)
+	return append(file, info...)
+}
+
+/*
+ * Builds an MPEG-1 layer III file of 128 kbps, 44.1 kHz stereo frames. The
+ * first frame carries a Xing header when frames is greater than zero.
+ */
+func newTestMp3(size int, frames uint32) []byte {
+	file := make([]byte, size)
+	copy(file, []byte{0xff, 0xfb, 0x90, 0x00})
+
+	if frames > 0 {
+		copy(file[4+32:], []byte("Xing"))
+		binary.BigEndian.PutUint32(file[4+32+4:], 0x01)
+		binary.BigEndian.PutUint32(file[4+32+8:], frames)
+	}
+
+	return file
+}
+
+/*
+ * Builds an ogg page of the given stream holding a single packet
+ */
+func newTestOggPage(serial uint32, granule uint64, packet []byte) []byte {
+	page := make([]byte, 27)
+	copy(page, []byte("OggS"))
+	binary.LittleEndian.PutUint64(page[6:14], granule)
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	page[26] = 1
+	page = append(page, byte(len(packet)))
+	return append(page, packet...)
+}
+
+/*
+ * Builds an ogg file with an identification page, a page of audio and a last
+ * page that ends at the given granule position
+ */
+func newTestOgg(header []byte, granule uint64) []byte {
+	file := newTestOggPage(7, 0, header)
+	file = append(file, newTestOggPage(7, granule/2, make([]byte, 200))...)
+	return append(file, newTestOggPage(7, granule, make([]byte, 100))...)
+}
+
+/*
+ * Builds an mp4 file whose movie header has the given time scale and duration
+ */
+func newTestMp4(version byte, timeScale uint32, length uint64) []byte {
+	var mvhd []byte
+	if version == 1 {
+		mvhd = make([]byte, 4+16+4+8)
+		binary.BigEndian.PutUint32(mvhd[20:24], timeScale)
+		binary.BigEndian.PutUint64(mvhd[24:32], length)
+	} else {
+		mvhd = make([]byte, 4+8+4+4)
+		binary.BigEndian.PutUint32(mvhd[12:16], timeScale)
+		binary.BigEndian.PutUint32(mvhd[16:20], uint32(length))
+	}
+	mvhd[0] = version
+
+	box := func(boxType string, contents []byte) []byte {
+		header := make([]byte, 4, 8+len(contents))
+		binary.BigEndian.PutUint32(header, uint32(8+len(contents)))
+		return append(append(header, boxType...), contents...)
+	}
+
+	file := box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	file = append(file, box("mdat", make([]byte, 500))...)
+	return append(file, box("moov", append(box("trak", nil), box("mvhd", mvhd)...))...)
+}
+
+func TestReadDuration_whenFlac_usesStreamInfo(t *testing.T) {
+	file := newTestFlac(44100, 44100*90)
+
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration != 90*time.Second {
+		t.Error("Expected 1m30s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenConstantBitRateMp3_usesFileSize(t *testing.T) {
+	// 128 kbps is 16000 bytes a second
+	file := newTestMp3(16000*60, 0)
+
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration != time.Minute {
+		t.Error("Expected 1m0s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenXingMp3_usesFrameCount(t *testing.T) {
+	// each frame holds 1152 samples
+	file := newTestMp3(1024, 44100*4/1152*30)
+
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration.Round(time.Second) != 2*time.Minute {
+		t.Error("Expected 2m0s but got", duration, err)
+	}
+}
+
+func TestReadDuration_skipsId3Tag(t *testing.T) {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 10}
+	tag = append(tag, make([]byte, 10)...)
+	file := append(tag, newTestFlac(48000, 48000*5)...)
+
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration != 5*time.Second {
+		t.Error("Expected 5s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenMp3HasLeadingPadding_findsFirstFrame(t *testing.T) {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 10}
+	tag = append(tag, make([]byte, 10)...)
+
+	// junk that looks like a frame header but isn't followed by a frame
+	padding := make([]byte, 1000)
+	copy(padding[10:], []byte{0xff, 0xfb, 0x90, 0x00})
+
+	// 128 kbps frames at 44.1 kHz are 417 bytes long
+	frames := make([]byte, 16000*10)
+	for i := 0; i+4 <= len(frames); i += 417 {
+		copy(frames[i:], []byte{0xff, 0xfb, 0x90, 0x00})
+	}
+
+	file := append(append(tag, padding...), frames...)
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration != 10*time.Second {
+		t.Error("Expected 10s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenUnknownFormat_fails(t *testing.T) {
+	if _, err := ReadDuration(bytes.NewReader([]byte("not a song at all"))); err == nil {
+		t.Error("Expected an error for a file that isn't audio")
+	}
+}
+
+func TestReadDuration_whenOggVorbis_usesLastGranule(t *testing.T) {
+	header := append([]byte("\x01vorbis"), 0, 0, 0, 0, 2, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(header[12:16], 44100)
+	file := newTestOgg(header, 44100*200)
+
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration != 200*time.Second {
+		t.Error("Expected 3m20s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenOggOpus_leavesOutPreSkip(t *testing.T) {
+	header := append([]byte("OpusHead"), 1, 2, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(header[10:12], 312)
+	binary.LittleEndian.PutUint32(header[12:16], 44100)
+	file := newTestOgg(header, 48000*45+312)
+
+	duration, err := ReadDuration(bytes.NewReader(file))
+	if err != nil || duration != 45*time.Second {
+		t.Error("Expected 45s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenM4a_usesMovieHeader(t *testing.T) {
+	duration, err := ReadDuration(bytes.NewReader(newTestMp4(0, 1000, 252500)))
+	if err != nil || duration != 252500*time.Millisecond {
+		t.Error("Expected 4m12.5s but got", duration, err)
+	}
+
+	duration, err = ReadDuration(bytes.NewReader(newTestMp4(1, 44100, 44100*61)))
+	if err != nil || duration != 61*time.Second {
+		t.Error("Expected 1m1s but got", duration, err)
+	}
+}
+
+func TestReadDuration_whenOggTruncated_fails(t *testing.T) {
+	// the segment table claims more segments than the file holds
+	file := make([]byte, 40)
+	copy(file, []byte("OggS"))
+	file[26] = 200
+
+	if _, err := ReadDuration(bytes.NewReader(file)); err == nil {
+		t.Error("Expected an error for a truncated ogg file")
+	}
+
+	header := append([]byte("\x01vorbis"), 0, 0, 0, 0, 2, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(header[12:16], 44100)
+	whole := newTestOgg(header, 44100*200)
+	firstPage := 27 + 1 + len(header)
+	for size := 4; size < len(whole); size++ {
+		// once a later page is whole the file has a shorter length instead
+		_, err := ReadDuration(bytes.NewReader(whole[:size]))
+		if err == nil && size < firstPage+27 {
+			t.Error("Expected an error for an ogg file cut to", size, "bytes")
+		}
+	}
+}
+
+func TestReadDuration_whenM4aTruncated_fails(t *testing.T) {
+	whole := newTestMp4(1, 44100, 44100*61)
+	for size := 8; size < len(whole); size++ {
+		if _, err := ReadDuration(bytes.NewReader(whole[:size])); err == nil {
+			t.Error("Expected an error for an m4a file cut to", size, "bytes")
+		}
+	}
+
+	// a box claiming to be larger than the file
+	file := append([]byte{0, 0, 0, 16}, []byte("ftypM4A ")...)
+	file = append(file, 0xff, 0xff, 0xff, 0xff)
+	file = append(file, []byte("moov")...)
+	if _, err := ReadDuration(bytes.NewReader(file)); err == nil {
+		t.Error("Expected an error for an m4a box larger than the file")
+	}
+}
@@ -30,11 +30,21 @@ type DbManager interface {
 	// Add a new song to the database
 	AddSong(song *cmpb.Song) error
 
+	// Get the last time a song started playing in a room
+	GetLastPlayDate(roomId uint32, service cmpb.ServiceType, serviceId string) (time.Time, error)
+
+	// Record that a song started playing
+	MarkSongPlayed(songId uint32) error
+
+	// Remove a song that was added but never queued
+	RemoveSong(songId uint32) error
+
 	// Add a new user to the users table and returns the user's id
 	AddUser(username string, roomId uint32) (*UserData, error)
 
-	// Add a new room to the database
-	AddRoom(roomName string) (*RoomData, error)
+	// Add a new room with the given queuing strategy and longest song allowed
+	// in seconds to the database
+	AddRoom(roomName string, strategy string, maxDuration uint32) (*RoomData, error)
 
 	// Close the database connection
 	Close()
@@ -48,6 +58,58 @@ type DbManager interface {
 	// Queries for a room given its name
 	GetRoomByName(roomName string) (*RoomData, error)
 
+	// Queries for a room given its id
+	GetRoomById(roomId uint32) (*RoomData, error)
+
+	// Queries for all the rooms
+	GetRooms() ([]*RoomData, error)
+
+	// Updates the queuing strategy of the given room
+	UpdateRoomStrategy(roomId uint32, strategy string) error
+
+	// Updates the longest song in seconds allowed in the given room
+	UpdateRoomMaxDuration(roomId uint32, maxDuration uint32) error
+
+	// Updates the user who controls the players of the given room
+	UpdateRoomAdmin(roomId uint32, userId uint32) error
+
+	// Makes the user the admin of the room if the room has no admin yet
+	ClaimRoomAdmin(roomId uint32, userId uint32) (bool, error)
+
+	// Record a user's vote on a song, replacing their earlier vote
+	AddVote(songId uint32, userId uint32, value int32) error
+
+	// Get the net votes of a song
+	GetVotes(songId uint32) (int32, error)
+
+	// Save the state of a room's queue, replacing its earlier state
+	SaveQueueState(state *bepb.QueueState) error
+
+	// Get the saved state of every room's queue
+	GetQueueStates() ([]*bepb.QueueState, error)
+
+	// Mark a song as one that the players could not play
+	MarkSongFailed(songId uint32, reason string) error
+
+	// Get the failed songs of a user that the user hasn't been told about
+	PopFailedSongs(userId uint32) ([]*bepb.FailedSong, error)
+
+	// Get the modification time of every file in the music library by path
+	GetLibraryFiles() (map[string]int64, error)
+
+	// Add a file to the music library, replacing the track of the same path
+	SaveLibraryTrack(track *bepb.LibraryTrack, modTime int64) error
+
+	// Remove the files with the given paths from the music library
+	RemoveLibraryTracks(paths []string) error
+
+	// List the artists of the music library, the albums of an artist or the
+	// tracks of an album
+	BrowseLibrary(artist string, album string) (*bepb.LibraryListing, error)
+
+	// Find the library tracks matching every word of the text
+	SearchLibrary(text string, limit int) ([]*bepb.LibraryTrack, error)
+
 	// Initialize the database interface
 	Init(dbPath string) error
 }
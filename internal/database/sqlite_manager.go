@@ -6,11 +6,17 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	_ "github.com/mattn/go-sqlite3"
+
 	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
@@ -47,12 +53,18 @@ const (
 	enableForeignKeySupport = `
 		PRAGMA foreign_keys = ON;`
 
+	querySchemaVersion = `
+		PRAGMA user_version;`
+
+	updateSchemaVersion = `
+		PRAGMA user_version = %d;`
+
 	insertRoom = `
-		INSERT INTO rooms VALUES
-		(NULL, ?, datetime('now'), datetime('now'));`
+		INSERT INTO rooms (room_id, room_name, create_date, last_access, strategy, max_duration) VALUES
+		(NULL, ?, datetime('now'), datetime('now'), ?, ?);`
 
 	insertSong = `
-		INSERT INTO songs VALUES
+		INSERT INTO songs (id, title, service, service_id, date, user_id, room_id) VALUES
 		(NULL, ?, ?, ?, datetime('now'), ?, ?);`
 
 	insertUser = `
@@ -62,14 +74,212 @@ const (
 	queryUserById = `
 		SELECT * FROM users WHERE user_id = ?;`
 
+	queryLastPlayDate = `
+		SELECT played_date FROM songs
+		WHERE room_id = ? AND service = ? AND service_id = ? AND played_date IS NOT NULL
+		ORDER BY played_date DESC LIMIT 1;`
+
 	queryRoomByName = `
-		SELECT * FROM rooms where room_name = ?;`
+		SELECT room_id, room_name, create_date, last_access, strategy, max_duration, admin_id
+		FROM rooms where room_name = ?;`
+
+	queryRoomById = `
+		SELECT room_id, room_name, create_date, last_access, strategy, max_duration, admin_id
+		FROM rooms where room_id = ?;`
+
+	queryRooms = `
+		SELECT room_id, room_name, create_date, last_access, strategy, max_duration, admin_id
+		FROM rooms;`
+
+	insertVote = `
+		INSERT OR REPLACE INTO votes (song_id, user_id, value, date) VALUES
+		(?, ?, ?, datetime('now'));`
+
+	querySongVotes = `
+		SELECT COALESCE(SUM(value), 0) FROM votes WHERE song_id = ?;`
+
+	insertQueue = `
+		INSERT OR REPLACE INTO queues (room_id, strategy, clock, now_playing) VALUES
+		(?, ?, ?, ?);`
+
+	insertQueueEntry = `
+		INSERT INTO queue_entries (room_id, position, song, slot, length, submitted) VALUES
+		(?, ?, ?, ?, ?, ?);`
+
+	insertQueueUser = `
+		INSERT INTO queue_users (room_id, user_id, clock) VALUES
+		(?, ?, ?);`
+
+	deleteQueueEntries = `
+		DELETE FROM queue_entries WHERE room_id = ?;`
+
+	deleteQueueUsers = `
+		DELETE FROM queue_users WHERE room_id = ?;`
+
+	queryQueues = `
+		SELECT room_id, strategy, clock, now_playing FROM queues;`
+
+	queryQueueEntries = `
+		SELECT song, slot, length, submitted FROM queue_entries
+		WHERE room_id = ? ORDER BY position;`
+
+	queryQueueUsers = `
+		SELECT user_id, clock FROM queue_users WHERE room_id = ?;`
 
 	updateUsername = `
 		UPDATE users SET username=?
 		WHERE user_id=?;`
+
+	updateRoomStrategy = `
+		UPDATE rooms SET strategy=?
+		WHERE room_id=?;`
+
+	updateRoomMaxDuration = `
+		UPDATE rooms SET max_duration=?
+		WHERE room_id=?;`
+
+	updateRoomAdmin = `
+		UPDATE rooms SET admin_id=?
+		WHERE room_id=?;`
+
+	claimRoomAdmin = `
+		UPDATE rooms SET admin_id=?
+		WHERE room_id=? AND admin_id=0;`
+
+	updateSongPlayed = `
+		UPDATE songs SET played_date=datetime('now') WHERE id=?;`
+
+	deleteSong = `
+		DELETE FROM songs WHERE id = ?;`
+
+	updateSongFailed = `
+		UPDATE songs SET failed=1, failure_reason=?, failure_notified=0
+		WHERE id=?;`
+
+	queryUnnotifiedFailures = `
+		SELECT id, title, service, service_id, user_id, room_id, failure_reason FROM songs
+		WHERE user_id = ? AND failed = 1 AND failure_notified = 0
+		ORDER BY id;`
+
+	updateFailureNotified = `
+		UPDATE songs SET failure_notified=1
+		WHERE id=?;`
+
+	queryLibraryFiles = `
+		SELECT path, mod_time FROM library_tracks;`
+
+	upsertLibraryTrack = `
+		INSERT INTO library_tracks (path, artist, album, title, duration, track, mod_time) VALUES
+		(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+		artist=excluded.artist, album=excluded.album, title=excluded.title,
+		duration=excluded.duration, track=excluded.track, mod_time=excluded.mod_time;`
+
+	deleteLibraryTrack = `
+		DELETE FROM library_tracks WHERE path = ?;`
+
+	queryLibraryArtists = `
+		SELECT DISTINCT artist FROM library_tracks
+		ORDER BY artist COLLATE NOCASE;`
+
+	queryLibraryAlbums = `
+		SELECT DISTINCT album FROM library_tracks WHERE artist = ?
+		ORDER BY album COLLATE NOCASE;`
+
+	queryLibraryAlbumTracks = `
+		SELECT id, path, artist, album, title, duration, track FROM library_tracks
+		WHERE artist = ? AND album = ?
+		ORDER BY track, title COLLATE NOCASE;`
+
+	// filled in with a condition for each word searched for
+	queryLibrarySearch = `
+		SELECT id, path, artist, album, title, duration, track FROM library_tracks
+		WHERE %s
+		ORDER BY artist COLLATE NOCASE, album COLLATE NOCASE, track
+		LIMIT ?;`
+
+	librarySearchWord = `(artist || ' ' || album || ' ' || title) LIKE ? ESCAPE '\'`
 )
 
+/*
+ * Schema changes applied in order on top of the tables created by
+ * foundDatabase. The schema version of a database is the number of migrations
+ * that have been applied to it.
+ */
+var migrations = []string{
+	// queuing strategy of each room
+	`ALTER TABLE rooms ADD COLUMN strategy TEXT NOT NULL DEFAULT '';`,
+
+	// votes cast by users on the songs in the queue
+	`CREATE TABLE votes (
+		song_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		value INTEGER NOT NULL,
+		date DATETIME NOT NULL,
+		PRIMARY KEY (song_id, user_id),
+		FOREIGN KEY (song_id) REFERENCES songs(id),
+		FOREIGN KEY (user_id) REFERENCES users(user_id));`,
+
+	// saved state of each room's queue
+	`CREATE TABLE queues (
+		room_id INTEGER PRIMARY KEY,
+		strategy TEXT NOT NULL,
+		clock INTEGER NOT NULL,
+		now_playing BLOB,
+		FOREIGN KEY (room_id) REFERENCES rooms(room_id));`,
+
+	// songs in each saved queue in play order
+	`CREATE TABLE queue_entries (
+		room_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		song BLOB NOT NULL,
+		slot INTEGER NOT NULL,
+		length INTEGER NOT NULL,
+		submitted INTEGER NOT NULL,
+		PRIMARY KEY (room_id, position),
+		FOREIGN KEY (room_id) REFERENCES queues(room_id));`,
+
+	// per user ordering data of each saved queue
+	`CREATE TABLE queue_users (
+		room_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		clock INTEGER NOT NULL,
+		PRIMARY KEY (room_id, user_id),
+		FOREIGN KEY (room_id) REFERENCES queues(room_id));`,
+
+	// longest song in seconds allowed in each room
+	`ALTER TABLE rooms ADD COLUMN max_duration INTEGER NOT NULL DEFAULT 0;`,
+
+	// user who controls the players of each room
+	`ALTER TABLE rooms ADD COLUMN admin_id INTEGER NOT NULL DEFAULT 0;`,
+
+	// songs that the players could not play
+	`ALTER TABLE songs ADD COLUMN failed BOOLEAN NOT NULL DEFAULT 0;`,
+
+	// why the players could not play a failed song
+	`ALTER TABLE songs ADD COLUMN failure_reason TEXT NOT NULL DEFAULT '';`,
+
+	// whether the submitter of a failed song has been told about it
+	`ALTER TABLE songs ADD COLUMN failure_notified BOOLEAN NOT NULL DEFAULT 0;`,
+
+	// audio files found in the local music library
+	`CREATE TABLE library_tracks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		path TEXT NOT NULL UNIQUE,
+		artist TEXT NOT NULL,
+		album TEXT NOT NULL,
+		title TEXT NOT NULL,
+		duration INTEGER NOT NULL,
+		track INTEGER NOT NULL,
+		mod_time INTEGER NOT NULL);`,
+
+	// browsing the music library by artist and album
+	`CREATE INDEX library_tracks_artist_album ON library_tracks (artist, album);`,
+
+	// when each song last started playing
+	`ALTER TABLE songs ADD COLUMN played_date DATETIME;`,
+}
+
 type SqliteManager struct {
 	db   *sql.DB
 	lock *sync.RWMutex
@@ -109,6 +319,10 @@ func (mgr *SqliteManager) Init(dbPath string) error {
 		fil.Close()
 	}
 
+	if err = migrateDatabase(mgr.db); err != nil {
+		return err
+	}
+
 	mgr.lock = new(sync.RWMutex)
 	return nil
 }
@@ -145,6 +359,73 @@ func (mgr *SqliteManager) AddSong(song *cmpb.Song) error {
 	return nil
 }
 
+/*
+ * Query for the last time a song from the given service with the given
+ * service id started playing in the room. The zero time is returned if the
+ * song has never played in the room.
+ */
+func (mgr *SqliteManager) GetLastPlayDate(roomId uint32, service cmpb.ServiceType, serviceId string) (time.Time, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	var date time.Time
+	err := mgr.db.QueryRow(queryLastPlayDate, roomId, service, serviceId).Scan(&date)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	} else if err != nil {
+		log.Printf("Error querying last play date of song %s: %v", serviceId, err)
+		return time.Time{}, err
+	}
+
+	return date, nil
+}
+
+/*
+ * Record that the song with the given id started playing now
+ */
+func (mgr *SqliteManager) MarkSongPlayed(songId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(updateSongPlayed)
+	if err != nil {
+		log.Printf("Error preparing mark song played statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(songId)
+	if err != nil {
+		log.Printf("Error marking song played: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Remove the song with the given id from the database
+ */
+func (mgr *SqliteManager) RemoveSong(songId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(deleteSong)
+	if err != nil {
+		log.Printf("Error preparing remove song statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(songId)
+	if err != nil {
+		log.Printf("Error removing song %d: %v", songId, err)
+		return err
+	}
+
+	return nil
+}
+
 /*
  * Add a new user to the database
  */
@@ -231,9 +512,10 @@ func (mgr *SqliteManager) UpdateUsername(username string, userId uint32) error {
 }
 
 /*
- * Adds a new room with given name
+ * Adds a new room with given name, queuing strategy and longest song allowed
+ * in seconds
  */
-func (mgr *SqliteManager) AddRoom(roomName string) (*RoomData, error) {
+func (mgr *SqliteManager) AddRoom(roomName string, strategy string, maxDuration uint32) (*RoomData, error) {
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
@@ -244,7 +526,7 @@ func (mgr *SqliteManager) AddRoom(roomName string) (*RoomData, error) {
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(roomName)
+	res, err := stmt.Exec(roomName, strategy, maxDuration)
 	if err != nil {
 		log.Printf("Error adding new room: %v", err)
 		return nil, err
@@ -264,10 +546,28 @@ func (mgr *SqliteManager) GetRoomByName(roomName string) (*RoomData, error) {
 }
 
 func (mgr *SqliteManager) unsyncGetRoomByName(roomName string) (*RoomData, error) {
+	return scanRoom(mgr.db.QueryRow(queryRoomByName, roomName))
+}
+
+/*
+ * Query for a room given its id
+ */
+func (mgr *SqliteManager) GetRoomById(roomId uint32) (*RoomData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	return scanRoom(mgr.db.QueryRow(queryRoomById, roomId))
+}
+
+/*
+ * Reads a room out of a query result
+ */
+func scanRoom(row *sql.Row) (*RoomData, error) {
 	roomData := new(RoomData)
 
-	err := mgr.db.QueryRow(queryRoomByName, roomName).Scan(&roomData.Room.Id,
-		&roomData.Room.Name, &roomData.CreateDate, &roomData.LastAccess)
+	err := row.Scan(&roomData.Room.Id, &roomData.Room.Name, &roomData.CreateDate,
+		&roomData.LastAccess, &roomData.Room.Strategy, &roomData.Room.MaxDuration,
+		&roomData.Room.AdminId)
 
 	if err != nil {
 		roomData = nil
@@ -278,6 +578,611 @@ func (mgr *SqliteManager) unsyncGetRoomByName(roomName string) (*RoomData, error
 	return roomData, nil
 }
 
+/*
+ * Query for all the rooms
+ */
+func (mgr *SqliteManager) GetRooms() ([]*RoomData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryRooms)
+	if err != nil {
+		log.Printf("Error querying rooms: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]*RoomData, 0)
+	for rows.Next() {
+		roomData := new(RoomData)
+		err = rows.Scan(&roomData.Room.Id, &roomData.Room.Name, &roomData.CreateDate,
+			&roomData.LastAccess, &roomData.Room.Strategy, &roomData.Room.MaxDuration,
+			&roomData.Room.AdminId)
+		if err != nil {
+			log.Printf("Error reading room: %v", err)
+			return nil, err
+		}
+
+		roomData.Room.Err = &bepb.Error{Success: true}
+		rooms = append(rooms, roomData)
+	}
+
+	return rooms, rows.Err()
+}
+
+/*
+ * Updates the queuing strategy of an existing room
+ */
+func (mgr *SqliteManager) UpdateRoomStrategy(roomId uint32, strategy string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(updateRoomStrategy)
+	if err != nil {
+		log.Printf("Error preparing update room strategy statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(strategy, roomId)
+	if err != nil {
+		log.Printf("Error updating room strategy: %v", err)
+		return err
+	}
+
+	log.Printf("Updated room strategy: {id: %d, strategy: %s}", roomId, strategy)
+
+	return nil
+}
+
+/*
+ * Updates the longest song in seconds allowed in an existing room
+ */
+func (mgr *SqliteManager) UpdateRoomMaxDuration(roomId uint32, maxDuration uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(updateRoomMaxDuration)
+	if err != nil {
+		log.Printf("Error preparing update room max duration statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(maxDuration, roomId)
+	if err != nil {
+		log.Printf("Error updating room max duration: %v", err)
+		return err
+	}
+
+	log.Printf("Updated room max duration: {id: %d, max duration: %d}", roomId, maxDuration)
+
+	return nil
+}
+
+/*
+ * Updates the admin of an existing room
+ */
+func (mgr *SqliteManager) UpdateRoomAdmin(roomId uint32, userId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(updateRoomAdmin)
+	if err != nil {
+		log.Printf("Error preparing update room admin statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userId, roomId)
+	if err != nil {
+		log.Printf("Error updating room admin: %v", err)
+		return err
+	}
+
+	log.Printf("Updated room admin: {id: %d, admin id: %d}", roomId, userId)
+
+	return nil
+}
+
+/*
+ * Makes the user the admin of the room if the room doesn't have an admin yet.
+ * Returns true if the user became the admin.
+ */
+func (mgr *SqliteManager) ClaimRoomAdmin(roomId uint32, userId uint32) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(claimRoomAdmin)
+	if err != nil {
+		log.Printf("Error preparing claim room admin statement: %v", err)
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(userId, roomId)
+	if err != nil {
+		log.Printf("Error claiming room admin: %v", err)
+		return false, err
+	}
+
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting rows affected by claiming room admin: %v", err)
+		return false, err
+	}
+
+	return claimed > 0, nil
+}
+
+/*
+ * Records the user's vote on a song, replacing any earlier vote the user made
+ * on the same song
+ */
+func (mgr *SqliteManager) AddVote(songId uint32, userId uint32, value int32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(insertVote)
+	if err != nil {
+		log.Printf("Error preparing add vote statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(songId, userId, value)
+	if err != nil {
+		log.Printf("Error adding vote: %v", err)
+		return err
+	}
+
+	log.Printf("Added vote: {song id: %d, user id: %d, value: %d}", songId, userId, value)
+
+	return nil
+}
+
+/*
+ * Query for the net votes of the given song
+ */
+func (mgr *SqliteManager) GetVotes(songId uint32) (int32, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	var votes int32
+	err := mgr.db.QueryRow(querySongVotes, songId).Scan(&votes)
+	if err != nil {
+		log.Printf("Error querying votes of song %d: %v", songId, err)
+		return 0, err
+	}
+
+	return votes, nil
+}
+
+/*
+ * Marks a song as one that the players could not play for the given reason
+ */
+func (mgr *SqliteManager) MarkSongFailed(songId uint32, reason string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(updateSongFailed)
+	if err != nil {
+		log.Printf("Error preparing mark song failed statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(reason, songId)
+	if err != nil {
+		log.Printf("Error marking song failed: %v", err)
+		return err
+	}
+
+	log.Printf("Marked song failed: {id: %d, reason: %s}", songId, reason)
+
+	return nil
+}
+
+/*
+ * Returns the failed songs submitted by the user that the user hasn't been
+ * told about yet. The songs are marked as told about so they are only
+ * returned once.
+ */
+func (mgr *SqliteManager) PopFailedSongs(userId uint32) ([]*bepb.FailedSong, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	tx, err := mgr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting pop failed songs transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(queryUnnotifiedFailures, userId)
+	if err != nil {
+		log.Printf("Error querying failed songs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := make([]*bepb.FailedSong, 0)
+	for rows.Next() {
+		failure := &bepb.FailedSong{Song: new(cmpb.Song)}
+		err = rows.Scan(&failure.Song.SongId, &failure.Song.Title, &failure.Song.Service,
+			&failure.Song.ServiceId, &failure.Song.UserId, &failure.Song.RoomId, &failure.Reason)
+		if err != nil {
+			log.Printf("Error reading failed song: %v", err)
+			return nil, err
+		}
+
+		failures = append(failures, failure)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, failure := range failures {
+		if _, err = tx.Exec(updateFailureNotified, failure.Song.SongId); err != nil {
+			log.Printf("Error marking failed song notified: %v", err)
+			return nil, err
+		}
+	}
+
+	return failures, tx.Commit()
+}
+
+/*
+ * Returns the modification time of every file in the music library, keyed by
+ * the path of the file
+ */
+func (mgr *SqliteManager) GetLibraryFiles() (map[string]int64, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryLibraryFiles)
+	if err != nil {
+		log.Printf("Error querying library files: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]int64)
+	for rows.Next() {
+		var path string
+		var modTime int64
+		if err = rows.Scan(&path, &modTime); err != nil {
+			log.Printf("Error reading library file: %v", err)
+			return nil, err
+		}
+
+		files[path] = modTime
+	}
+
+	return files, rows.Err()
+}
+
+/*
+ * Adds a file to the music library, replacing the track saved earlier for the
+ * same path
+ */
+func (mgr *SqliteManager) SaveLibraryTrack(track *bepb.LibraryTrack, modTime int64) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.db.Exec(upsertLibraryTrack, track.Path, track.Artist, track.Album, track.Title,
+		track.Duration, track.Track, modTime)
+	if err != nil {
+		log.Printf("Error saving library track %s: %v", track.Path, err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Removes the files with the given paths from the music library
+ */
+func (mgr *SqliteManager) RemoveLibraryTracks(paths []string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	tx, err := mgr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting remove library tracks transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, path := range paths {
+		if _, err = tx.Exec(deleteLibraryTrack, path); err != nil {
+			log.Printf("Error removing library track %s: %v", path, err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Lists the artists of the music library when no artist is given, the albums
+ * of the artist when no album is given, or else the tracks of the album
+ */
+func (mgr *SqliteManager) BrowseLibrary(artist string, album string) (*bepb.LibraryListing, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	listing := new(bepb.LibraryListing)
+	var err error
+	switch {
+	case artist == "":
+		listing.Artists, err = mgr.unsyncQueryNames(queryLibraryArtists)
+	case album == "":
+		listing.Albums, err = mgr.unsyncQueryNames(queryLibraryAlbums, artist)
+	default:
+		listing.Tracks, err = mgr.unsyncQueryLibraryTracks(queryLibraryAlbumTracks, artist, album)
+	}
+
+	if err != nil {
+		log.Printf("Error browsing library: %v", err)
+		return nil, err
+	}
+
+	return listing, nil
+}
+
+/*
+ * Returns at most limit tracks of the music library whose artist, album or
+ * title contain every word of the text
+ */
+func (mgr *SqliteManager) SearchLibrary(text string, limit int) ([]*bepb.LibraryTrack, error) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []*bepb.LibraryTrack{}, nil
+	}
+
+	conditions := make([]string, len(words))
+	args := make([]interface{}, 0, len(words)+1)
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for i, word := range words {
+		conditions[i] = librarySearchWord
+		args = append(args, "%"+escaper.Replace(word)+"%")
+	}
+	args = append(args, limit)
+
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	query := fmt.Sprintf(queryLibrarySearch, strings.Join(conditions, " AND "))
+	tracks, err := mgr.unsyncQueryLibraryTracks(query, args...)
+	if err != nil {
+		log.Printf("Error searching library for %q: %v", text, err)
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+/*
+ * Runs a query for a single column of names
+ */
+func (mgr *SqliteManager) unsyncQueryNames(query string, args ...interface{}) ([]string, error) {
+	rows, err := mgr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+/*
+ * Runs a query for library tracks
+ */
+func (mgr *SqliteManager) unsyncQueryLibraryTracks(query string, args ...interface{}) ([]*bepb.LibraryTrack, error) {
+	rows, err := mgr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := make([]*bepb.LibraryTrack, 0)
+	for rows.Next() {
+		track := new(bepb.LibraryTrack)
+		err = rows.Scan(&track.Id, &track.Path, &track.Artist, &track.Album, &track.Title,
+			&track.Duration, &track.Track)
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+/*
+ * Saves the state of a room's queue, replacing the state saved earlier for the
+ * same room
+ */
+func (mgr *SqliteManager) SaveQueueState(state *bepb.QueueState) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	var nowPlaying []byte
+	var err error
+	if state.NowPlaying != nil {
+		nowPlaying, err = proto.Marshal(state.NowPlaying)
+		if err != nil {
+			log.Printf("Error encoding now playing song: %v", err)
+			return err
+		}
+	}
+
+	tx, err := mgr.db.Begin()
+	if err != nil {
+		log.Printf("Error starting save queue transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(deleteQueueEntries, state.RoomId); err != nil {
+		log.Printf("Error clearing queue entries: %v", err)
+		return err
+	}
+
+	if _, err = tx.Exec(deleteQueueUsers, state.RoomId); err != nil {
+		log.Printf("Error clearing queue users: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(insertQueue, state.RoomId, state.Strategy, state.Clock, nowPlaying)
+	if err != nil {
+		log.Printf("Error saving queue: %v", err)
+		return err
+	}
+
+	for position, entry := range state.Entries {
+		song, err := proto.Marshal(entry.Song)
+		if err != nil {
+			log.Printf("Error encoding queued song: %v", err)
+			return err
+		}
+
+		_, err = tx.Exec(insertQueueEntry, state.RoomId, position, song, entry.Slot, entry.Length, entry.Submitted)
+		if err != nil {
+			log.Printf("Error saving queue entry: %v", err)
+			return err
+		}
+	}
+
+	for userId, clock := range state.Users {
+		if _, err = tx.Exec(insertQueueUser, state.RoomId, userId, clock); err != nil {
+			log.Printf("Error saving queue user: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Query for the saved state of every room's queue
+ */
+func (mgr *SqliteManager) GetQueueStates() ([]*bepb.QueueState, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryQueues)
+	if err != nil {
+		log.Printf("Error querying queues: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make([]*bepb.QueueState, 0)
+	for rows.Next() {
+		state := &bepb.QueueState{Users: make(map[uint32]int64)}
+		var nowPlaying []byte
+
+		err = rows.Scan(&state.RoomId, &state.Strategy, &state.Clock, &nowPlaying)
+		if err != nil {
+			log.Printf("Error reading queue: %v", err)
+			return nil, err
+		}
+
+		if nowPlaying != nil {
+			state.NowPlaying = new(cmpb.Song)
+			if err = proto.Unmarshal(nowPlaying, state.NowPlaying); err != nil {
+				log.Printf("Error decoding now playing song: %v", err)
+				return nil, err
+			}
+		}
+
+		states = append(states, state)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		if err = mgr.unsyncGetQueueEntries(state); err != nil {
+			return nil, err
+		}
+	}
+
+	return states, nil
+}
+
+/*
+ * Reads the songs and per user data of a saved queue into its state. This is
+ * a helper method that relies on other callers to already have the mutex lock.
+ */
+func (mgr *SqliteManager) unsyncGetQueueEntries(state *bepb.QueueState) error {
+	entries, err := mgr.db.Query(queryQueueEntries, state.RoomId)
+	if err != nil {
+		log.Printf("Error querying queue entries: %v", err)
+		return err
+	}
+	defer entries.Close()
+
+	for entries.Next() {
+		entry := &bepb.QueueEntry{Song: new(cmpb.Song)}
+		var song []byte
+
+		err = entries.Scan(&song, &entry.Slot, &entry.Length, &entry.Submitted)
+		if err != nil {
+			log.Printf("Error reading queue entry: %v", err)
+			return err
+		}
+
+		if err = proto.Unmarshal(song, entry.Song); err != nil {
+			log.Printf("Error decoding queued song: %v", err)
+			return err
+		}
+
+		state.Entries = append(state.Entries, entry)
+	}
+
+	if err = entries.Err(); err != nil {
+		return err
+	}
+
+	users, err := mgr.db.Query(queryQueueUsers, state.RoomId)
+	if err != nil {
+		log.Printf("Error querying queue users: %v", err)
+		return err
+	}
+	defer users.Close()
+
+	for users.Next() {
+		var userId uint32
+		var clock int64
+
+		if err = users.Scan(&userId, &clock); err != nil {
+			log.Printf("Error reading queue user: %v", err)
+			return err
+		}
+
+		state.Users[userId] = clock
+	}
+
+	return users.Err()
+}
+
 /*
  * Creates a new database with the necessary tables
  */
@@ -302,3 +1207,33 @@ func foundDatabase(db *sql.DB) error {
 
 	return nil
 }
+
+/*
+ * Applies the schema migrations that the database hasn't seen yet
+ */
+func migrateDatabase(db *sql.DB) error {
+	var version int
+	err := db.QueryRow(querySchemaVersion).Scan(&version)
+	if err != nil {
+		log.Printf("Error reading schema version: %v", err)
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		_, err = db.Exec(migrations[version])
+		if err != nil {
+			log.Printf("Error applying schema migration %d: %v", version+1, err)
+			return err
+		}
+
+		_, err = db.Exec(fmt.Sprintf(updateSchemaVersion, version+1))
+		if err != nil {
+			log.Printf("Error updating schema version to %d: %v", version+1, err)
+			return err
+		}
+
+		log.Printf("Migrated database to schema version %d", version+1)
+	}
+
+	return nil
+}
@@ -9,21 +9,29 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	sqlite "github.com/mattn/go-sqlite3"
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
 )
 
 const (
-	testDbLocation = "/tmp/test_db.db"
-	testRoomId     = 1
-	testRoomName   = "Wizard's Keep"
-	testSongId     = 1
-	testUserId     = 1
-	testUserName   = "Zedd"
+	testDbLocation  = "/tmp/test_db.db"
+	testRoomId      = 1
+	testRoomName    = "Wizard's Keep"
+	testSongId      = 1
+	testUserId      = 1
+	testUserName    = "Zedd"
+	testStrategy    = "round-robin"
+	testMaxDuration = 600
 )
 
-var testSong = cmpb.Song{"Bags!!", 0, testUserName, testUserId, cmpb.ServiceType_Youtube, "0xdeadbeef", testRoomId}
+func newTestSong() *cmpb.Song {
+	return &cmpb.Song{Title: "Bags!!", Username: testUserName, UserId: testUserId,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xdeadbeef", RoomId: testRoomId}
+}
 
 func initDatabase() (*SqliteManager, error) {
 	dbManager := new(SqliteManager)
@@ -58,7 +66,7 @@ func TestAddRoom_when_success(t *testing.T) {
 
 	expectedRoomName := testRoomName
 	expectedRoomId := uint32(testRoomId)
-	actualRoomData, err := dbManager.AddRoom(testRoomName)
+	actualRoomData, err := dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
 	if err != nil {
 		t.Error("Error when adding new room", err)
 	}
@@ -81,7 +89,7 @@ func TestAddUser_when_success(t *testing.T) {
 		t.Error("Error when initializing the database", err)
 	}
 
-	_, err = dbManager.AddRoom(testRoomName)
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
 	if err != nil {
 		t.Error("Error when adding new room", err)
 	}
@@ -116,7 +124,7 @@ func TestAddSong_when_success(t *testing.T) {
 		t.Error("Error when initializing the database", err)
 	}
 
-	_, err = dbManager.AddRoom(testRoomName)
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
 	if err != nil {
 		t.Error("Error when adding new room", err)
 	}
@@ -126,8 +134,8 @@ func TestAddSong_when_success(t *testing.T) {
 		t.Error("Error when adding new user", err)
 	}
 
-	actualSong := testSong
-	err = dbManager.AddSong(&actualSong)
+	actualSong := newTestSong()
+	err = dbManager.AddSong(actualSong)
 	if err != nil {
 		t.Error("Error when adding new song", err)
 	}
@@ -146,7 +154,7 @@ func TestAddSong_whenRoomDoesNotExist_failToAdd(t *testing.T) {
 		t.Error("Error when initializing the database", err)
 	}
 
-	_, err = dbManager.AddRoom(testRoomName)
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
 	if err != nil {
 		t.Error("Error when adding new room", err)
 	}
@@ -156,9 +164,9 @@ func TestAddSong_whenRoomDoesNotExist_failToAdd(t *testing.T) {
 		t.Error("Error when adding new user", err)
 	}
 
-	actualSong := testSong
+	actualSong := newTestSong()
 	actualSong.RoomId = testRoomId + 1
-	err = dbManager.AddSong(&actualSong)
+	err = dbManager.AddSong(actualSong)
 	if err == nil {
 		t.Error("DB manager did not return an error when adding a song with a room id that doesn't exist")
 	}
@@ -177,7 +185,7 @@ func TestGetRoomByName_when_success(t *testing.T) {
 		t.Error("Error when initializing the database", err)
 	}
 
-	_, err = dbManager.AddRoom(testRoomName)
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
 	if err != nil {
 		t.Error("Error when adding new room", err)
 	}
@@ -220,3 +228,449 @@ func TestGetRoomByName_whenRoomDoesNotExist_returnsNil(t *testing.T) {
 
 	cleanUp(dbManager)
 }
+
+func TestAddRoom_storesStrategy(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	roomData, err := dbManager.GetRoomByName(testRoomName)
+	if err != nil {
+		t.Error("Get room by name failed with error:", err)
+	}
+
+	if roomData.Room.Strategy != testStrategy {
+		t.Error("DB manager should return strategy", testStrategy, "but was", roomData.Room.Strategy)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestUpdateRoomStrategy_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	expectedStrategy := "fifo"
+	err = dbManager.UpdateRoomStrategy(testRoomId, expectedStrategy)
+	if err != nil {
+		t.Error("Error when updating room strategy", err)
+	}
+
+	rooms, err := dbManager.GetRooms()
+	if err != nil {
+		t.Error("Get rooms failed with error:", err)
+	}
+
+	if len(rooms) != 1 || rooms[0].Room.Strategy != expectedStrategy {
+		t.Error("DB manager should return one room with strategy", expectedStrategy, "but got", rooms)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestAddVote_replacesEarlierVote(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	_, err = dbManager.AddUser(testUserName, testRoomId)
+	if err != nil {
+		t.Error("Error when adding new user", err)
+	}
+
+	voter, err := dbManager.AddUser("Kid A", testRoomId)
+	if err != nil {
+		t.Error("Error when adding new user", err)
+	}
+
+	err = dbManager.AddSong(newTestSong())
+	if err != nil {
+		t.Error("Error when adding new song", err)
+	}
+
+	if err = dbManager.AddVote(testSongId, testUserId, 1); err != nil {
+		t.Error("Error when adding vote", err)
+	}
+
+	if err = dbManager.AddVote(testSongId, voter.User.UserId, 1); err != nil {
+		t.Error("Error when adding vote", err)
+	}
+
+	if err = dbManager.AddVote(testSongId, voter.User.UserId, -1); err != nil {
+		t.Error("Error when changing vote", err)
+	}
+
+	votes, err := dbManager.GetVotes(testSongId)
+	if err != nil {
+		t.Error("Get votes failed with error:", err)
+	}
+
+	if votes != 0 {
+		t.Error("DB manager should net the votes of the song to 0 but got", votes)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSaveQueueState_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	state := &bepb.QueueState{
+		RoomId:     testRoomId,
+		Strategy:   testStrategy,
+		Clock:      2,
+		NowPlaying: newTestSong(),
+		Entries: []*bepb.QueueEntry{
+			{Song: &cmpb.Song{Title: "first", SongId: 2, UserId: testUserId}, Slot: 2, Submitted: 10},
+			{Song: &cmpb.Song{Title: "second", SongId: 3, UserId: testUserId}, Slot: 3, Submitted: 20},
+		},
+		Users: map[uint32]int64{testUserId: 3},
+	}
+
+	if err = dbManager.SaveQueueState(state); err != nil {
+		t.Error("Error when saving queue state", err)
+	}
+
+	// saving again replaces the earlier state
+	state.Entries = state.Entries[1:]
+	if err = dbManager.SaveQueueState(state); err != nil {
+		t.Error("Error when saving queue state", err)
+	}
+
+	states, err := dbManager.GetQueueStates()
+	if err != nil {
+		t.Error("Get queue states failed with error:", err)
+	}
+
+	if len(states) != 1 || !proto.Equal(states[0], state) {
+		t.Error("DB manager should return", state, "but got", states)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetLastPlayDate_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	_, err = dbManager.AddUser(testUserName, testRoomId)
+	if err != nil {
+		t.Error("Error when adding new user", err)
+	}
+
+	song := newTestSong()
+	if err = dbManager.AddSong(song); err != nil {
+		t.Error("Error when adding new song", err)
+	}
+
+	date, err := dbManager.GetLastPlayDate(testRoomId, song.Service, song.ServiceId)
+	if err != nil || !date.IsZero() {
+		t.Error("DB manager should return the zero time for a song that never played but got", date, err)
+	}
+
+	// the song was submitted long ago but only just played
+	_, err = dbManager.db.Exec("UPDATE songs SET date=datetime('now', '-1 day') WHERE id=?;", song.SongId)
+	if err != nil {
+		t.Error("Error when aging the song", err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	if err = dbManager.MarkSongPlayed(song.SongId); err != nil {
+		t.Error("Error when marking the song played", err)
+	}
+
+	date, err = dbManager.GetLastPlayDate(testRoomId, song.Service, song.ServiceId)
+	if err != nil || date.Before(start) {
+		t.Error("DB manager should return a recent date but got", date, err)
+	}
+
+	date, err = dbManager.GetLastPlayDate(testRoomId+1, song.Service, song.ServiceId)
+	if err != nil || !date.IsZero() {
+		t.Error("DB manager should not find the song in another room but got", date, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestRemoveSong_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	_, err = dbManager.AddUser(testUserName, testRoomId)
+	if err != nil {
+		t.Error("Error when adding new user", err)
+	}
+
+	song := newTestSong()
+	if err = dbManager.AddSong(song); err != nil {
+		t.Error("Error when adding new song", err)
+	}
+
+	if err = dbManager.MarkSongPlayed(song.SongId); err != nil {
+		t.Error("Error when marking the song played", err)
+	}
+
+	if err = dbManager.RemoveSong(song.SongId); err != nil {
+		t.Error("Error when removing the song", err)
+	}
+
+	date, err := dbManager.GetLastPlayDate(testRoomId, song.Service, song.ServiceId)
+	if err != nil || !date.IsZero() {
+		t.Error("DB manager should not find a removed song but got", date, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestUpdateRoomMaxDuration_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	roomData, err := dbManager.GetRoomById(testRoomId)
+	if err != nil || roomData.Room.MaxDuration != testMaxDuration {
+		t.Error("DB manager should return a room with max duration", testMaxDuration, "but got", roomData, err)
+	}
+
+	expectedMaxDuration := uint32(300)
+	err = dbManager.UpdateRoomMaxDuration(testRoomId, expectedMaxDuration)
+	if err != nil {
+		t.Error("Error when updating room max duration", err)
+	}
+
+	roomData, err = dbManager.GetRoomById(testRoomId)
+	if err != nil || roomData.Room.MaxDuration != expectedMaxDuration {
+		t.Error("DB manager should return a room with max duration", expectedMaxDuration, "but got", roomData, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestUpdateRoomAdmin_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	roomData, err := dbManager.GetRoomById(testRoomId)
+	if err != nil || roomData.Room.AdminId != 0 {
+		t.Error("DB manager should return a room without an admin but got", roomData, err)
+	}
+
+	err = dbManager.UpdateRoomAdmin(testRoomId, testUserId)
+	if err != nil {
+		t.Error("Error when updating room admin", err)
+	}
+
+	roomData, err = dbManager.GetRoomByName(testRoomName)
+	if err != nil || roomData.Room.AdminId != testUserId {
+		t.Error("DB manager should return a room with admin", testUserId, "but got", roomData, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestClaimRoomAdmin_whenRoomHasAdmin_keepsAdmin(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	claimed, err := dbManager.ClaimRoomAdmin(testRoomId, testUserId)
+	if err != nil || !claimed {
+		t.Error("DB manager should make the user the admin of a room without one", err)
+	}
+
+	claimed, err = dbManager.ClaimRoomAdmin(testRoomId, testUserId+1)
+	if err != nil || claimed {
+		t.Error("DB manager should not replace the admin of a room", err)
+	}
+
+	roomData, err := dbManager.GetRoomById(testRoomId)
+	if err != nil || roomData.Room.AdminId != testUserId {
+		t.Error("DB manager should return a room with admin", testUserId, "but got", roomData, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestPopFailedSongs_returnsFailuresOnce(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	_, err = dbManager.AddRoom(testRoomName, testStrategy, testMaxDuration)
+	if err != nil {
+		t.Error("Error when adding new room", err)
+	}
+
+	_, err = dbManager.AddUser(testUserName, testRoomId)
+	if err != nil {
+		t.Error("Error when adding new user", err)
+	}
+
+	err = dbManager.AddSong(newTestSong())
+	if err != nil {
+		t.Error("Error when adding new song", err)
+	}
+
+	err = dbManager.AddSong(newTestSong())
+	if err != nil {
+		t.Error("Error when adding new song", err)
+	}
+
+	if err = dbManager.MarkSongFailed(testSongId, "Video unavailable"); err != nil {
+		t.Error("Error when marking song failed", err)
+	}
+
+	failures, err := dbManager.PopFailedSongs(testUserId)
+	if err != nil {
+		t.Error("Pop failed songs failed with error:", err)
+	}
+
+	if len(failures) != 1 {
+		t.Fatal("DB manager should return 1 failed song but got", failures)
+	}
+
+	if failures[0].Song.SongId != testSongId || failures[0].Reason != "Video unavailable" {
+		t.Error("DB manager returned the wrong failed song:", failures[0])
+	}
+
+	if failures[0].Song.ServiceId != newTestSong().ServiceId || failures[0].Song.Service != cmpb.ServiceType_Youtube {
+		t.Error("DB manager returned the failed song without its link:", failures[0].Song)
+	}
+
+	failures, err = dbManager.PopFailedSongs(testUserId)
+	if err != nil || len(failures) != 0 {
+		t.Error("DB manager should only return a failed song once but got", failures, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestLibrary_browsesAndSearchesSavedTracks(t *testing.T) {
+	dbManager, err := initDatabase()
+	defer cleanUp(dbManager)
+	if err != nil {
+		t.Fatal("Error when initializing the database", err)
+	}
+
+	tracks := []*bepb.LibraryTrack{
+		{Path: "/music/a/1.mp3", Artist: "Daft Punk", Album: "Discovery", Title: "One More Time", Track: 1},
+		{Path: "/music/a/2.mp3", Artist: "Daft Punk", Album: "Discovery", Title: "Aerodynamic", Track: 2},
+		{Path: "/music/b/1.flac", Artist: "Air", Album: "Moon Safari", Title: "La Femme d'Argent", Track: 1},
+	}
+	for i, track := range tracks {
+		if err = dbManager.SaveLibraryTrack(track, int64(i)); err != nil {
+			t.Fatal("Error saving library track", err)
+		}
+	}
+
+	// saving a path again replaces its track
+	tracks[1].Title = "Aerodynamic (Remastered)"
+	if err = dbManager.SaveLibraryTrack(tracks[1], 10); err != nil {
+		t.Fatal("Error saving library track again", err)
+	}
+
+	files, err := dbManager.GetLibraryFiles()
+	if err != nil || len(files) != 3 || files["/music/a/2.mp3"] != 10 {
+		t.Error("Expected 3 library files with updated modification times but got", files, err)
+	}
+
+	listing, err := dbManager.BrowseLibrary("", "")
+	if err != nil || len(listing.Artists) != 2 || listing.Artists[0] != "Air" {
+		t.Error("Expected the artists in order but got", listing, err)
+	}
+
+	listing, err = dbManager.BrowseLibrary("Daft Punk", "Discovery")
+	if err != nil || len(listing.Tracks) != 2 || listing.Tracks[1].Title != "Aerodynamic (Remastered)" {
+		t.Error("Expected the tracks of the album in order but got", listing, err)
+	}
+
+	found, err := dbManager.SearchLibrary("daft remaster", 10)
+	if err != nil || len(found) != 1 || found[0].Path != "/music/a/2.mp3" {
+		t.Error("Expected to find the track matching every word but got", found, err)
+	}
+
+	found, err = dbManager.SearchLibrary("100%", 10)
+	if err != nil || len(found) != 0 {
+		t.Error("Expected wildcards in the text to be matched literally but got", found, err)
+	}
+
+	if err = dbManager.RemoveLibraryTracks([]string{"/music/b/1.flac"}); err != nil {
+		t.Fatal("Error removing library track", err)
+	}
+
+	listing, err = dbManager.BrowseLibrary("", "")
+	if err != nil || len(listing.Artists) != 1 || listing.Artists[0] != "Daft Punk" {
+		t.Error("Expected only the remaining artist but got", listing, err)
+	}
+}
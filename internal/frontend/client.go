@@ -10,6 +10,7 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 
 	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
@@ -38,8 +39,8 @@ func (c *BackendClient) Connect(host string, port string) error {
 	return err
 }
 
-func (c *BackendClient) GetPlaylist() (*bepb.Playlist, error) {
-	playlist, err := c.be_client.GetPlaylist(context.Background(), &cmpb.Empty{})
+func (c *BackendClient) GetPlaylist(user_id uint32) (*bepb.Playlist, error) {
+	playlist, err := c.be_client.GetPlaylist(context.Background(), &bepb.User{UserId: user_id})
 
 	if err != nil {
 		log.Printf("Failed to fetch playlist with error: %v\n", err)
@@ -48,10 +49,11 @@ func (c *BackendClient) GetPlaylist() (*bepb.Playlist, error) {
 	return playlist, err
 }
 
-func (c *BackendClient) SendNewSong(link string, user_id uint32) (*bepb.Error, error) {
+func (c *BackendClient) SendNewSong(link string, user_id uint32, playlist bool) (*bepb.Error, error) {
 	var submission = bepb.Submission{
-		Link:   link,
-		UserId: user_id,
+		Link:           link,
+		UserId:         user_id,
+		ExpandPlaylist: playlist,
 	}
 
 	response, err := c.be_client.SendSong(context.Background(), &submission)
@@ -67,8 +69,8 @@ func (c *BackendClient) SendNewSong(link string, user_id uint32) (*bepb.Error, e
 	return response, err
 }
 
-func (c *BackendClient) GetNowPlaying() (*cmpb.Song, error) {
-	song, err := c.be_client.GetNowPlaying(context.Background(), &cmpb.Empty{})
+func (c *BackendClient) GetNowPlaying(user_id uint32) (*cmpb.Song, error) {
+	song, err := c.be_client.GetNowPlaying(context.Background(), &bepb.User{UserId: user_id})
 
 	if err != nil {
 		log.Printf("Failed to fetch currently playing song with error: %v\n", err)
@@ -77,6 +79,17 @@ func (c *BackendClient) GetNowPlaying() (*cmpb.Song, error) {
 	return song, err
 }
 
+func (c *BackendClient) GetPlayback(user_id uint32) (*bepb.Playback, error) {
+	playback, err := c.be_client.GetPlayback(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to fetch playback with error: %v\n", err)
+		playback = &bepb.Playback{Song: &cmpb.Song{}}
+	}
+
+	return playback, err
+}
+
 func (c *BackendClient) RemoveSong(song_id uint32, user_id uint32) (*bepb.Error, error) {
 	var eviction_request = bepb.Eviction{
 		SongId: song_id,
@@ -92,6 +105,27 @@ func (c *BackendClient) RemoveSong(song_id uint32, user_id uint32) (*bepb.Error,
 	return response, err
 }
 
+func (c *BackendClient) MoveSong(song_id uint32, user_id uint32, offset int32) (*bepb.Error, error) {
+	var move_request = bepb.Move{
+		SongId: song_id,
+		UserId: user_id,
+		Offset: offset,
+	}
+
+	response, err := c.be_client.MoveSong(context.Background(), &move_request)
+
+	if err != nil {
+		log.Printf("Failed to move song with error: %v\n", err)
+		return response, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
 func (c *BackendClient) LoginNewUser(userName string, roomName string) (*bepb.User, error) {
 	roomRequest := bepb.Room{Name: roomName}
 
@@ -120,11 +154,158 @@ func (c *BackendClient) LoginNewUser(userName string, roomName string) (*bepb.Us
 	return user, err
 }
 
-func (c *BackendClient) NextSong() (*bepb.Error, error) {
-	response, err := c.be_client.NextSong(context.Background(), &cmpb.Empty{})
+func (c *BackendClient) SkipVote(user_id uint32) (*bepb.SkipTally, error) {
+	tally, err := c.be_client.SkipVote(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to vote to skip currently playing song with error: %v\n", err)
+		return tally, err
+	}
+
+	if !tally.Err.Success {
+		err = errors.New(tally.Err.Message)
+	}
+
+	return tally, err
+}
+
+func (c *BackendClient) GetSkipTally(user_id uint32) (*bepb.SkipTally, error) {
+	tally, err := c.be_client.GetSkipTally(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to fetch skip votes with error: %v\n", err)
+	}
+
+	return tally, err
+}
+
+func (c *BackendClient) GetFailedSongs(user_id uint32) (*bepb.FailedSongs, error) {
+	failures, err := c.be_client.GetFailedSongs(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to fetch failed songs with error: %v\n", err)
+	}
+
+	return failures, err
+}
+
+/*
+ * Search for songs matching the text. The error carries a message that can be
+ * shown to the user.
+ */
+func (c *BackendClient) SearchSongs(text string, user_id uint32) (*bepb.SearchResults, error) {
+	results, err := c.be_client.SearchSongs(context.Background(), &bepb.SearchQuery{Text: text, UserId: user_id})
 
 	if err != nil {
-		log.Printf("Failed to skip currently playing song with error: %v\n", err)
+		log.Printf("Failed to search for songs with error: %v\n", err)
+		return nil, errors.New(status.Convert(err).Message())
+	}
+
+	return results, nil
+}
+
+func (c *BackendClient) VoteSong(song_id uint32, user_id uint32, value int32) (*bepb.Error, error) {
+	vote := bepb.Vote{
+		SongId: song_id,
+		UserId: user_id,
+		Value:  value,
+	}
+
+	response, err := c.be_client.VoteSong(context.Background(), &vote)
+
+	if err != nil {
+		log.Printf("Failed to vote on song with error: %v\n", err)
+		return response, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) GetUser(user_id uint32) (*bepb.User, error) {
+	user, err := c.be_client.GetUser(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to fetch user with error: %v\n", err)
+		return &bepb.User{}, err
+	}
+
+	if !user.Err.Success {
+		err = errors.New(user.Err.Message)
+	}
+
+	return user, err
+}
+
+func (c *BackendClient) SeekSong(user_id uint32, seconds float64, relative bool) (*bepb.Error, error) {
+	seek := bepb.SeekPosition{
+		User:     &bepb.User{UserId: user_id},
+		Seconds:  seconds,
+		Relative: relative,
+	}
+
+	response, err := c.be_client.SeekSong(context.Background(), &seek)
+
+	if err != nil {
+		log.Printf("Failed to seek song with error: %v\n", err)
+		return response, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) SetVolume(user_id uint32, level float64) (*bepb.Error, error) {
+	volume := bepb.VolumeLevel{
+		User:  &bepb.User{UserId: user_id},
+		Level: level,
+	}
+
+	response, err := c.be_client.SetVolume(context.Background(), &volume)
+
+	if err != nil {
+		log.Printf("Failed to set volume with error: %v\n", err)
+		return response, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) MuteSong(user_id uint32) (*bepb.Error, error) {
+	response, err := c.be_client.MuteSong(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to mute song with error: %v\n", err)
+		return response, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) RestartSong(user_id uint32) (*bepb.Error, error) {
+	response, err := c.be_client.RestartSong(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to restart song with error: %v\n", err)
+		return response, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
 	}
 
 	return response, err
@@ -18,7 +18,9 @@ import (
 	"github.com/gorilla/securecookie"
 
 	"github.com/nguyenmq/ytbox-go/internal/common"
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+	"github.com/nguyenmq/ytbox-go/internal/provider"
 )
 
 var ErrFailedLogin = errors.New("Failed to login user.")
@@ -28,6 +30,12 @@ var ErrMissingRoomName = errors.New("Missing room name.")
 var ErrMissingSessionToken = errors.New("Missing session token. Please log back in.")
 var ErrMissingLink = errors.New("Missing song link.")
 var ErrRemoveMissingSong = errors.New("Did not supply a song to remove.")
+var ErrMoveMissingSong = errors.New("Did not supply a song to move.")
+var ErrInvalidMove = errors.New("A song can only be moved up or down.")
+var ErrVoteMissingSong = errors.New("Did not supply a song to vote on.")
+var ErrInvalidVote = errors.New("A vote must be up or down.")
+var ErrInvalidSeek = errors.New("Did not supply a position to seek to.")
+var ErrInvalidVolume = errors.New("Did not supply a volume level.")
 var ErrFailedToProcessSong = errors.New("Could not process your submission. Please check your link.")
 
 const (
@@ -45,17 +53,19 @@ const (
 )
 
 type FrontendServer struct {
-	addr   string                     // ip address and port to listen on
-	client *BackendClient             // the backend client
-	router *gin.Engine                // gin router
-	server *http.Server               // http server
-	cookie *securecookie.SecureCookie // secure cookie provider
+	addr      string                     // ip address and port to listen on
+	client    *BackendClient             // the backend client
+	router    *gin.Engine                // gin router
+	server    *http.Server               // http server
+	cookie    *securecookie.SecureCookie // secure cookie provider
+	providers *provider.Registry         // sources that songs come from
 }
 
 func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool) *FrontendServer {
 	frontend := new(FrontendServer)
 	frontend.addr = addr
 	frontend.cookie = securecookie.New(hashKey, blockKey)
+	frontend.providers = provider.NewRegistry("")
 
 	gin.DefaultWriter = common.GetLogger()
 	gin.DefaultErrorWriter = common.GetLogger()
@@ -89,9 +99,16 @@ func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool) *Fron
 	frontend.router.POST("/new_song", frontend.HandleNewSong)
 	frontend.router.GET("/now_playing", frontend.HandleNowPlaying)
 	frontend.router.POST("/remove", frontend.HandleRemove)
+	frontend.router.POST("/vote", frontend.HandleVote)
+	frontend.router.POST("/move", frontend.HandleMove)
+	frontend.router.POST("/seek", frontend.HandleSeek)
+	frontend.router.POST("/volume", frontend.HandleVolume)
+	frontend.router.POST("/mute", frontend.HandleMute)
+	frontend.router.POST("/restart", frontend.HandleRestart)
 	frontend.router.GET("/login", frontend.HandleLoginPage)
 	frontend.router.POST("/login", frontend.HandleLoginPost)
 	frontend.router.GET("/next", frontend.HandleNextSong)
+	frontend.router.GET("/failed_songs", frontend.HandleFailedSongs)
 	frontend.router.GET("/ping", func(context *gin.Context) {
 		context.String(http.StatusOK, "pong")
 	})
@@ -119,41 +136,47 @@ func (s *FrontendServer) HandleIndex(context *gin.Context) {
 	} else {
 		title := "No song is currently playing"
 
-		current_song, err := s.client.GetNowPlaying()
+		playback, err := s.client.GetPlayback(userId)
+		current_song := playback.Song
 		has_song_playing := current_song.SongId != 0
 
 		if err == nil && has_song_playing {
 			title = truncate_song_title(current_song.Title, titleMaxLength)
 		}
 
-		playlist, err := s.client.GetPlaylist()
+		playlist, err := s.client.GetPlaylist(userId)
 
 		context.HTML(http.StatusOK, "index", gin.H{
 			"title":                "yt-box: Song Queue",
 			"now_playing":          title,
 			"has_song_playing":     has_song_playing,
 			"song":                 current_song,
+			"playback":             playback,
+			"format_seconds":       format_seconds,
+			"is_admin":             s.isRoomAdmin(userId),
+			"skip_tally":           s.getSkipTally(userId),
 			"song_count":           len(playlist.Songs),
 			"queue":                playlist.Songs,
 			"session_user_id":      userId,
 			"increment_index":      increment_index,
 			"transform_thumbnail":  s.transformThumbnailLink,
 			"transform_user_name":  s.transformUsername,
+			"song_link":            s.songLink,
 			"matches_session_user": s.matchesSessionUser,
 		})
 	}
 }
 
 func (s *FrontendServer) HandlePlaylist(context *gin.Context) {
-	playlist, err := s.client.GetPlaylist()
+	userId, err := s.getUserIdCookie(context)
 	if err != nil {
-		buildErrorResponse(context, http.StatusInternalServerError, err)
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
 		return
 	}
 
-	userId, err := s.getUserIdCookie(context)
+	playlist, err := s.client.GetPlaylist(userId)
 	if err != nil {
-		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		buildErrorResponse(context, http.StatusInternalServerError, err)
 	} else {
 		context.HTML(http.StatusOK, "layouts/queue.html", gin.H{
 			"song_count":           len(playlist.Songs),
@@ -162,6 +185,7 @@ func (s *FrontendServer) HandlePlaylist(context *gin.Context) {
 			"increment_index":      increment_index,
 			"transform_thumbnail":  s.transformThumbnailLink,
 			"transform_user_name":  s.transformUsername,
+			"song_link":            s.songLink,
 			"matches_session_user": s.matchesSessionUser,
 		})
 	}
@@ -181,50 +205,154 @@ func (s *FrontendServer) HandleNewSong(context *gin.Context) {
 		return
 	}
 
-	_, err = s.client.SendNewSong(link, userId)
+	_, playlist := context.GetPostForm("expand_playlist")
+	if _, isLink := s.providers.Match(link); !isLink && !playlist {
+		s.renderSearchResults(context, link, userId)
+		return
+	}
+
+	response, err := s.client.SendNewSong(link, userId, playlist)
 	if err != nil {
 		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else if playlist {
+		context.HTML(http.StatusOK, "layouts/alert.html", gin.H{
+			"alert_type": AlertInfo,
+			"alert_emph": AlertEmphInfo,
+			"alert_msg":  response.Message,
+		})
 	} else {
 		context.Status(http.StatusOK)
 	}
 }
 
+/*
+ * Renders the songs found by searching for the text so that the user can pick
+ * one of them to submit
+ */
+func (s *FrontendServer) renderSearchResults(context *gin.Context, text string, userId uint32) {
+	results, err := s.client.SearchSongs(text, userId)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+		return
+	}
+
+	context.HTML(http.StatusOK, "layouts/search_results.html", gin.H{
+		"query":               text,
+		"songs":               results.Songs,
+		"transform_thumbnail": s.transformThumbnailLink,
+		"submit_link":         s.submitLink,
+		"song_length":         song_length,
+	})
+}
+
 func (s *FrontendServer) HandleNowPlaying(context *gin.Context) {
 	title := "No song is currently playing"
 
-	current_song, err := s.client.GetNowPlaying()
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	playback, err := s.client.GetPlayback(userId)
+	current_song := playback.Song
 	has_song_playing := current_song.SongId != 0
 
 	if err == nil && has_song_playing {
 		title = truncate_song_title(current_song.Title, titleMaxLength)
 	}
 
-	userId, err := s.getUserIdCookie(context)
+	context.HTML(http.StatusOK, "layouts/now_playing.html", gin.H{
+		"now_playing":          title,
+		"has_song_playing":     has_song_playing,
+		"session_user_id":      userId,
+		"song":                 current_song,
+		"playback":             playback,
+		"format_seconds":       format_seconds,
+		"is_admin":             s.isRoomAdmin(userId),
+		"skip_tally":           s.getSkipTally(userId),
+		"transform_user_name":  s.transformUsername,
+		"song_link":            s.songLink,
+		"matches_session_user": s.matchesSessionUser,
+	})
+}
+
+func (s *FrontendServer) HandleRemove(context *gin.Context) {
+	// todo: get user id from session
+	song_id_str, exists := context.GetPostForm("song_id")
+
+	if exists == false {
+		buildErrorResponse(context, http.StatusBadRequest, ErrRemoveMissingSong)
+		return
+	}
+
+	song_id, err := strconv.ParseUint(song_id_str, 10, 32)
 
+	userId, err := s.getUserIdCookie(context)
 	if err != nil {
 		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err = s.client.RemoveSong(uint32(song_id), userId)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
 	} else {
-		context.HTML(http.StatusOK, "layouts/now_playing.html", gin.H{
-			"now_playing":          title,
-			"has_song_playing":     has_song_playing,
-			"session_user_id":      userId,
-			"song":                 current_song,
-			"transform_user_name":  s.transformUsername,
-			"matches_session_user": s.matchesSessionUser,
-		})
+		context.Status(http.StatusOK)
 	}
 }
 
-func (s *FrontendServer) HandleRemove(context *gin.Context) {
-	// todo: get user id from session
+func (s *FrontendServer) HandleMove(context *gin.Context) {
 	song_id_str, exists := context.GetPostForm("song_id")
+	if exists == false {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMoveMissingSong)
+		return
+	}
+
+	song_id, err := strconv.ParseUint(song_id_str, 10, 32)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMoveMissingSong)
+		return
+	}
 
+	offset, err := strconv.ParseInt(context.PostForm("offset"), 10, 32)
+	if err != nil || (offset != -1 && offset != 1) {
+		buildErrorResponse(context, http.StatusBadRequest, ErrInvalidMove)
+		return
+	}
+
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err = s.client.MoveSong(uint32(song_id), userId, int32(offset))
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+func (s *FrontendServer) HandleVote(context *gin.Context) {
+	song_id_str, exists := context.GetPostForm("song_id")
 	if exists == false {
-		buildErrorResponse(context, http.StatusBadRequest, ErrRemoveMissingSong)
+		buildErrorResponse(context, http.StatusBadRequest, ErrVoteMissingSong)
 		return
 	}
 
 	song_id, err := strconv.ParseUint(song_id_str, 10, 32)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrVoteMissingSong)
+		return
+	}
+
+	value, err := strconv.ParseInt(context.PostForm("value"), 10, 32)
+	if err != nil || value < -1 || value > 1 {
+		buildErrorResponse(context, http.StatusBadRequest, ErrInvalidVote)
+		return
+	}
 
 	userId, err := s.getUserIdCookie(context)
 	if err != nil {
@@ -232,7 +360,81 @@ func (s *FrontendServer) HandleRemove(context *gin.Context) {
 		return
 	}
 
-	_, err = s.client.RemoveSong(uint32(song_id), userId)
+	_, err = s.client.VoteSong(uint32(song_id), userId, int32(value))
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+func (s *FrontendServer) HandleSeek(context *gin.Context) {
+	seconds, err := strconv.ParseFloat(context.PostForm("seconds"), 64)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrInvalidSeek)
+		return
+	}
+
+	relative := context.PostForm("relative") == "true"
+
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err = s.client.SeekSong(userId, seconds, relative)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+func (s *FrontendServer) HandleVolume(context *gin.Context) {
+	level, err := strconv.ParseFloat(context.PostForm("level"), 64)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrInvalidVolume)
+		return
+	}
+
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err = s.client.SetVolume(userId, level)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+func (s *FrontendServer) HandleMute(context *gin.Context) {
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err = s.client.MuteSong(userId)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+func (s *FrontendServer) HandleRestart(context *gin.Context) {
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err = s.client.RestartSong(userId)
 	if err != nil {
 		buildErrorResponse(context, http.StatusInternalServerError, err)
 	} else {
@@ -277,19 +479,66 @@ func (s *FrontendServer) HandleLoginPost(context *gin.Context) {
 }
 
 func (s *FrontendServer) HandleNextSong(context *gin.Context) {
-	current_song, _ := s.client.GetNowPlaying()
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
 
+	_, err = s.client.SkipVote(userId)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+/*
+ * Renders an alert for each of the user's songs that the players couldn't play
+ * since the user last checked
+ */
+func (s *FrontendServer) HandleFailedSongs(context *gin.Context) {
 	userId, err := s.getUserIdCookie(context)
 	if err != nil {
 		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
 		return
 	}
 
-	if s.matchesSessionUser(current_song.UserId, userId) {
-		s.client.NextSong()
+	failures, err := s.client.GetFailedSongs(userId)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.HTML(http.StatusOK, "layouts/failed_songs.html", gin.H{
+			"failures":   failures.Songs,
+			"alert_type": AlertWarning,
+			"alert_emph": AlertEmphWarn,
+		})
+	}
+}
+
+/*
+ * Returns the skip votes of the song playing in the user's room. An empty
+ * tally is returned if the votes couldn't be fetched.
+ */
+func (s *FrontendServer) getSkipTally(userId uint32) *bepb.SkipTally {
+	tally, err := s.client.GetSkipTally(userId)
+	if err != nil {
+		return &bepb.SkipTally{}
 	}
 
-	context.Status(http.StatusOK)
+	return tally
+}
+
+/*
+ * Returns true if the user controls the players of their room
+ */
+func (s *FrontendServer) isRoomAdmin(userId uint32) bool {
+	user, err := s.client.GetUser(userId)
+	if err != nil {
+		return false
+	}
+
+	return user.Admin
 }
 
 func (s *FrontendServer) transformUsername(song *cmpb.Song, session_user_id uint32) string {
@@ -308,6 +557,34 @@ func (s *FrontendServer) transformThumbnailLink(song *cmpb.Song) string {
 	}
 }
 
+/*
+ * Returns the web page of the song for users to open. Local files have none.
+ */
+func (s *FrontendServer) songLink(song *cmpb.Song) string {
+	if song.Service == cmpb.ServiceType_Local {
+		return ""
+	}
+
+	link, err := s.providers.PlayableUri(song)
+	if err != nil {
+		return ""
+	}
+
+	return link
+}
+
+/*
+ * Returns the link or path that queues the song when it's submitted
+ */
+func (s *FrontendServer) submitLink(song *cmpb.Song) string {
+	link, err := s.providers.PlayableUri(song)
+	if err != nil {
+		return ""
+	}
+
+	return link
+}
+
 func (s *FrontendServer) matchesSessionUser(user_id uint32, session_user_id uint32) bool {
 	return user_id == session_user_id
 }
@@ -316,6 +593,23 @@ func increment_index(index int) int {
 	return index + 1
 }
 
+func format_seconds(seconds float64) string {
+	total := int(seconds)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, (total/60)%60, total%60)
+	}
+
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+func song_length(song *cmpb.Song) string {
+	if song.Duration == 0 {
+		return ""
+	}
+
+	return format_seconds(float64(song.Duration))
+}
+
 func truncate_song_title(title string, length int) string {
 	if len(title) > length {
 		return fmt.Sprintf("%s…", title[0:length])
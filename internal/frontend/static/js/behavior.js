@@ -13,7 +13,7 @@ $(document).ready(function(){
         $.ajax({
             url: "/new_song",
             type: "POST",
-            data: $("input"),
+            data: $("#link_form").serialize(),
             error: function(jqXHR, textStatus, errorThrown) {
                 if(jqXHR.status == 500 || jqXHR.status == 400) {
                     $("#alert_area").empty();
@@ -25,6 +25,17 @@ $(document).ready(function(){
                 this.always()
             },
             success: function(data, textStatus, errorThrown) {
+                $("#loading").removeClass("spin")
+                if($(data).filter("#search_results").length > 0) {
+                    $("#search_area").html(data);
+                    this.always()
+                    return;
+                }
+                if(data) {
+                    $("#alert_area").empty();
+                    $("#alert_area").append(data);
+                }
+                $("#search_area").empty();
                 $("#submit_box").val("");
                 refresh_elements();
                 this.always()
@@ -61,6 +72,8 @@ $(document).ready(function(){
             success: function(data, textStatus, errorThrown) {
                 $("#banner").empty();
                 $("#banner").append(data);
+                start_playback();
+                show_failed_songs();
                 //disable_wrap();
 
                 // Make the ajax call refresh the queue
@@ -84,13 +97,140 @@ $(document).ready(function(){
                         $("#queue_title").on("tap", refresh_elements);
                         $("#queue_button").click(refresh_elements);
                         $(".queue_rm").click(remove_song);
+                        $(".queue_vote").click(vote_song);
+                        $(".queue_move").click(move_song);
                         $(".skip_now_playing").click(skip_song);
+                        register_player_controls();
                     },
                 });
             },
         });
     };
 
+    /*----------------------------------------------------------------
+    Tell the user about their songs that the players couldn't play
+    ----------------------------------------------------------------*/
+    function show_failed_songs() {
+        $.ajax({
+            url: "/failed_songs",
+            type: "GET",
+            dataType: "html",
+            success: function(data, textStatus, errorThrown) {
+                if ($.trim(data).length > 0) {
+                    $("#alert_area").append(data);
+                    refresh_elements();
+                }
+            },
+        });
+    };
+
+    /*----------------------------------------------------------------
+    Move the progress bar of the now playing song along every second
+    ----------------------------------------------------------------*/
+    var playback_timer = null;
+
+    function start_playback() {
+        clearInterval(playback_timer);
+        playback_timer = null;
+
+        var playback = $("#playback");
+        if (playback.length == 0) {
+            return;
+        }
+
+        var position = parseFloat(playback.data("position")) || 0;
+        var duration = parseFloat(playback.data("duration")) || 0;
+        var paused = playback.data("paused") === true;
+        var started = Date.now();
+
+        function tick() {
+            var elapsed = position;
+            if (!paused) {
+                elapsed += (Date.now() - started) / 1000;
+            }
+
+            if (duration > 0) {
+                elapsed = Math.min(elapsed, duration);
+                $("#playback_bar").css("width", (elapsed / duration * 100) + "%");
+                $("#playback_remaining").text("-" + format_seconds(duration - elapsed));
+            }
+            $("#playback_elapsed").text(format_seconds(elapsed));
+
+            // pick up the next song once this one should have finished
+            if (!paused && duration > 0 && elapsed >= duration) {
+                clearInterval(playback_timer);
+                playback_timer = null;
+                setTimeout(refresh_elements, 3000);
+            }
+        }
+
+        tick();
+        if (!paused) {
+            playback_timer = setInterval(tick, 1000);
+        }
+    };
+
+    function format_seconds(seconds) {
+        var total = Math.floor(seconds);
+        var secs = ("0" + (total % 60)).slice(-2);
+
+        if (total >= 3600) {
+            var mins = ("0" + (Math.floor(total / 60) % 60)).slice(-2);
+            return Math.floor(total / 3600) + ":" + mins + ":" + secs;
+        }
+
+        return Math.floor(total / 60) + ":" + secs;
+    };
+
+    /*----------------------------------------------------------------
+    Send a control command to the players of the room
+    ----------------------------------------------------------------*/
+    function control_player(url, data) {
+        $.ajax({
+            url: url,
+            type: "POST",
+            data: data,
+            error: function(jqXHR, textStatus, errorThrown) {
+                if(jqXHR.status == 500 || jqXHR.status == 400) {
+                    $("#alert_area").empty();
+                    $("#alert_area").append(jqXHR.responseText);
+                } else {
+                    alert("Failed to contact server");
+                }
+            },
+            success: function(data, textStatus, errorThrown) {
+                // give the players a moment to report their new progress
+                setTimeout(refresh_elements, 1500);
+            }
+        });
+    };
+
+    function seek_song(event) {
+        control_player("/seek", {
+            'seconds' : $(event.currentTarget).data("seconds"),
+            'relative' : true
+        });
+    };
+
+    function restart_song(event) {
+        control_player("/restart", {});
+    };
+
+    function mute_song(event) {
+        control_player("/mute", {});
+    };
+
+    function set_volume(event) {
+        control_player("/volume", { 'level' : $(event.currentTarget).val() });
+    };
+
+    function register_player_controls() {
+        $(".player_seek").click(seek_song);
+        $(".player_restart").click(restart_song);
+        $(".player_mute").click(mute_song);
+        $("#player_volume").change(set_volume);
+    };
+
     /*----------------------------------------------------------------
     Remove the target song from queue
     ----------------------------------------------------------------*/
@@ -113,6 +253,82 @@ $(document).ready(function(){
         });
     };
 
+    /*----------------------------------------------------------------
+    Submit the song that the user picked from the search results
+    ----------------------------------------------------------------*/
+    function pick_song(event) {
+        $.ajax({
+            url: "/new_song",
+            type: "POST",
+            data: { 'submit_box' : $(event.currentTarget).data("link") },
+            error: function(jqXHR, textStatus, errorThrown) {
+                if(jqXHR.status == 500 || jqXHR.status == 400) {
+                    $("#alert_area").empty();
+                    $("#alert_area").append(jqXHR.responseText);
+                } else {
+                    alert("Failed to contact server");
+                }
+            },
+            success: function(data, textStatus, errorThrown) {
+                $("#search_area").empty();
+                $("#submit_box").val("");
+                refresh_elements();
+            }
+        });
+    };
+
+    /*----------------------------------------------------------------
+    Move the target song up or down among the user's own songs
+    ----------------------------------------------------------------*/
+    function move_song(event) {
+        event.preventDefault();
+
+        $.ajax({
+            url: "/move",
+            type: "POST",
+            data: {
+                'song_id' : $(event.currentTarget).data("song"),
+                'offset' : $(event.currentTarget).data("offset")
+            },
+            error: function(jqXHR, textStatus, errorThrown) {
+                if(jqXHR.status == 500 || jqXHR.status == 400) {
+                    $("#alert_area").empty();
+                    $("#alert_area").append(jqXHR.responseText);
+                } else {
+                    alert("Failed to contact server");
+                }
+            },
+            success: function(data, textStatus, errorThrown) {
+                refresh_elements();
+            }
+        });
+    };
+
+    /*----------------------------------------------------------------
+    Upvote or downvote the target song in the queue
+    ----------------------------------------------------------------*/
+    function vote_song(event) {
+        $.ajax({
+            url: "/vote",
+            type: "POST",
+            data: {
+                'song_id' : $(event.currentTarget).data("song"),
+                'value' : $(event.currentTarget).data("value")
+            },
+            error: function(jqXHR, textStatus, errorThrown) {
+                if(jqXHR.status == 500 || jqXHR.status == 400) {
+                    $("#alert_area").empty();
+                    $("#alert_area").append(jqXHR.responseText);
+                } else {
+                    alert("Failed to contact server");
+                }
+            },
+            success: function(data, textStatus, errorThrown) {
+                refresh_elements();
+            }
+        });
+    };
+
     /*----------------------------------------------------------------
     Skip the currently playing song
     ----------------------------------------------------------------*/
@@ -135,12 +351,34 @@ $(document).ready(function(){
         });
     };
 
+    // Start moving the progress bar of the now playing song
+    start_playback();
+
+    // Check for failed songs now and then
+    show_failed_songs();
+    setInterval(show_failed_songs, 15000);
+
+    // Register handlers on the songs found by a search
+    $("#search_area").on("click", ".search_pick", pick_song);
+    $("#search_area").on("click", "#search_cancel", function() {
+        $("#search_area").empty();
+    });
+
     // Register handler on queue items to remove song
     $(".queue_rm").click(remove_song);
 
+    // Register handler on queue items to vote on a song
+    $(".queue_vote").click(vote_song);
+
+    // Register handler on queue items to move a song
+    $(".queue_move").click(move_song);
+
     // Register handler to skip the currently playing song
     $(".skip_now_playing").click(skip_song);
 
+    // Register handlers on the player controls of room admins
+    register_player_controls();
+
     // Register handler on the queue title to refresh items
     $("#queue_title").click(refresh_elements);
     $("#queue_title").on("tap", refresh_elements);
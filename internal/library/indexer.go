@@ -0,0 +1,268 @@
+/*
+ * Keeps a catalog of the audio files in the directories of the local music
+ * library so that users can find songs by name instead of by path
+ */
+
+package library
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+	"github.com/nguyenmq/ytbox-go/internal/provider"
+)
+
+const (
+	UnknownArtist = "Unknown Artist" // artist of the tracks without an artist tag
+	UnknownAlbum  = "Unknown Album"  // album of the tracks without an album tag
+)
+
+var errStopped = errors.New("Library scan stopped")
+
+/*
+ * Storage for the catalog of the library
+ */
+type Store interface {
+	// Get the modification time of every file in the library by path
+	GetLibraryFiles() (map[string]int64, error)
+
+	// Add a file to the library, replacing the track of the same path
+	SaveLibraryTrack(track *bepb.LibraryTrack, modTime int64) error
+
+	// Remove the files with the given paths from the library
+	RemoveLibraryTracks(paths []string) error
+}
+
+/*
+ * What a scan of the library changed
+ */
+type ScanStats struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+}
+
+/*
+ * Scans the library directories for audio files and keeps the store in step
+ * with them. Only the files that are new or were modified since the last scan
+ * are read again.
+ */
+type Indexer struct {
+	dirs     []string
+	store    Store
+	local    *provider.LocalProvider
+	scanLock sync.Mutex    // one scan at a time
+	stop     chan struct{} // closed to stop scanning
+	wg       sync.WaitGroup
+}
+
+/*
+ * Create an indexer of the audio files in the given directories
+ */
+func NewIndexer(dirs []string, store Store) *Indexer {
+	indexer := new(Indexer)
+	indexer.dirs = dirs
+	indexer.store = store
+	indexer.local = new(provider.LocalProvider)
+	indexer.stop = make(chan struct{})
+	return indexer
+}
+
+/*
+ * Scan the library now and then again after every interval. An interval of
+ * zero only scans the library once.
+ */
+func (indexer *Indexer) Start(interval time.Duration) {
+	indexer.wg.Add(1)
+	go func() {
+		defer indexer.wg.Done()
+
+		indexer.scanAndLog()
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				indexer.scanAndLog()
+			case <-indexer.stop:
+				return
+			}
+		}
+	}()
+}
+
+/*
+ * Stop scanning the library. A scan in progress is abandoned.
+ */
+func (indexer *Indexer) Stop() {
+	close(indexer.stop)
+	indexer.wg.Wait()
+}
+
+func (indexer *Indexer) scanAndLog() {
+	start := time.Now()
+	stats, err := indexer.Scan()
+	if err != nil {
+		log.Printf("Failed to scan the music library: %v", err)
+		return
+	}
+
+	log.Printf("Scanned the music library in %v: %d added, %d updated, %d removed, %d unchanged",
+		time.Since(start).Round(time.Millisecond), stats.Added, stats.Updated, stats.Removed, stats.Unchanged)
+}
+
+/*
+ * Bring the store in step with the files in the library directories. Files
+ * are read again only if their modification time changed. Tracks are removed
+ * once their files are gone, unless their directory couldn't be read.
+ */
+func (indexer *Indexer) Scan() (ScanStats, error) {
+	indexer.scanLock.Lock()
+	defer indexer.scanLock.Unlock()
+
+	var stats ScanStats
+	known, err := indexer.store.GetLibraryFiles()
+	if err != nil {
+		return stats, err
+	}
+
+	seen := make(map[string]bool)
+	var unreadable []string
+	for _, dir := range indexer.dirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			log.Printf("Failed to resolve library directory %s: %v", dir, err)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			select {
+			case <-indexer.stop:
+				return errStopped
+			default:
+			}
+
+			if err != nil {
+				log.Printf("Failed to read %s: %v", path, err)
+				unreadable = append(unreadable, path)
+				return nil
+			}
+
+			if entry.IsDir() || !indexer.local.Matches(path) {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				unreadable = append(unreadable, path)
+				return nil
+			}
+
+			seen[path] = true
+			modTime := info.ModTime().UnixNano()
+			previous, found := known[path]
+			if found && previous == modTime {
+				stats.Unchanged++
+				return nil
+			}
+
+			track, err := readTrack(path)
+			if err != nil {
+				return nil
+			}
+
+			if err = indexer.store.SaveLibraryTrack(track, modTime); err != nil {
+				return err
+			}
+
+			if found {
+				stats.Updated++
+			} else {
+				stats.Added++
+			}
+			return nil
+		})
+
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	var removed []string
+	for path := range known {
+		if !seen[path] && !isUnder(path, unreadable) {
+			removed = append(removed, path)
+		}
+	}
+
+	if len(removed) > 0 {
+		if err = indexer.store.RemoveLibraryTracks(removed); err != nil {
+			return stats, err
+		}
+	}
+	stats.Removed = len(removed)
+
+	return stats, nil
+}
+
+/*
+ * Read the track of an audio file. Tracks without tags are named after their
+ * file.
+ */
+func readTrack(path string) (*bepb.LibraryTrack, error) {
+	tags, err := provider.ReadLocalTags(path)
+	if err != nil {
+		return nil, err
+	}
+
+	track := &bepb.LibraryTrack{
+		Path:     path,
+		Artist:   tags.Artist,
+		Album:    tags.Album,
+		Title:    tags.Title,
+		Duration: tags.Duration,
+	}
+
+	if tags.Track > 0 {
+		track.Track = uint32(tags.Track)
+	}
+
+	if track.Artist == "" {
+		track.Artist = UnknownArtist
+	}
+
+	if track.Album == "" {
+		track.Album = UnknownAlbum
+	}
+
+	if track.Title == "" {
+		track.Title = provider.FileTitle(path)
+	}
+
+	return track, nil
+}
+
+/*
+ * Returns true if the path is one of the given paths or inside one of them
+ */
+func isUnder(path string, parents []string) bool {
+	for _, parent := range parents {
+		if path == parent || strings.HasPrefix(path, parent+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/internal/proto/backend"
+)
+
+/*
+ * Keeps the library in memory and counts the tracks saved to it
+ */
+type testStore struct {
+	tracks   map[string]*bepb.LibraryTrack
+	modTimes map[string]int64
+	saves    int
+}
+
+func newTestStore() *testStore {
+	return &testStore{tracks: make(map[string]*bepb.LibraryTrack), modTimes: make(map[string]int64)}
+}
+
+func (store *testStore) GetLibraryFiles() (map[string]int64, error) {
+	files := make(map[string]int64)
+	for path, modTime := range store.modTimes {
+		files[path] = modTime
+	}
+
+	return files, nil
+}
+
+func (store *testStore) SaveLibraryTrack(track *bepb.LibraryTrack, modTime int64) error {
+	store.tracks[track.Path] = track
+	store.modTimes[track.Path] = modTime
+	store.saves++
+	return nil
+}
+
+func (store *testStore) RemoveLibraryTracks(paths []string) error {
+	for _, path := range paths {
+		delete(store.tracks, path)
+		delete(store.modTimes, path)
+	}
+
+	return nil
+}
+
+func writeTestFile(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal("Failed to create directory:", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not really audio"), 0644); err != nil {
+		t.Fatal("Failed to write file:", err)
+	}
+}
+
+/*
+ * Only new and modified files should be read again, and tracks of deleted
+ * files should be removed
+ */
+func TestScan_onlyReadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	song := filepath.Join(dir, "Some Song.mp3")
+	other := filepath.Join(dir, "album", "Other Song.OGG")
+	writeTestFile(t, song)
+	writeTestFile(t, other)
+	writeTestFile(t, filepath.Join(dir, "cover.jpg"))
+
+	store := newTestStore()
+	indexer := NewIndexer([]string{dir}, store)
+
+	stats, err := indexer.Scan()
+	if err != nil || stats.Added != 2 || len(store.tracks) != 2 {
+		t.Fatal("Expected the 2 audio files to be added but got", stats, err)
+	}
+
+	track := store.tracks[song]
+	if track.Title != "Some Song" || track.Artist != UnknownArtist || track.Album != UnknownAlbum {
+		t.Error("Expected an untagged file to be named after the file but got", track)
+	}
+
+	stats, err = indexer.Scan()
+	if err != nil || stats.Unchanged != 2 || store.saves != 2 {
+		t.Error("Expected unchanged files not to be read again but got", stats, err)
+	}
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(song, later, later)
+	os.Remove(other)
+
+	stats, err = indexer.Scan()
+	if err != nil || stats.Updated != 1 || stats.Removed != 1 || stats.Unchanged != 0 {
+		t.Error("Expected 1 updated and 1 removed file but got", stats, err)
+	}
+
+	if _, found := store.tracks[other]; found || len(store.tracks) != 1 {
+		t.Error("Expected the deleted file to be removed but got", store.tracks)
+	}
+}
+
+/*
+ * A library directory that can't be read, like an unmounted drive, should
+ * keep its tracks
+ */
+func TestScan_whenDirectoryMissing_keepsItsTracks(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "unmounted")
+	store := newTestStore()
+	store.SaveLibraryTrack(&bepb.LibraryTrack{Path: filepath.Join(missing, "song.flac")}, 1)
+
+	stats, err := NewIndexer([]string{missing}, store).Scan()
+	if err != nil || stats.Removed != 0 || len(store.tracks) != 1 {
+		t.Error("Expected the tracks of the missing directory to be kept but got", stats, err)
+	}
+}
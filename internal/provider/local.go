@@ -0,0 +1,119 @@
+/*
+ * Songs from audio files on the backend's machine
+ */
+
+package provider
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dhowden/tag"
+
+	"github.com/nguyenmq/ytbox-go/internal/common"
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+// match absolute paths to mp3, flac, ogg or m4a files
+var validFile = regexp.MustCompile(`(?i)(^\/).*\.(mp3|flac|ogg|m4a)$`)
+
+/*
+ * The tags and length of a local audio file. Tags missing from the file are
+ * left empty.
+ */
+type LocalTags struct {
+	Artist   string
+	Album    string
+	Title    string
+	Track    int
+	Duration uint32 // seconds, zero if the length couldn't be read
+}
+
+/*
+ * Reads songs out of local mp3, flac, ogg and m4a files. The players play the
+ * files from the same path, so they must run on the backend's machine or share
+ * its files.
+ */
+type LocalProvider struct{}
+
+func (provider *LocalProvider) Service() cmpb.ServiceType {
+	return cmpb.ServiceType_Local
+}
+
+func (provider *LocalProvider) Matches(link string) bool {
+	return validFile.MatchString(link)
+}
+
+/*
+ * Read the metadata out of a local audio file. Files without a title tag are
+ * named after the file.
+ */
+func (provider *LocalProvider) Fetch(link string, song *cmpb.Song) error {
+	tags, err := ReadLocalTags(link)
+	if err != nil {
+		return err
+	}
+
+	title := tags.Title
+	if title == "" {
+		title = FileTitle(link)
+	}
+
+	song.Title = title
+	if tags.Artist != "" {
+		song.Title = fmt.Sprintf("%s - %s", tags.Artist, title)
+	}
+	song.Duration = tags.Duration
+	song.ServiceId = link
+	song.Service = cmpb.ServiceType_Local
+
+	return nil
+}
+
+func (provider *LocalProvider) PlayableUri(song *cmpb.Song) (string, error) {
+	return song.GetServiceId(), nil
+}
+
+/*
+ * Read the tags and length of a local audio file. A file without tags isn't an
+ * error, only a file that can't be opened is.
+ */
+func ReadLocalTags(path string) (*LocalTags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to read file %s: %v", path, err)
+		return nil, err
+	}
+	defer file.Close()
+
+	tags := new(LocalTags)
+	if metadata, err := tag.ReadFrom(file); err != nil {
+		log.Printf("Failed to parse tags of %s: %v", path, err)
+	} else {
+		tags.Artist = strings.TrimSpace(metadata.Artist())
+		tags.Album = strings.TrimSpace(metadata.Album())
+		tags.Title = strings.TrimSpace(metadata.Title())
+		tags.Track, _ = metadata.Track()
+	}
+
+	duration, err := common.ReadDuration(file)
+	if err != nil {
+		log.Printf("Failed to read duration of %s: %v", path, err)
+	} else {
+		tags.Duration = uint32(duration.Seconds())
+	}
+
+	return tags, nil
+}
+
+/*
+ * Returns the name of the file without its directory and extension
+ */
+func FileTitle(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
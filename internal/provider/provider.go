@@ -0,0 +1,175 @@
+/*
+ * Providers are the sources that songs come from. Each provider knows which
+ * submitted links belong to it, how to fetch the data of a song from one of
+ * its links and how to build a link that a player can play the song from. The
+ * backend and the players share the same registry of providers.
+ */
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+/*
+ * A source of songs
+ */
+type Provider interface {
+	// Service type of the songs that come from the provider
+	Service() cmpb.ServiceType
+
+	// True if the submitted link belongs to the provider
+	Matches(link string) bool
+
+	// Fill in the title, service id and other data of the song behind the link
+	Fetch(link string, song *cmpb.Song) error
+
+	// Link or path that a player can play the song from
+	PlayableUri(song *cmpb.Song) (string, error)
+}
+
+/*
+ * A provider whose links can point at playlists of songs
+ */
+type PlaylistProvider interface {
+	Provider
+
+	// True if the link points at a playlist
+	IsPlaylist(link string) bool
+
+	// Links to at most limit songs of the playlist, in playlist order
+	ExpandPlaylist(link string, limit int) ([]string, error)
+}
+
+/*
+ * A provider that can look up songs by text instead of a link
+ */
+type SearchProvider interface {
+	Provider
+
+	// At most limit songs matching the text, best match first. The songs are
+	// filled in as if they were fetched from their links.
+	Search(text string, limit int) ([]*cmpb.Song, error)
+}
+
+/*
+ * The providers that songs can come from. Links are matched against the
+ * providers in the order they were registered.
+ */
+type Registry struct {
+	providers []Provider
+}
+
+/*
+ * Create a registry of every provider. The YouTube api key is optional, songs
+ * are looked up with yt-dlp without it.
+ */
+func NewRegistry(ytApiKey string) *Registry {
+	registry := new(Registry)
+	registry.Register(NewYoutubeProvider(ytApiKey))
+	registry.Register(new(LocalProvider))
+	registry.Register(new(UrlProvider))
+	return registry
+}
+
+/*
+ * Add a provider to the registry. It's matched against links after the
+ * providers registered before it.
+ */
+func (registry *Registry) Register(provider Provider) {
+	registry.providers = append(registry.providers, provider)
+}
+
+/*
+ * Returns the first provider that the link belongs to
+ */
+func (registry *Registry) Match(link string) (Provider, bool) {
+	for _, provider := range registry.providers {
+		if provider.Matches(link) {
+			return provider, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+ * Returns the provider of the given service type
+ */
+func (registry *Registry) ForService(service cmpb.ServiceType) (Provider, bool) {
+	for _, provider := range registry.providers {
+		if provider.Service() == service {
+			return provider, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+ * Fetch the data of the song behind the link from the provider that the link
+ * belongs to
+ */
+func (registry *Registry) Fetch(link string, song *cmpb.Song) error {
+	provider, ok := registry.Match(link)
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown link submitted: %s", link))
+	}
+
+	return provider.Fetch(link, song)
+}
+
+/*
+ * Returns the link or path that a player can play the song from
+ */
+func (registry *Registry) PlayableUri(song *cmpb.Song) (string, error) {
+	provider, ok := registry.ForService(song.GetService())
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Unsupported service %v of song %s", song.GetService(), song.GetServiceId()))
+	}
+
+	return provider.PlayableUri(song)
+}
+
+/*
+ * Returns true if the link points at a playlist that can be expanded into its
+ * songs
+ */
+func (registry *Registry) IsPlaylist(link string) bool {
+	provider, ok := registry.Match(link)
+	if !ok {
+		return false
+	}
+
+	playlists, ok := provider.(PlaylistProvider)
+	return ok && playlists.IsPlaylist(link)
+}
+
+/*
+ * Returns links to at most limit songs of the playlist behind the link
+ */
+func (registry *Registry) ExpandPlaylist(link string, limit int) ([]string, error) {
+	provider, _ := registry.Match(link)
+	playlists, ok := provider.(PlaylistProvider)
+	if !ok || !playlists.IsPlaylist(link) {
+		return nil, errors.New(fmt.Sprintf("Not a playlist: %s", link))
+	}
+
+	return playlists.ExpandPlaylist(link, limit)
+}
+
+/*
+ * Search for songs matching the text with the first provider that can search
+ */
+func (registry *Registry) Search(text string, limit int) ([]*cmpb.Song, error) {
+	for _, provider := range registry.providers {
+		if searcher, ok := provider.(SearchProvider); ok {
+			return searcher.Search(text, limit)
+		}
+	}
+
+	return nil, errors.New("No provider can search for songs")
+}
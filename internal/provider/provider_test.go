@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+func TestMatch_picksProviderOfLink(t *testing.T) {
+	registry := NewRegistry("")
+
+	provider, ok := registry.Match("https://youtu.be/ed0CcFcBBMI")
+	if !ok || provider.Service() != cmpb.ServiceType_Youtube {
+		t.Errorf("Expected a YouTube link to match the YouTube provider but got %v", provider)
+	}
+
+	provider, ok = registry.Match("/music/song.flac")
+	if !ok || provider.Service() != cmpb.ServiceType_Local {
+		t.Errorf("Expected a path to match the local provider but got %v", provider)
+	}
+
+	provider, ok = registry.Match("https://soundcloud.com/artist/song")
+	if !ok || provider.Service() != cmpb.ServiceType_Url {
+		t.Errorf("Expected any other web link to match the url provider but got %v", provider)
+	}
+
+	if provider, ok = registry.Match("song.mp3"); ok {
+		t.Errorf("Expected an unknown link to match no provider but got %v", provider)
+	}
+}
+
+func TestFetch_whenUnknownLink_fails(t *testing.T) {
+	registry := NewRegistry("")
+
+	if err := registry.Fetch("song.mp3", new(cmpb.Song)); err == nil {
+		t.Error("Expected fetching an unknown link to fail")
+	}
+}
+
+func TestPlayableUri_buildsLinkForService(t *testing.T) {
+	registry := NewRegistry("")
+
+	uri, err := registry.PlayableUri(&cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "ed0CcFcBBMI"})
+	if err != nil || uri != "https://www.youtube.com/watch?v=ed0CcFcBBMI" {
+		t.Errorf("Expected a YouTube watch link but got %s, %v", uri, err)
+	}
+
+	uri, err = registry.PlayableUri(&cmpb.Song{Service: cmpb.ServiceType_Local, ServiceId: "/music/song.flac"})
+	if err != nil || uri != "/music/song.flac" {
+		t.Errorf("Expected the path of the local file but got %s, %v", uri, err)
+	}
+
+	if uri, err = registry.PlayableUri(&cmpb.Song{Service: cmpb.ServiceType_None}); err == nil {
+		t.Errorf("Expected a song without a service to have no playable link but got %s", uri)
+	}
+}
+
+func TestIsPlaylist_onlyForPlaylistLinks(t *testing.T) {
+	registry := NewRegistry("")
+
+	if !registry.IsPlaylist("https://www.youtube.com/playlist?list=PLabc") {
+		t.Error("Expected a YouTube playlist link to be a playlist")
+	}
+
+	if registry.IsPlaylist("https://www.youtube.com/watch?v=SilKjJ0S904") {
+		t.Error("Expected a YouTube video link not to be a playlist")
+	}
+
+	if registry.IsPlaylist("https://soundcloud.com/some-artist/sets/some-set") {
+		t.Error("Expected links of providers without playlists not to be playlists")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Songs from any other site that yt-dlp supports, like SoundCloud, Bandcamp
+ * and Vimeo
+ */
+
+package provider
+
+import (
+	"regexp"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+// match web links
+var validUrl = regexp.MustCompile(`^https?://\S+$`)
+
+/*
+ * Takes any web link and asks yt-dlp for the song behind it. It matches every
+ * web link, so it should be registered after the providers of specific sites.
+ */
+type UrlProvider struct{}
+
+func (provider *UrlProvider) Service() cmpb.ServiceType {
+	return cmpb.ServiceType_Url
+}
+
+func (provider *UrlProvider) Matches(link string) bool {
+	return validUrl.MatchString(link)
+}
+
+/*
+ * Fetch song data from the json that yt-dlp dumps for the link
+ */
+func (provider *UrlProvider) Fetch(link string, song *cmpb.Song) error {
+	info, err := dumpJson(link)
+	if err != nil {
+		return err
+	}
+
+	song.Title = info.Title
+	song.Duration = uint32(info.Duration)
+	song.ServiceId = link
+	if info.WebpageUrl != "" {
+		song.ServiceId = info.WebpageUrl
+	}
+	song.Service = cmpb.ServiceType_Url
+	song.Metadata = &cmpb.Metadata{
+		Thumbnail: info.Thumbnail,
+		Uploader:  info.channel(),
+	}
+
+	return nil
+}
+
+func (provider *UrlProvider) PlayableUri(song *cmpb.Song) (string, error) {
+	return song.GetServiceId(), nil
+}
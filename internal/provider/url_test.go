@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+const testDumpJson = `{"id": "123", "title": " Song Title ", "duration": 215.4,
+"thumbnail": "https://example.com/thumb.jpg", "uploader": "Some Artist",
+"webpage_url": "https://soundcloud.com/some-artist/song-title"}`
+
+/*
+ * Puts a stub yt-dlp script on the path that prints the given output and
+ * exits with the given status
+ */
+func stubYtDlp(t *testing.T, output string, status int) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat <<'EOF'\n" + output + "\nEOF\nexit " + strconv.Itoa(status) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "yt-dlp"), []byte(script), 0755); err != nil {
+		t.Fatal("Failed to write stub yt-dlp:", err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestUrlFetch_fillsSongFromDumpJson(t *testing.T) {
+	stubYtDlp(t, testDumpJson, 0)
+
+	song := new(cmpb.Song)
+	err := new(UrlProvider).Fetch("https://soundcloud.com/some-artist/song-title?si=abc", song)
+	if err != nil {
+		t.Fatal("Fetch failed with error:", err)
+	}
+
+	if song.Title != "Song Title" || song.Duration != 215 {
+		t.Errorf("Expected title and duration from yt-dlp but got %s, %d", song.Title, song.Duration)
+	}
+
+	if song.Service != cmpb.ServiceType_Url || song.ServiceId != "https://soundcloud.com/some-artist/song-title" {
+		t.Errorf("Expected the song to be played from its page but got %v, %s", song.Service, song.ServiceId)
+	}
+
+	if song.Metadata.Thumbnail != "https://example.com/thumb.jpg" || song.Metadata.Uploader != "Some Artist" {
+		t.Errorf("Expected thumbnail and uploader from yt-dlp but got %v", song.Metadata)
+	}
+}
+
+func TestUrlFetch_whenYtDlpFails_fails(t *testing.T) {
+	stubYtDlp(t, "ERROR: Unsupported URL", 1)
+
+	if err := new(UrlProvider).Fetch("https://example.com/page", new(cmpb.Song)); err == nil {
+		t.Error("Expected fetch to fail when yt-dlp fails")
+	}
+}
+
+func TestParseDumpJson_whenNoTitle_fails(t *testing.T) {
+	if _, err := parseDumpJson([]byte(`{"duration": 30}`)); err == nil {
+		t.Error("Expected a song without a title to be rejected")
+	}
+}
@@ -0,0 +1,436 @@
+/*
+ * Songs from YouTube
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rickb777/date/period"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+var (
+	// match youtube links
+	validYt = regexp.MustCompile(`^(https?://)?(www\.)?(m\.)?(youtube\.com|youtu\.be)(\S+)$`)
+
+	// match the full length youtube url
+	fullYoutubeLink = regexp.MustCompile(`^(https?://)?(www\.)?(m\.)?youtube\.com/watch(\S+)$`)
+
+	// match the shortened youtube url
+	shortYoutubeLink = regexp.MustCompile(`^(https?://)?(www\.)?youtu\.be/(\S+)$`)
+
+	// full length youtube url uses a query parameter
+	videoQueryParam = regexp.MustCompile(`v=[A-Za-z0-9_\-]+`)
+
+	// shortened youtube url uses a path parameter
+	videoPathParam = regexp.MustCompile(`be/[A-Za-z0-9_\-]+`)
+
+	// playlists are given by the list query parameter
+	playlistQueryParam = regexp.MustCompile(`[?&]list=([A-Za-z0-9_\-]+)`)
+
+	// a video id as printed by yt-dlp
+	validVideoId = regexp.MustCompile(`^[A-Za-z0-9_\-]{11}$`)
+)
+
+const (
+	youtubeWatchLink = "https://www.youtube.com/watch?v=%s"
+	youtubeThumbnail = "https://i.ytimg.com/vi/%s/mqdefault.jpg"
+	maxPlaylistPage  = 50 // most playlist items the YouTube api returns at once
+)
+
+/*
+ * Fetches YouTube videos from the YouTube api, or with yt-dlp when there is no
+ * api key
+ */
+type YoutubeProvider struct {
+	ytService *youtube.Service
+}
+
+/*
+ * Create the YouTube provider. The api key may be empty to use yt-dlp instead.
+ */
+func NewYoutubeProvider(apiKey string) *YoutubeProvider {
+	provider := new(YoutubeProvider)
+	if apiKey != "" {
+		provider.ytService, _ = youtube.NewService(context.Background(), option.WithAPIKey(apiKey))
+	}
+
+	return provider
+}
+
+func (provider *YoutubeProvider) Service() cmpb.ServiceType {
+	return cmpb.ServiceType_Youtube
+}
+
+func (provider *YoutubeProvider) Matches(link string) bool {
+	return validYt.MatchString(link)
+}
+
+func (provider *YoutubeProvider) Fetch(link string, song *cmpb.Song) error {
+	if provider.ytService == nil {
+		return provider.fetchYoutubeDlp(link, song)
+	}
+
+	return provider.fetchYoutubeSongData(link, song)
+}
+
+func (provider *YoutubeProvider) PlayableUri(song *cmpb.Song) (string, error) {
+	return fmt.Sprintf(youtubeWatchLink, song.GetServiceId()), nil
+}
+
+func (provider *YoutubeProvider) IsPlaylist(link string) bool {
+	return extractPlaylistId(link) != ""
+}
+
+/*
+ * Returns links to the videos of the playlist, from the YouTube api or with
+ * yt-dlp when there is no api key
+ */
+func (provider *YoutubeProvider) ExpandPlaylist(link string, limit int) ([]string, error) {
+	playlistId := extractPlaylistId(link)
+	if playlistId == "" {
+		log.Printf("Failed to extract playlist id from link: %s\n", link)
+		return nil, errors.New("Failed to extract playlist id")
+	}
+
+	var videoIds []string
+	var err error
+	if provider.ytService == nil {
+		videoIds, err = expandPlaylistDlp(link, limit)
+	} else {
+		videoIds, err = provider.expandPlaylistItems(playlistId, limit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]string, 0, len(videoIds))
+	for _, videoId := range videoIds {
+		links = append(links, fmt.Sprintf(youtubeWatchLink, videoId))
+	}
+
+	return links, nil
+}
+
+func extractVideoId(link string) string {
+	if fullYoutubeLink.MatchString(link) {
+		return strings.TrimPrefix(videoQueryParam.FindString(link), "v=")
+	} else if shortYoutubeLink.MatchString(link) {
+		return strings.TrimPrefix(videoPathParam.FindString(link), "be/")
+	} else {
+		return ""
+	}
+}
+
+/*
+ * Search YouTube for videos matching the text, with the YouTube api or with
+ * yt-dlp when there is no api key
+ */
+func (provider *YoutubeProvider) Search(text string, limit int) ([]*cmpb.Song, error) {
+	if provider.ytService == nil {
+		return searchYoutubeDlp(text, limit)
+	}
+
+	return provider.searchYoutubeApi(text, limit)
+}
+
+func extractPlaylistId(link string) string {
+	if !validYt.MatchString(link) {
+		return ""
+	}
+
+	if match := playlistQueryParam.FindStringSubmatch(link); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+/*
+ * Fetch song data from the json that yt-dlp dumps for the video. It fills in
+ * the same fields as the YouTube api does.
+ */
+func (provider *YoutubeProvider) fetchYoutubeDlp(link string, song *cmpb.Song) error {
+	songId := extractVideoId(link)
+	if len(songId) == 0 {
+		log.Printf("Failed to extract id from link: %s\n", link)
+		return errors.New("Failed to extract song id")
+	}
+
+	info, err := dumpJson(link)
+	if err != nil {
+		return err
+	}
+
+	thumbnail := info.Thumbnail
+	if thumbnail == "" {
+		thumbnail = fmt.Sprintf(youtubeThumbnail, songId)
+	}
+
+	song.Title = info.Title
+	song.Duration = uint32(info.Duration)
+	song.ServiceId = songId
+	song.Service = cmpb.ServiceType_Youtube
+	song.Metadata = &cmpb.Metadata{
+		Thumbnail: thumbnail,
+		Duration:  formatIsoDuration(song.Duration),
+		Uploader:  info.channel(),
+	}
+
+	return nil
+}
+
+/*
+ * Fetch song data for the given link. This includes the song title, service
+ * id, and service type. Populates the Song structure with the song data it
+ * retrieves. Returns an error status.
+ */
+func (provider *YoutubeProvider) fetchYoutubeSongData(link string, song *cmpb.Song) error {
+	songId := extractVideoId(link)
+	if len(songId) == 0 {
+		log.Printf("Failed to extract id from link: %s\n", link)
+		return errors.New("Failed to extract song id")
+	}
+
+	args := []string{"snippet", "contentDetails"}
+	request := provider.ytService.Videos.List(args)
+	request.Id(songId)
+	response, err := request.Do()
+
+	if err != nil {
+		log.Printf("Failed to fetch song data for %s with error: %s\n", songId, err.Error())
+		return errors.New("Failed to fetch song metadata")
+	}
+
+	if len(response.Items) > 0 {
+		item := response.Items[0]
+		switch item.Snippet.LiveBroadcastContent {
+		case "live":
+			log.Printf("Refused %s: %v", link, ErrLiveStream)
+			return ErrLiveStream
+
+		case "upcoming":
+			log.Printf("Refused %s: %v", link, ErrUpcomingStream)
+			return ErrUpcomingStream
+		}
+
+		song.Title = item.Snippet.Title
+		song.ServiceId = songId
+		song.Service = cmpb.ServiceType_Youtube
+		song.Metadata = &cmpb.Metadata{
+			Thumbnail: fmt.Sprintf(youtubeThumbnail, songId),
+			Duration:  item.ContentDetails.Duration,
+			Uploader:  item.Snippet.ChannelTitle,
+		}
+		song.Duration = parseIsoDuration(item.ContentDetails.Duration)
+
+		return nil
+	}
+
+	log.Printf("Did not get proper metadata from youtube: %v", response)
+	return errors.New("Failed to fetch song metadata")
+}
+
+/*
+ * Converts an ISO-8601 duration, as used by the YouTube api, into seconds.
+ * Zero is returned if the duration can't be parsed.
+ */
+func parseIsoDuration(duration string) uint32 {
+	parsed, err := period.Parse(duration)
+	if err != nil {
+		log.Printf("Failed to parse duration %s: %v", duration, err)
+		return 0
+	}
+
+	return uint32(parsed.DurationApprox().Seconds())
+}
+
+/*
+ * Converts seconds into an ISO-8601 duration like the ones from the YouTube
+ * api. An empty string is returned for an unknown duration of zero.
+ */
+func formatIsoDuration(seconds uint32) string {
+	if seconds == 0 {
+		return ""
+	}
+
+	total := int(seconds)
+	return period.NewHMS(total/3600, total/60%60, total%60).String()
+}
+
+/*
+ * Fetch the ids of the first videos of the playlist from the YouTube api
+ */
+func (provider *YoutubeProvider) expandPlaylistItems(playlistId string, limit int) ([]string, error) {
+	var videoIds []string
+	request := provider.ytService.PlaylistItems.List([]string{"contentDetails"})
+	request.PlaylistId(playlistId)
+
+	for len(videoIds) < limit {
+		pageSize := limit - len(videoIds)
+		if pageSize > maxPlaylistPage {
+			pageSize = maxPlaylistPage
+		}
+
+		response, err := request.MaxResults(int64(pageSize)).Do()
+		if err != nil {
+			log.Printf("Failed to fetch playlist %s with error: %s\n", playlistId, err.Error())
+			return nil, errors.New("Failed to fetch playlist")
+		}
+
+		for _, item := range response.Items {
+			videoIds = append(videoIds, item.ContentDetails.VideoId)
+		}
+
+		if response.NextPageToken == "" {
+			break
+		}
+		request.PageToken(response.NextPageToken)
+	}
+
+	if len(videoIds) > limit {
+		videoIds = videoIds[:limit]
+	}
+
+	return videoIds, nil
+}
+
+/*
+ * List the ids of the first videos of the playlist with yt-dlp, without
+ * looking up each video
+ */
+func expandPlaylistDlp(link string, limit int) ([]string, error) {
+	output, err := exec.Command("yt-dlp", "--flat-playlist", "--yes-playlist",
+		"--playlist-end", strconv.Itoa(limit), "--print", "id", "--", link).Output()
+	if err != nil {
+		log.Printf("Failed to run yt-dlp on playlist %s with error: %v", link, err)
+		return nil, errors.New("Failed to fetch playlist")
+	}
+
+	var videoIds []string
+	for _, line := range strings.Split(string(output), "\n") {
+		videoId := strings.TrimSpace(line)
+		if validVideoId.MatchString(videoId) && len(videoIds) < limit {
+			videoIds = append(videoIds, videoId)
+		}
+	}
+
+	return videoIds, nil
+}
+
+/*
+ * Search for videos with the YouTube api. Search results don't have durations,
+ * so they're looked up for all the videos at once afterwards.
+ */
+func (provider *YoutubeProvider) searchYoutubeApi(text string, limit int) ([]*cmpb.Song, error) {
+	request := provider.ytService.Search.List([]string{"snippet"})
+	request.Q(text).Type("video").MaxResults(int64(limit))
+	response, err := request.Do()
+	if err != nil {
+		log.Printf("Failed to search for %q with error: %s\n", text, err.Error())
+		return nil, errors.New("Failed to search for songs")
+	}
+
+	var songs []*cmpb.Song
+	var videoIds []string
+	for _, item := range response.Items {
+		if item.Id == nil || item.Id.VideoId == "" || item.Snippet.LiveBroadcastContent != "none" {
+			continue
+		}
+
+		thumbnail := fmt.Sprintf(youtubeThumbnail, item.Id.VideoId)
+		if item.Snippet.Thumbnails != nil && item.Snippet.Thumbnails.Medium != nil {
+			thumbnail = item.Snippet.Thumbnails.Medium.Url
+		}
+
+		videoIds = append(videoIds, item.Id.VideoId)
+		songs = append(songs, &cmpb.Song{
+			Title:     html.UnescapeString(item.Snippet.Title),
+			ServiceId: item.Id.VideoId,
+			Service:   cmpb.ServiceType_Youtube,
+			Metadata: &cmpb.Metadata{
+				Thumbnail: thumbnail,
+				Uploader:  html.UnescapeString(item.Snippet.ChannelTitle),
+			},
+		})
+	}
+
+	if len(videoIds) == 0 {
+		return songs, nil
+	}
+
+	details, err := provider.ytService.Videos.List([]string{"contentDetails"}).Id(videoIds...).Do()
+	if err != nil {
+		log.Printf("Failed to fetch durations of search results with error: %s\n", err.Error())
+		return songs, nil
+	}
+
+	durations := make(map[string]string)
+	for _, item := range details.Items {
+		durations[item.Id] = item.ContentDetails.Duration
+	}
+
+	for _, song := range songs {
+		song.Metadata.Duration = durations[song.ServiceId]
+		song.Duration = parseIsoDuration(song.Metadata.Duration)
+	}
+
+	return songs, nil
+}
+
+/*
+ * Search for videos with yt-dlp. Live streams are left out of the results.
+ */
+func searchYoutubeDlp(text string, limit int) ([]*cmpb.Song, error) {
+	query := fmt.Sprintf("ytsearch%d:%s", limit, text)
+	output, err := exec.Command("yt-dlp", "--flat-playlist", "--dump-json", "--", query).Output()
+	if err != nil {
+		log.Printf("Failed to run yt-dlp search for %q with error: %v", text, err)
+		return nil, errors.New("Failed to search for songs")
+	}
+
+	var songs []*cmpb.Song
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		info, err := parseDumpJson([]byte(line))
+		if err != nil || !validVideoId.MatchString(info.Id) || info.checkLive() != nil {
+			continue
+		}
+
+		thumbnail := info.Thumbnail
+		if thumbnail == "" {
+			thumbnail = fmt.Sprintf(youtubeThumbnail, info.Id)
+		}
+
+		songs = append(songs, &cmpb.Song{
+			Title:     info.Title,
+			Duration:  uint32(info.Duration),
+			ServiceId: info.Id,
+			Service:   cmpb.ServiceType_Youtube,
+			Metadata: &cmpb.Metadata{
+				Thumbnail: thumbnail,
+				Duration:  formatIsoDuration(uint32(info.Duration)),
+				Uploader:  info.channel(),
+			},
+		})
+	}
+
+	return songs, nil
+}
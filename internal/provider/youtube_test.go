@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/internal/proto/common"
+)
+
+var testLinks = []string{
+	"https://www.youtube.com/watch?v=SilKjJ0S904",
+	"https://www.youtube.com/watch?v=aatr_2MstrI",
+	"https://www.youtube.com/watch?v=lMinM-FphYQ",
+	"https://www.youtube.com/watch?v=cHkDZ1ekB9U&list=RDcHkDZ1ekB9U&start_radio=1",
+	"https://www.youtube.com/watch?v=vjAxLbmy83E",
+	"https://www.youtube.com/watch?v=_sV0S8qWSy0",
+	"https://youtu.be/ed0CcFcBBMI",
+	"https://youtu.be/bL_NcoCJgzo",
+	"https://youtu.be/A1oxh8Z-2ko",
+	"https://m.youtube.com/watch?v=VQa9Q5_Dcck",
+	"https://google.com",
+}
+
+var expectedIds = []string{
+	"SilKjJ0S904",
+	"aatr_2MstrI",
+	"lMinM-FphYQ",
+	"cHkDZ1ekB9U",
+	"vjAxLbmy83E",
+	"_sV0S8qWSy0",
+	"ed0CcFcBBMI",
+	"bL_NcoCJgzo",
+	"A1oxh8Z-2ko",
+	"VQa9Q5_Dcck",
+	"",
+}
+
+func TestExtractVideoId_when_success(t *testing.T) {
+	for index, link := range testLinks {
+		result := extractVideoId(link)
+
+		if result != expectedIds[index] {
+			t.Errorf("Expected id %s should match actual id %s\n", expectedIds[index], result)
+		}
+	}
+}
+
+func TestParseIsoDuration_when_success(t *testing.T) {
+	if seconds := parseIsoDuration("PT4M13S"); seconds != 253 {
+		t.Errorf("Expected 253 seconds but got %d\n", seconds)
+	}
+
+	if seconds := parseIsoDuration("PT1H2M"); seconds != 3720 {
+		t.Errorf("Expected 3720 seconds but got %d\n", seconds)
+	}
+}
+
+func TestFormatIsoDuration_when_success(t *testing.T) {
+	if duration := formatIsoDuration(253); duration != "PT4M13S" {
+		t.Error("Expected PT4M13S but got", duration)
+	}
+
+	if duration := formatIsoDuration(3720); duration != "PT1H2M" || parseIsoDuration(duration) != 3720 {
+		t.Error("Expected PT1H2M but got", duration)
+	}
+
+	if duration := formatIsoDuration(0); duration != "" {
+		t.Error("Expected no duration but got", duration)
+	}
+}
+
+func TestParseIsoDuration_when_invalid(t *testing.T) {
+	if seconds := parseIsoDuration("four minutes"); seconds != 0 {
+		t.Errorf("Expected 0 seconds but got %d\n", seconds)
+	}
+}
+
+func TestYoutubeDlpFetch_fillsSongFromDumpJson(t *testing.T) {
+	stubYtDlp(t, `{"id": "dQw4w9WgXcQ", "title": "Song Title", "duration": 212,
+"thumbnail": "https://i.ytimg.com/vi_webp/dQw4w9WgXcQ/maxresdefault.webp",
+"uploader": "Some Uploader", "channel": "Some Channel", "live_status": "not_live"}`, 0)
+
+	song := new(cmpb.Song)
+	if err := NewYoutubeProvider("").Fetch("https://www.youtube.com/watch?v=dQw4w9WgXcQ", song); err != nil {
+		t.Fatal("Fetch failed with error:", err)
+	}
+
+	if song.Title != "Song Title" || song.Duration != 212 || song.ServiceId != "dQw4w9WgXcQ" {
+		t.Errorf("Expected title, duration and id from yt-dlp but got %s, %d, %s", song.Title, song.Duration, song.ServiceId)
+	}
+
+	if song.Metadata.Duration != "PT3M32S" {
+		t.Error("Expected the duration in the metadata but got", song.Metadata.Duration)
+	}
+
+	if song.Metadata.Thumbnail != "https://i.ytimg.com/vi_webp/dQw4w9WgXcQ/maxresdefault.webp" || song.Metadata.Uploader != "Some Channel" {
+		t.Errorf("Expected thumbnail and channel from yt-dlp but got %v", song.Metadata)
+	}
+}
+
+func TestYoutubeDlpFetch_whenLive_refusesSong(t *testing.T) {
+	stubYtDlp(t, `{"title": "Live Radio", "is_live": true, "live_status": "is_live"}`, 0)
+
+	err := NewYoutubeProvider("").Fetch("https://youtu.be/dQw4w9WgXcQ", new(cmpb.Song))
+	if err != ErrLiveStream {
+		t.Errorf("Expected live stream to be refused but got %v", err)
+	}
+}
+
+func TestYoutubeDlpFetch_whenUpcoming_refusesSong(t *testing.T) {
+	stubYtDlp(t, `{"title": "Premiere", "live_status": "is_upcoming"}`, 0)
+
+	err := NewYoutubeProvider("").Fetch("https://youtu.be/dQw4w9WgXcQ", new(cmpb.Song))
+	if err != ErrUpcomingStream {
+		t.Errorf("Expected upcoming stream to be refused but got %v", err)
+	}
+}
+
+func TestExtractPlaylistId_when_success(t *testing.T) {
+	links := map[string]string{
+		"https://www.youtube.com/playlist?list=PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG":           "PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG",
+		"https://www.youtube.com/watch?v=cHkDZ1ekB9U&list=RDcHkDZ1ekB9U&start_radio=1":       "RDcHkDZ1ekB9U",
+		"https://youtu.be/SilKjJ0S904?list=PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG":               "PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG",
+		"https://www.youtube.com/watch?v=SilKjJ0S904":                                        "",
+		"https://soundcloud.com/some-artist/sets/some-set?list=PLx0sYbCqOb8TBPRdmBHs5Iftvv9": "",
+	}
+
+	for link, expected := range links {
+		if playlistId := extractPlaylistId(link); playlistId != expected {
+			t.Errorf("Expected playlist id %q from %s but got %q\n", expected, link, playlistId)
+		}
+	}
+}
+
+func TestYoutubeDlpExpandPlaylist_linksFirstVideos(t *testing.T) {
+	stubYtDlp(t, "SilKjJ0S904\nNA\naatr_2MstrI\nlMinM-FphYQ", 0)
+
+	links, err := NewYoutubeProvider("").ExpandPlaylist("https://www.youtube.com/playlist?list=PLabc", 2)
+	if err != nil {
+		t.Fatal("ExpandPlaylist failed with error:", err)
+	}
+
+	expected := []string{"https://www.youtube.com/watch?v=SilKjJ0S904", "https://www.youtube.com/watch?v=aatr_2MstrI"}
+	if len(links) != len(expected) || links[0] != expected[0] || links[1] != expected[1] {
+		t.Errorf("Expected links %v but got %v", expected, links)
+	}
+}
+
+func TestYoutubeDlpSearch_leavesOutLiveStreams(t *testing.T) {
+	stubYtDlp(t, `{"id": "SilKjJ0S904", "title": "First Song", "duration": 180.0, "channel": "Some Channel"}
+{"id": "aatr_2MstrI", "title": "Live Radio", "live_status": "is_live"}
+{"id": "lMinM-FphYQ", "title": "Second Song", "duration": 200.0, "uploader": "Some Uploader"}`, 0)
+
+	songs, err := NewYoutubeProvider("").Search("some song", 3)
+	if err != nil {
+		t.Fatal("Search failed with error:", err)
+	}
+
+	if len(songs) != 2 || songs[0].ServiceId != "SilKjJ0S904" || songs[1].ServiceId != "lMinM-FphYQ" {
+		t.Fatalf("Expected the two songs that aren't live but got %v", songs)
+	}
+
+	if songs[0].Duration != 180 || songs[0].Metadata.Duration != "PT3M" || songs[0].Metadata.Uploader != "Some Channel" {
+		t.Errorf("Expected duration and channel from yt-dlp but got %d, %v", songs[0].Duration, songs[0].Metadata)
+	}
+
+	if songs[1].Metadata.Thumbnail != "https://i.ytimg.com/vi/lMinM-FphYQ/mqdefault.jpg" {
+		t.Errorf("Expected a thumbnail for the video but got %s", songs[1].Metadata.Thumbnail)
+	}
+}
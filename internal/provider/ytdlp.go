@@ -0,0 +1,104 @@
+/*
+ * Song data from the json that yt-dlp dumps about a link
+ */
+
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+var ErrLiveStream = errors.New("Live streams can't be added to the queue.")
+var ErrUpcomingStream = errors.New("Streams and premieres that haven't started yet can't be added to the queue.")
+
+/*
+ * The fields of yt-dlp's json output that songs are made from
+ */
+type ytDlpInfo struct {
+	Id         string  `json:"id"`
+	Title      string  `json:"title"`
+	Duration   float64 `json:"duration"`
+	Thumbnail  string  `json:"thumbnail"`
+	Uploader   string  `json:"uploader"`
+	Channel    string  `json:"channel"`
+	WebpageUrl string  `json:"webpage_url"`
+	IsLive     bool    `json:"is_live"`
+	LiveStatus string  `json:"live_status"`
+}
+
+/*
+ * Ask yt-dlp about the song behind the link. Live streams and streams that
+ * haven't started yet are refused since they can't be played to the end.
+ */
+func dumpJson(link string) (*ytDlpInfo, error) {
+	output, err := exec.Command("yt-dlp", "--dump-json", "--no-playlist", "--", link).Output()
+	if err != nil {
+		log.Printf("Failed to run yt-dlp on %s with error: %v", link, err)
+		return nil, errors.New("Failed to fetch song data")
+	}
+
+	info, err := parseDumpJson(output)
+	if err != nil {
+		log.Printf("Failed to parse yt-dlp output for %s: %v", link, err)
+		return nil, errors.New("Failed to fetch song data")
+	}
+
+	if err = info.checkLive(); err != nil {
+		log.Printf("Refused %s: %v", link, err)
+		return nil, err
+	}
+
+	return info, nil
+}
+
+/*
+ * Reads the json that yt-dlp dumps for a single song. A song needs a title.
+ */
+func parseDumpJson(output []byte) (*ytDlpInfo, error) {
+	info := new(ytDlpInfo)
+	if err := json.Unmarshal(output, info); err != nil {
+		return nil, err
+	}
+
+	info.Title = strings.TrimSpace(info.Title)
+	if info.Title == "" {
+		return nil, errors.New("yt-dlp did not find a title")
+	}
+
+	if info.Duration < 0 {
+		info.Duration = 0
+	}
+
+	return info, nil
+}
+
+/*
+ * Returns an error if the song is a stream that is live or hasn't started
+ */
+func (info *ytDlpInfo) checkLive() error {
+	switch {
+	case info.IsLive || info.LiveStatus == "is_live":
+		return ErrLiveStream
+
+	case info.LiveStatus == "is_upcoming":
+		return ErrUpcomingStream
+	}
+
+	return nil
+}
+
+/*
+ * Returns the name of the channel that posted the song, or of the uploader
+ * when the site has no channels
+ */
+func (info *ytDlpInfo) channel() string {
+	if info.Channel != "" {
+		return info.Channel
+	}
+
+	return info.Uploader
+}